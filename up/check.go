@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// roundtrip is one of the round trips checked by up check roundtrip.
+type roundtrip struct {
+	name string
+	run  func(a *App, data []byte, doc *up.Document) (*up.Document, error)
+}
+
+// roundtrips lists the round trips a document must survive unchanged.
+var roundtrips = []roundtrip{
+	{"format", (*App).formatRoundtrip},
+	{"json", (*App).jsonRoundtrip},
+}
+
+// handleCheckRoundtrip processes the check roundtrip command. Every input is
+// formatted and converted to JSON and back, and any semantic difference from
+// the original is reported with its key path.
+func (a *App) handleCheckRoundtrip(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no input files")
+	}
+	files, err := expandPaths(c.Args().Slice(), nil)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	var diags diagnosticError
+	for _, filename := range files {
+		ok, err := a.checkRoundtrips(c, filename)
+		var fileDiags diagnosticError
+		switch {
+		case errors.As(err, &fileDiags):
+			diags = append(diags, fileDiags...)
+		case err != nil:
+			fmt.Fprintf(c.App.ErrWriter, "%s: %v\n", filename, err)
+		}
+		if !ok || err != nil {
+			failed++
+		}
+	}
+
+	if len(diags) > 0 {
+		return diags
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) did not survive the round trip", failed, len(files))
+	}
+	return nil
+}
+
+// checkRoundtrips runs every round trip on a file, reporting the differences
+// found. It returns false if there were any.
+func (a *App) checkRoundtrips(c *cli.Context, filename string) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	doc, err := parseSource(filename, data)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	ok := true
+	for _, rt := range roundtrips {
+		result, err := rt.run(a, data, doc)
+		if err != nil {
+			fmt.Fprintf(a.output, "%s: %s: %v\n", filename, rt.name, err)
+			ok = false
+			continue
+		}
+		for _, change := range compareDocuments(doc, result) {
+			fmt.Fprintf(a.output, "%s: %s: %s\n", filename, rt.name, change)
+			ok = false
+		}
+	}
+
+	if ok && c.Bool("verbose") {
+		fmt.Fprintf(a.output, "%s: ok\n", filename)
+	}
+	return ok, nil
+}
+
+// formatRoundtrip formats the source as up format does and parses it again.
+func (a *App) formatRoundtrip(data []byte, _ *up.Document) (*up.Document, error) {
+	formatted, err := a.formatSource("", data, defaultFormatOptions)
+	if err != nil {
+		return nil, err
+	}
+	return readDocument(bytes.NewReader(formatted))
+}
+
+// jsonRoundtrip converts the source to JSON and back to UP through the
+// ordered object codecs up convert uses, and parses the result.
+func (a *App) jsonRoundtrip(source []byte, _ *up.Document) (*up.Document, error) {
+	objs, err := (upCodec{}).DecodeObjects(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	if err := (jsonCodec{}).EncodeObject(&encoded, objs[0], false); err != nil {
+		return nil, fmt.Errorf("failed to convert to JSON: %w", err)
+	}
+	decoded, err := (jsonCodec{}).DecodeObjects(&encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert from JSON: %w", err)
+	}
+
+	var written bytes.Buffer
+	if err := (upCodec{}).EncodeObject(&written, decoded[0], false); err != nil {
+		return nil, fmt.Errorf("failed to convert from JSON: %w", err)
+	}
+	return readDocument(&written)
+}
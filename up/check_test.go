@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckRoundtrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "lossless",
+			source: "# comment\nname app\nport!int 80\nratio!float 1.5\nserver {\n  hosts [\n    a\n    b\n  ]\n}\ntext ```\none\ntwo\n```\n",
+			want:   "FILE: ok\n",
+		},
+		{
+			name:   "big integer",
+			source: "max!int 18446744073709551615\nmin!int -9223372036854775808\n",
+			want:   "FILE: ok\n",
+		},
+		{
+			name:    "invalid int",
+			source:  "n!int x\n",
+			want:    "FILE: json: n: invalid int \"x\"\n",
+			wantErr: "1 of 1 file(s) did not survive the round trip",
+		},
+		{
+			name:    "annotations lost in JSON",
+			source:  "timeout!dur 5s\ncolor!rgb fff\n",
+			want:    "FILE: json: color: type annotation !rgb lost\nFILE: json: timeout: type annotation !dur lost\n",
+			wantErr: "1 of 1 file(s) did not survive the round trip",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := writeTestFile(t, "test.up", tt.source)
+			got, err := runApp(t, "", "check", "roundtrip", "--verbose", filename)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if want := strings.ReplaceAll(tt.want, "FILE", filename); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckRoundtripErrors(t *testing.T) {
+	if _, err := runApp(t, "", "check", "roundtrip"); err == nil || err.Error() != "no input files" {
+		t.Errorf("got error %v, want no input files", err)
+	}
+
+	filename := writeTestFile(t, "broken.up", "a {\n")
+	_, err := runApp(t, "", "check", "roundtrip", filepath.Dir(filename))
+	var diags diagnosticError
+	if !errors.As(err, &diags) || len(diags) != 1 || diags[0].File != filename || diags[0].Code != codeUnterminatedBlock {
+		t.Errorf("got error %v, want an unterminated block in %s", err, filename)
+	}
+}
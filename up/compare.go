@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	up "github.com/uplang/go"
+)
+
+// changeKind classifies a semantic difference between two documents.
+type changeKind string
+
+const (
+	// changeAdded is a key or list item present only in the second document.
+	changeAdded changeKind = "added"
+	// changeRemoved is a key or list item present only in the first document.
+	changeRemoved changeKind = "removed"
+	// changeValue is a value that differs between the documents.
+	changeValue changeKind = "changed"
+	// changeType is a type annotation that differs between the documents.
+	changeType changeKind = "type"
+	// changeOrder is a list holding the same items in a different order.
+	changeOrder changeKind = "reordered"
+)
+
+// change is a single semantic difference between two documents. Block key
+// order is not significant in UP, so it is never reported.
+type change struct {
+	Path string
+	// Pointer is the JSON Pointer (RFC 6901) form of Path.
+	Pointer string
+	Kind    changeKind
+	OldType string
+	NewType string
+	Old     up.Value
+	New     up.Value
+}
+
+// String describes the change.
+func (c change) String() string {
+	var msg string
+	switch c.Kind {
+	case changeAdded:
+		msg = "added " + describeValue(c.NewType, c.New)
+	case changeRemoved:
+		msg = "removed " + describeValue(c.OldType, c.Old)
+	case changeType:
+		switch {
+		case c.NewType == "":
+			msg = fmt.Sprintf("type annotation !%s lost", c.OldType)
+		case c.OldType == "":
+			msg = fmt.Sprintf("type annotation !%s added", c.NewType)
+		default:
+			msg = fmt.Sprintf("type annotation changed from !%s to !%s", c.OldType, c.NewType)
+		}
+	case changeOrder:
+		msg = "list items reordered"
+	default:
+		oldText, isOld := c.Old.(string)
+		newText, isNew := c.New.(string)
+		switch {
+		case isOld && isNew && (needsMultiline(oldText) || needsMultiline(newText)):
+			msg = "multiline string changed"
+		default:
+			msg = fmt.Sprintf("changed from %s to %s", describeValue(c.OldType, c.Old), describeValue(c.NewType, c.New))
+		}
+	}
+	if c.Path == "" {
+		return msg
+	}
+	return c.Path + ": " + msg
+}
+
+// describeValue summarizes a value for change messages.
+func describeValue(typ string, v up.Value) string {
+	var s string
+	switch value := v.(type) {
+	case up.Block:
+		s = fmt.Sprintf("block of %d key(s)", len(value))
+	case up.List:
+		s = fmt.Sprintf("list of %d item(s)", len(value))
+	case []any:
+		s = fmt.Sprintf("list of %d item(s)", len(value))
+	case string:
+		s = strconv.Quote(value)
+	default:
+		s = fmt.Sprintf("%v", value)
+	}
+	if typ != "" {
+		s += " (!" + typ + ")"
+	}
+	return s
+}
+
+// compareDocuments returns the semantic differences between two documents
+// read with readDocument, in key path order.
+func compareDocuments(a, b *up.Document) []change {
+	var changes []change
+	compareValues("", "", "", documentBlock(a), "", documentBlock(b), &changes)
+	return changes
+}
+
+// documentBlock collects the top-level nodes of a document into a block keyed
+// by their source form, as readDocument keys nested blocks.
+func documentBlock(doc *up.Document) up.Block {
+	block := make(up.Block, len(doc.Nodes))
+	for _, node := range doc.Nodes {
+		block[joinKey(node.Key, node.Type)] = node.Value
+	}
+	return block
+}
+
+// compareValues compares two values with their type annotations, appending
+// any differences. Dedent widths only shape how a multiline string is
+// written, so they do not count as annotations.
+func compareValues(path, ptr, typA string, a up.Value, typB string, b up.Value, changes *[]change) {
+	if isDedent(typA) {
+		typA = ""
+	}
+	if isDedent(typB) {
+		typB = ""
+	}
+
+	blockA, isBlockA := a.(up.Block)
+	blockB, isBlockB := b.(up.Block)
+	listA, isListA := listValue(a)
+	listB, isListB := listValue(b)
+
+	switch {
+	case isBlockA && isBlockB:
+		if typA != typB {
+			*changes = append(*changes, change{Path: path, Pointer: ptr, Kind: changeType, OldType: typA, NewType: typB, Old: a, New: b})
+		}
+		compareBlocks(path, ptr, blockA, blockB, changes)
+	case isListA && isListB:
+		if typA != typB {
+			*changes = append(*changes, change{Path: path, Pointer: ptr, Kind: changeType, OldType: typA, NewType: typB, Old: a, New: b})
+		}
+		compareLists(path, ptr, typA, listA, typB, listB, changes)
+	case isBlockA || isBlockB || isListA || isListB:
+		*changes = append(*changes, change{Path: path, Pointer: ptr, Kind: changeValue, OldType: typA, NewType: typB, Old: a, New: b})
+	default:
+		if !scalarsEqual(typA, a, typB, b) {
+			*changes = append(*changes, change{Path: path, Pointer: ptr, Kind: changeValue, OldType: typA, NewType: typB, Old: a, New: b})
+		} else if typA != typB {
+			*changes = append(*changes, change{Path: path, Pointer: ptr, Kind: changeType, OldType: typA, NewType: typB, Old: a, New: b})
+		}
+	}
+}
+
+// compareBlocks compares the entries of two blocks by key name.
+func compareBlocks(path, ptr string, a, b up.Block, changes *[]change) {
+	type entry struct {
+		typ   string
+		value up.Value
+	}
+	entries := func(block up.Block) map[string]entry {
+		m := make(map[string]entry, len(block))
+		for k, v := range block {
+			key, typ := splitKey(k)
+			m[key] = entry{typ, v}
+		}
+		return m
+	}
+	entriesA, entriesB := entries(a), entries(b)
+
+	keys := make([]string, 0, len(entriesA)+len(entriesB))
+	for key := range entriesA {
+		keys = append(keys, key)
+	}
+	for key := range entriesB {
+		if _, ok := entriesA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := keyPath(path, key)
+		childPtr := ptr + "/" + pointerToken(key)
+		ea, okA := entriesA[key]
+		eb, okB := entriesB[key]
+		switch {
+		case !okB:
+			*changes = append(*changes, change{Path: childPath, Pointer: childPtr, Kind: changeRemoved, OldType: ea.typ, Old: ea.value})
+		case !okA:
+			*changes = append(*changes, change{Path: childPath, Pointer: childPtr, Kind: changeAdded, NewType: eb.typ, New: eb.value})
+		default:
+			compareValues(childPath, childPtr, ea.typ, ea.value, eb.typ, eb.value, changes)
+		}
+	}
+}
+
+// compareLists compares two lists item by item. A list whose items differ
+// only in order is reported once as reordered. The list's annotation applies
+// to its scalar items. Removed items are reported from the end of the list,
+// so the changes can be applied in order.
+func compareLists(path, ptr, typA string, a up.List, typB string, b up.List, changes *[]change) {
+	if len(a) == len(b) && !reflect.DeepEqual(a, b) && sameItems(a, b) {
+		*changes = append(*changes, change{Path: path, Pointer: ptr, Kind: changeOrder, Old: a, New: b})
+		return
+	}
+
+	item := func(i int) (string, string) {
+		return fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("%s/%d", ptr, i)
+	}
+	for i := 0; i < min(len(a), len(b)); i++ {
+		itemPath, itemPtr := item(i)
+		var itemChanges []change
+		compareValues(itemPath, itemPtr, typA, a[i], typB, b[i], &itemChanges)
+		for _, c := range itemChanges {
+			// The list's own annotation change is reported once.
+			if c.Path != itemPath || c.Kind != changeType {
+				*changes = append(*changes, c)
+			}
+		}
+	}
+	for i := len(a); i < len(b); i++ {
+		itemPath, itemPtr := item(i)
+		*changes = append(*changes, change{Path: itemPath, Pointer: itemPtr, Kind: changeAdded, NewType: typB, New: b[i]})
+	}
+	for i := len(a) - 1; i >= len(b); i-- {
+		itemPath, itemPtr := item(i)
+		*changes = append(*changes, change{Path: itemPath, Pointer: itemPtr, Kind: changeRemoved, OldType: typA, Old: a[i]})
+	}
+}
+
+// sameItems reports whether two lists hold the same items regardless of
+// order.
+func sameItems(a, b up.List) bool {
+	used := make([]bool, len(b))
+	for _, x := range a {
+		found := false
+		for j, y := range b {
+			if !used[j] && reflect.DeepEqual(x, y) {
+				used[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// listValue returns a value as a list. Inline lists nested in lists are
+// []any rather than up.List.
+func listValue(v up.Value) (up.List, bool) {
+	switch value := v.(type) {
+	case up.List:
+		return value, true
+	case []any:
+		list := make(up.List, len(value))
+		for i, item := range value {
+			list[i] = item
+		}
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+// scalarsEqual reports whether two scalars have the same value. Numbers and
+// booleans are compared by value, so "1.50" equals "1.5" when either side is
+// annotated as a float.
+func scalarsEqual(typA string, a up.Value, typB string, b up.Value) bool {
+	sa, okA := a.(string)
+	sb, okB := b.(string)
+	if !okA || !okB {
+		return reflect.DeepEqual(a, b)
+	}
+	if sa == sb {
+		return true
+	}
+
+	switch {
+	case typA == "int" || typB == "int":
+		x, errA := strconv.ParseInt(sa, 10, 64)
+		y, errB := strconv.ParseInt(sb, 10, 64)
+		if errA == nil && errB == nil {
+			return x == y
+		}
+		fallthrough
+	case typA == "float" || typB == "float":
+		x, errA := strconv.ParseFloat(sa, 64)
+		y, errB := strconv.ParseFloat(sb, 64)
+		return errA == nil && errB == nil && x == y
+	case typA == "bool" || typB == "bool":
+		x, errA := strconv.ParseBool(sa)
+		y, errB := strconv.ParseBool(sb)
+		return errA == nil && errB == nil && x == y
+	}
+	return false
+}
+
+// pointerToken escapes a key for use in a JSON Pointer.
+func pointerToken(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
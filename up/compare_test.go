@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{
+			name: "key order is not significant",
+			a:    "a 1\nb {\n  c 2\n  d 3\n}\n",
+			b:    "b {\n  d 3\n  c 2\n}\na 1\n",
+		},
+		{
+			name: "numbers compare by value",
+			a:    "f!float 1.50\ni!int 007\nb!bool true\n",
+			b:    "f!float 1.5\ni!int 7\nb!bool TRUE\n",
+		},
+		{
+			name: "dedent width is not an annotation",
+			a:    "t!2 ```\n  x\n```\n",
+			b:    "t ```\nx\n```\n",
+		},
+		{
+			name: "added and removed keys",
+			a:    "a 1\nb {\n  c 2\n}\n",
+			b:    "b {\n}\nz!int 3\n",
+			want: []string{"/a a: removed \"1\"", "/b/c b.c: removed \"2\"", "/z z: added \"3\" (!int)"},
+		},
+		{
+			name: "changed values and annotations",
+			a:    "a 1\nb!int 2\nc x\nm ```\none\ntwo\n```\n",
+			b:    "a 2\nb 2\nc {\n  x y\n}\nm ```\none\n2\n```\n",
+			want: []string{
+				`/a a: changed from "1" to "2"`,
+				"/b b: type annotation !int lost",
+				`/c c: changed from "x" to block of 1 key(s)`,
+				"/m m: multiline string changed",
+			},
+		},
+		{
+			name: "lists",
+			a:    "l!int [\n  1\n  2\n  3\n]\nr [\n  a\n  b\n]\n",
+			b:    "l!float [\n  1\n  5\n]\nr [\n  b\n  a\n]\n",
+			want: []string{
+				"/l l: type annotation changed from !int to !float",
+				`/l/1 l[1]: changed from "2" (!int) to "5" (!float)`,
+				`/l/2 l[2]: removed "3" (!int)`,
+				"/r r: list items reordered",
+			},
+		},
+		{
+			name: "pointer escaping",
+			a:    "a/b~c 1\n",
+			b:    "a/b~c 2\n",
+			want: []string{`/a~1b~0c a/b~c: changed from "1" to "2"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := parseSource("a.up", []byte(tt.a))
+			if err != nil {
+				t.Fatalf("failed to parse a: %v", err)
+			}
+			b, err := parseSource("b.up", []byte(tt.b))
+			if err != nil {
+				t.Fatalf("failed to parse b: %v", err)
+			}
+			var got []string
+			for _, c := range compareDocuments(a, b) {
+				got = append(got, c.Pointer+" "+c.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// codec reads and writes documents in one serialization format.
+type codec interface {
+	// Decode reads a document in the codec's format.
+	Decode(r io.Reader) (*up.Document, error)
+	// Encode writes a document in the codec's format.
+	Encode(w io.Writer, doc *up.Document, pretty bool) error
+	// DecodeObjects reads every document of the input as an ordered
+	// object, keeping the key order of nested mappings that up.Block loses.
+	DecodeObjects(r io.Reader) ([]object, error)
+	// EncodeObject writes an ordered object in the codec's format.
+	EncodeObject(w io.Writer, obj object, pretty bool) error
+}
+
+// codecs maps format names to their codecs.
+var codecs = map[string]codec{
+	"up":   upCodec{},
+	"json": jsonCodec{},
+	"yaml": yamlCodec{},
+	"toml": tomlCodec{},
+}
+
+// extensionFormats maps file extensions to format names.
+var extensionFormats = map[string]string{
+	".up":   "up",
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".toml": "toml",
+}
+
+// lookupCodec returns the codec for a format name.
+func lookupCodec(format string) (codec, error) {
+	c, ok := codecs[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(codecNames(), ", "))
+	}
+	return c, nil
+}
+
+// codecNames returns the sorted names of all registered formats.
+func codecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleConvert processes the convert command.
+func (a *App) handleConvert(c *cli.Context) error {
+	if c.Bool("split") && c.String("output") == "" {
+		return fmt.Errorf("--split requires --output")
+	}
+
+	input, err := a.getInput(c.String("input"))
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	defer a.closeIfFile(input)
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	from := c.String("from")
+	if from == "" {
+		var reason string
+		from, reason, err = detectFormat(c.String("input"), data)
+		if err != nil {
+			return err
+		}
+		if c.Bool("verbose") {
+			fmt.Fprintf(c.App.ErrWriter, "detected input format %s (%s)\n", from, reason)
+		}
+	}
+
+	decoder, err := lookupCodec(from)
+	if err != nil {
+		return err
+	}
+	encoder, err := lookupCodec(c.String("to"))
+	if err != nil {
+		return err
+	}
+
+	// Documents are converted as ordered objects so that nested keys keep
+	// their order.
+	objs, err := decoder.DecodeObjects(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", from, withSource(err, inputName(c.String("input")), data))
+	}
+
+	if len(objs) == 1 || !c.Bool("split") {
+		return a.writeConvertedObject(c.String("output"), encoder, combineObjects(objs), c.Bool("pretty"))
+	}
+	for i, obj := range objs {
+		if err := a.writeConvertedObject(numberedPath(c.String("output"), i+1), encoder, obj, c.Bool("pretty")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// combineObjects returns the only document of a stream, or a single
+// document holding every document of the stream in a "documents" list.
+func combineObjects(objs []object) object {
+	if len(objs) == 1 {
+		return objs[0]
+	}
+
+	list := make([]any, len(objs))
+	for i, obj := range objs {
+		list[i] = obj
+	}
+	return object{{Key: "documents", Value: list}}
+}
+
+// numberedPath inserts a document number before the extension of a path,
+// so that "out.up" becomes "out-2.up".
+func numberedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// writeConverted encodes a document and writes it to the output. Encoding
+// happens before the output is opened so that a failed conversion never
+// leaves a truncated file behind.
+func (a *App) writeConverted(filename string, encoder codec, doc *up.Document, pretty bool) error {
+	return a.writeEncoded(filename, func(w io.Writer) error {
+		return encoder.Encode(w, doc, pretty)
+	})
+}
+
+// writeConvertedObject encodes an ordered object and writes it to the
+// output, like writeConverted.
+func (a *App) writeConvertedObject(filename string, encoder codec, obj object, pretty bool) error {
+	return a.writeEncoded(filename, func(w io.Writer) error {
+		return encoder.EncodeObject(w, obj, pretty)
+	})
+}
+
+// writeEncoded writes what encode produces to the output.
+func (a *App) writeEncoded(filename string, encode func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputName(filename), err)
+	}
+
+	output, err := a.getOutput(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+
+	if _, err := buf.WriteTo(output); err != nil {
+		output.Abort()
+		return err
+	}
+	return output.Close()
+}
+
+// upCodec reads and writes UP documents.
+type upCodec struct{}
+
+// Decode reads a UP document, keeping nested type annotations.
+func (upCodec) Decode(r io.Reader) (*up.Document, error) {
+	return readDocument(r)
+}
+
+// Encode writes a UP document.
+func (upCodec) Encode(w io.Writer, doc *up.Document, _ bool) error {
+	return writeUP(w, doc)
+}
+
+// DecodeObjects reads a UP document as an ordered object, turning annotated
+// scalars into typed values.
+func (upCodec) DecodeObjects(r io.Reader) ([]object, error) {
+	root, err := parseSyntax(r)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := valueFromSyntax("", "", root)
+	if err != nil {
+		return nil, err
+	}
+	return []object{obj.(object)}, nil
+}
+
+// EncodeObject writes an ordered object as a UP document.
+func (upCodec) EncodeObject(w io.Writer, obj object, _ bool) error {
+	root, err := syntaxFromNeutral("", "", obj)
+	if err != nil {
+		return err
+	}
+	return formatSyntax(w, root, defaultFormatOptions)
+}
+
+// object is an ordered mapping, the format-neutral form used between a codec
+// and a UP document.
+type object []member
+
+// member is a single key-value pair of an object.
+type member struct {
+	Key   string
+	Value any
+}
+
+// annotatedScalar is a UP scalar whose type annotation has no equivalent in
+// other formats, such as a custom type or a multiline dedent width. Other
+// formats write it as a string; UP keeps the annotation.
+type annotatedScalar struct {
+	Type string
+	Text string
+}
+
+// String returns the text of the scalar.
+func (s annotatedScalar) String() string {
+	return s.Text
+}
+
+// MarshalJSON writes the scalar as a JSON string.
+func (s annotatedScalar) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Text)
+}
+
+// documentFromObject converts a decoded mapping into a UP document. Nested
+// mappings become up.Block, sequences become up.List, and scalar types are
+// kept as type annotations.
+func documentFromObject(obj object) (*up.Document, error) {
+	doc := &up.Document{Nodes: make([]up.Node, 0, len(obj))}
+	for _, m := range obj {
+		if err := checkKey(m.Key, m.Key); err != nil {
+			return nil, err
+		}
+		typ, value, err := fromValue(m.Key, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		doc.Nodes = append(doc.Nodes, up.Node{Key: m.Key, Type: typ, Value: value})
+	}
+	return doc, nil
+}
+
+// fromValue converts a decoded value into a UP value and its type annotation.
+func fromValue(path string, v any) (string, up.Value, error) {
+	switch v := v.(type) {
+	case object:
+		block := make(up.Block, len(v))
+		for _, m := range v {
+			childPath := path + "." + m.Key
+			if err := checkKey(childPath, m.Key); err != nil {
+				return "", nil, err
+			}
+			typ, value, err := fromValue(childPath, m.Value)
+			if err != nil {
+				return "", nil, err
+			}
+			block[joinKey(m.Key, typ)] = value
+		}
+		return "", block, nil
+	case []any:
+		return fromList(path, v)
+	case string:
+		if err := checkMultiline(path, v); err != nil {
+			return "", nil, err
+		}
+		return "", v, nil
+	case bool:
+		return "bool", strconv.FormatBool(v), nil
+	case nil:
+		return "null", "null", nil
+	case json.Number:
+		return fromNumber(path, v)
+	case int:
+		return "int", strconv.Itoa(v), nil
+	case int64:
+		return "int", strconv.FormatInt(v, 10), nil
+	case uint64:
+		return "int", strconv.FormatUint(v, 10), nil
+	case float64:
+		return "float", strconv.FormatFloat(v, 'g', -1, 64), nil
+	case timestamp:
+		return "ts", string(v), nil
+	case annotatedScalar:
+		if err := checkMultiline(path, v.Text); err != nil {
+			return "", nil, err
+		}
+		return v.Type, v.Text, nil
+	case time.Time:
+		return "ts", v.Format(time.RFC3339Nano), nil
+	default:
+		return "", nil, fmt.Errorf("%s: unsupported value of type %T", path, v)
+	}
+}
+
+// fromNumber annotates a decoded number. Integers that fit in an int64 or a
+// uint64 keep their exact text; anything else must be a finite float.
+func fromNumber(path string, n json.Number) (string, up.Value, error) {
+	s := n.String()
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return "int", s, nil
+	}
+	if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return "int", s, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", nil, fmt.Errorf("%s: number %s is out of range", path, s)
+	}
+	return "float", s, nil
+}
+
+// fromList converts a decoded sequence into a UP list. List items cannot
+// carry their own annotation, so the type the scalars in the list share
+// annotates the list itself; see listType.
+func fromList(path string, items []any) (string, up.Value, error) {
+	list := make(up.List, 0, len(items))
+	types := make(map[string]bool)
+
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		typ, value, err := fromValue(itemPath, item)
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch value := value.(type) {
+		case up.Block:
+			list = append(list, value)
+		case up.List:
+			for j, inner := range value {
+				s, ok := inner.(string)
+				if !ok || !inlineItemOK(s) {
+					return "", nil, fmt.Errorf("%s[%d]: nested list items must be simple scalars", itemPath, j)
+				}
+			}
+			if len(value) > 0 {
+				types[typ] = true
+			}
+			list = append(list, value)
+		case string:
+			if !listItemOK(value) {
+				return "", nil, fmt.Errorf("%s: value %q cannot be written as a UP list item", itemPath, value)
+			}
+			types[typ] = true
+			list = append(list, value)
+		}
+	}
+
+	typ, err := listType(path, types)
+	if err != nil {
+		return "", nil, err
+	}
+	return typ, list, nil
+}
+
+// listType returns the annotation of a list whose scalar items have the
+// given annotations. A list of integers and floats is a list of floats. A
+// list mixing other types cannot be represented, as the annotation of a
+// list applies to all its items.
+func listType(path string, types map[string]bool) (string, error) {
+	if len(types) == 2 && types["int"] && types["float"] {
+		return "float", nil
+	}
+	if len(types) > 1 {
+		names := make([]string, 0, len(types))
+		for typ := range types {
+			if typ == "" {
+				typ = "string"
+			}
+			names = append(names, typ)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("%s: cannot represent a list mixing %s items in UP", path, strings.Join(names, ", "))
+	}
+	for typ := range types {
+		return typ, nil
+	}
+	return "", nil
+}
+
+// syntaxFromNeutral builds the syntax node for a format-neutral value,
+// keeping the order of object members. The root object has an empty path
+// and key.
+func syntaxFromNeutral(path, key string, v any) (*syntaxNode, error) {
+	switch v := v.(type) {
+	case object:
+		node := &syntaxNode{Key: key, Kind: blockSyntax}
+		for _, m := range v {
+			childPath := keyPath(path, m.Key)
+			if err := checkKey(childPath, m.Key); err != nil {
+				return nil, err
+			}
+			child, err := syntaxFromNeutral(childPath, m.Key, m.Value)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+	case []any:
+		// fromList checks the items and resolves the list's annotation.
+		typ, _, err := fromList(path, v)
+		if err != nil {
+			return nil, err
+		}
+		node := &syntaxNode{Key: key, Type: typ, Kind: listSyntax}
+		for i, item := range v {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			var child *syntaxNode
+			if _, ok := item.(object); ok {
+				child, err = syntaxFromNeutral(itemPath, "", item)
+			} else {
+				var value up.Value
+				if _, value, err = fromValue(itemPath, item); err == nil {
+					child = syntaxFromItem(value)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+	default:
+		typ, value, err := fromValue(path, v)
+		if err != nil {
+			return nil, err
+		}
+		return syntaxFromValue(key, typ, value), nil
+	}
+}
+
+// syntaxFromNeutralItem builds the syntax node for a list item.
+func syntaxFromNeutralItem(path string, v any) (*syntaxNode, error) {
+	if _, ok := v.(object); ok {
+		return syntaxFromNeutral(path, "", v)
+	}
+	_, value, err := fromValue(path, v)
+	if err != nil {
+		return nil, err
+	}
+	return syntaxFromItem(value), nil
+}
+
+// valueFromSyntax converts a syntax node into a format-neutral value, in
+// the order of its entries. typ is the annotation of the node, or of the
+// list holding it.
+func valueFromSyntax(path, typ string, node *syntaxNode) (any, error) {
+	switch node.Kind {
+	case blockSyntax:
+		obj := make(object, 0, len(node.Children))
+		for _, child := range node.Children {
+			value, err := valueFromSyntax(keyPath(path, child.Key), child.Type, child)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{Key: child.Key, Value: value})
+		}
+		return obj, nil
+	case listSyntax:
+		items := make([]any, len(node.Children))
+		for i, child := range node.Children {
+			value, err := valueFromSyntax(fmt.Sprintf("%s[%d]", path, i), node.Type, child)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+	case inlineListSyntax:
+		items := make([]any, len(node.Items))
+		for i, item := range node.Items {
+			value, err := neutralScalar(fmt.Sprintf("%s[%d]", path, i), typ, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+	case multilineSyntax:
+		text := strings.Join(node.Lines, "\n")
+		if n, ok := dedentWidth(typ); ok {
+			return neutralScalar(path, typ, dedent(text, n))
+		}
+		return neutralScalar(path, typ, text)
+	default:
+		return neutralScalar(path, typ, node.Text)
+	}
+}
+
+// neutralScalar converts a scalar according to its type annotation, keeping
+// annotations other formats cannot express as an annotatedScalar.
+func neutralScalar(path, typ, s string) (any, error) {
+	switch typ {
+	case "", "string", "int", "float", "bool", "null", "ts":
+		return scalarValue(path, typ, s)
+	default:
+		return annotatedScalar{Type: typ, Text: s}, nil
+	}
+}
+
+// checkKey reports an error when a key cannot be written as a UP key.
+func checkKey(path, key string) error {
+	if key == "" || strings.ContainsAny(key, " \t\r\n!") || strings.ContainsAny(key[:1], "#{}[]`") {
+		return fmt.Errorf("%s: key %q cannot be represented in UP", path, key)
+	}
+	return nil
+}
+
+// checkMultiline reports an error when a string contains a line that would
+// terminate a multiline value early.
+func checkMultiline(path, s string) error {
+	if !needsMultiline(s) {
+		return nil
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "```" {
+			return fmt.Errorf("%s: multiline value contains a ``` line", path)
+		}
+	}
+	return nil
+}
+
+// listItemOK reports whether a string survives being written as a list item.
+func listItemOK(s string) bool {
+	return s != "" && !needsMultiline(s) && !strings.ContainsAny(s[:1], "#{[") && s != "]"
+}
+
+// inlineItemOK reports whether a string survives being written inside an
+// inline [a, b] list.
+func inlineItemOK(s string) bool {
+	return s != "" && s == strings.TrimSpace(s) && !strings.ContainsAny(s, ",[]\n")
+}
+
+// documentToObject converts a UP document into a format-neutral mapping,
+// turning annotated scalars into typed values.
+func documentToObject(doc *up.Document) (object, error) {
+	obj := make(object, 0, len(doc.Nodes))
+	for _, node := range doc.Nodes {
+		value, err := toValue(node.Key, node.Type, node.Value)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, member{Key: node.Key, Value: value})
+	}
+	return obj, nil
+}
+
+// toValue converts a UP value with its type annotation into a typed value.
+// Block keys are emitted in sorted order because up.Block does not keep the
+// source order; valueFromSyntax keeps it.
+func toValue(path, typ string, v up.Value) (any, error) {
+	switch v := v.(type) {
+	case up.Block:
+		obj := make(object, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			key, keyType := splitKey(k)
+			value, err := toValue(path+"."+key, keyType, v[k])
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{Key: key, Value: value})
+		}
+		return obj, nil
+	case map[string]any:
+		block := make(up.Block, len(v))
+		for k, item := range v {
+			block[k] = item
+		}
+		return toValue(path, typ, block)
+	case up.List:
+		items := make([]any, len(v))
+		for i, item := range v {
+			value, err := toValue(fmt.Sprintf("%s[%d]", path, i), typ, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+	case []any:
+		list := make(up.List, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+		return toValue(path, typ, list)
+	case string:
+		return scalarValue(path, typ, v)
+	default:
+		return v, nil
+	}
+}
+
+// scalarValue converts a scalar string according to its type annotation.
+func scalarValue(path, typ, s string) (any, error) {
+	switch typ {
+	case "int":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid int %q", path, s)
+		}
+		return u, nil
+	case "float":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid float %q", path, s)
+		}
+		return f, nil
+	case "bool":
+		switch s {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%s: invalid bool %q", path, s)
+	case "null":
+		return nil, nil
+	case "ts":
+		if !validTimestamp(s) {
+			return nil, fmt.Errorf("%s: invalid ts %q", path, s)
+		}
+		return timestamp(s), nil
+	default:
+		return s, nil
+	}
+}
+
+// keyPath appends a key to a dotted key path.
+func keyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// sortedKeys returns the keys of a block in sorted order.
+func sortedKeys(b up.Block) []string {
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// timestamp is a date or time kept in its source text, so that converting
+// between formats does not change how it is written.
+type timestamp string
+
+// timestampLayouts lists the layouts accepted for !ts values.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+// validTimestamp reports whether s matches one of the timestamp layouts.
+func validTimestamp(s string) bool {
+	for _, layout := range timestampLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"scalars", `{"s":"text","i":42,"f":1.5,"b":true,"n":null}`},
+		{"key order", `{"z":1,"a":{"y":2,"b":{"q":"x","p":"y"}},"m":3}`},
+		{"nested lists", `{"l":[[1,2],[3]],"e":[]}`},
+		{"list of objects", `{"users":[{"name":"b","id":2},{"name":"a","id":1}]}`},
+		{"numbers", `{"l":[1,2.5],"neg":-7,"exp":1e+21}`},
+		{"big integers", `{"max":18446744073709551615,"min":-9223372036854775808,"l":[12345678901234567890]}`},
+		{"multiline", `{"text":"line one\nline two","padded":"  x  "}`},
+		{"empty object", `{"o":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upText, err := runApp(t, tt.json, "convert", "--from", "json", "--to", "up")
+			if err != nil {
+				t.Fatalf("json to up: %v", err)
+			}
+			back, err := runApp(t, upText, "convert", "--from", "up", "--to", "json")
+			if err != nil {
+				t.Fatalf("up to json: %v\n%s", err, upText)
+			}
+			if got := strings.TrimSpace(back); got != tt.json {
+				t.Errorf("round trip through:\n%s\ngot  %s\nwant %s", upText, got, tt.json)
+			}
+		})
+	}
+}
+
+func TestConvertKeepsKeyOrder(t *testing.T) {
+	got, err := runApp(t, `{"z":1,"a":{"y":true,"b":"x"}}`, "convert", "--from", "json", "--to", "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "z!int 1\na {\n  y!bool true\n  b x\n}\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConvertUPKeepsAnnotations(t *testing.T) {
+	input := "color!rgb #fff\ntext!2 ```\n  hi\n    there\n```\nblock {\n  z 1\n  a!int 2\n}\n"
+	got, err := runApp(t, input, "convert", "--from", "up", "--to", "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != input {
+		t.Errorf("got:\n%s\nwant:\n%s", got, input)
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		args  []string
+		want  string
+	}{
+		{"mixed list", `{"l":[1,"a",true]}`, []string{"--from", "json", "--to", "up"}, "cannot represent a list mixing bool, int, string items"},
+		{"key with space", `{"a b":1}`, []string{"--from", "json", "--to", "up"}, `key "a b" cannot be represented`},
+		{"fence in multiline", `{"t":"a\n` + "```" + `\nb"}`, []string{"--from", "json", "--to", "up"}, "multiline value contains a ``` line"},
+		{"not an object", `[1]`, []string{"--from", "json", "--to", "up"}, "top-level value must be an object"},
+		{"trailing data", `{} {}`, []string{"--from", "json", "--to", "up"}, "unexpected data after top-level value"},
+		{"infinite number", `{"n":1e400}`, []string{"--from", "json", "--to", "up"}, "n: number 1e400 is out of range"},
+		{"invalid int", "n!int x\n", []string{"--from", "up", "--to", "json"}, `invalid int "x"`},
+		{"unknown format", `{}`, []string{"--from", "json", "--to", "xml"}, `unsupported format "xml"`},
+		{"stdout", `{"l":[1,"a"]}`, []string{"--from", "json", "--to", "up"}, "failed to write <stdout>: l:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, tt.input, append([]string{"convert"}, tt.args...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertFailureLeavesNoOutput(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.up")
+	if _, err := runApp(t, `{"l":[1,"a"]}`, "convert", "--from", "json", "--to", "up", "-o", output); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output file exists after a failed conversion")
+	}
+}
+
+func TestJSONCodecDocument(t *testing.T) {
+	doc, err := (jsonCodec{}).Decode(strings.NewReader(`{"port":8080,"tags":["a","b"],"on":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := (jsonCodec{}).Encode(&buf, doc, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(buf.String()), `{"port":8080,"tags":["a","b"],"on":false}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestListType(t *testing.T) {
+	tests := []struct {
+		types []string
+		want  string
+		err   bool
+	}{
+		{types: nil, want: ""},
+		{types: []string{""}, want: ""},
+		{types: []string{"int"}, want: "int"},
+		{types: []string{"int", "float"}, want: "float"},
+		{types: []string{"int", ""}, err: true},
+		{types: []string{"bool", "null"}, err: true},
+	}
+	for _, tt := range tests {
+		types := make(map[string]bool)
+		for _, typ := range tt.types {
+			types[typ] = true
+		}
+		got, err := listType("l", types)
+		if tt.err != (err != nil) || got != tt.want {
+			t.Errorf("listType(%v) = %q, %v; want %q, error %v", tt.types, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	up "github.com/uplang/go"
+)
+
+// syntaxKind identifies the form of a syntax node's value.
+type syntaxKind int
+
+const (
+	// scalarSyntax is a single-line value.
+	scalarSyntax syntaxKind = iota
+	// multilineSyntax is a triple-backtick string.
+	multilineSyntax
+	// blockSyntax is a { ... } block, or the document itself.
+	blockSyntax
+	// listSyntax is a [ ... ] list.
+	listSyntax
+	// inlineListSyntax is an inline [a, b] list item.
+	inlineListSyntax
+	// conflictSyntax is an unresolved merge conflict between two versions
+	// of an entry. It never comes from parsing.
+	conflictSyntax
+)
+
+// syntaxNode is a node of the concrete syntax tree of a UP document. Unlike
+// up.Document it keeps the order of entries, comments and blank lines, so a
+// document can be written back without losing anything but insignificant
+// whitespace. Block entries carry a Key; list items do not.
+type syntaxNode struct {
+	// Comments holds the comment lines before the node, trimmed, with ""
+	// standing for a blank line.
+	Comments []string
+	Key      string
+	Type     string
+	Kind     syntaxKind
+	// Text is the value of a scalar.
+	Text string
+	// Fence is whatever follows the opening ``` of a multiline string.
+	Fence string
+	// Lines holds the raw body lines of a multiline string.
+	Lines []string
+	// Children holds the entries of a block or the items of a list. For a
+	// conflict it holds our and their version, either of which may be nil.
+	Children []*syntaxNode
+	// Items holds the items of an inline list, or the three marker lines of
+	// a conflict.
+	Items []string
+	// Trailer holds the comment and blank lines before the closing } or ],
+	// or at the end of the document.
+	Trailer []string
+	// Line is the 1-based line the node starts on.
+	Line int
+	// EndLine is the 1-based line the node ends on, including the closing
+	// brace, bracket or fence.
+	EndLine int
+}
+
+// parseSyntax reads a UP document into a syntax tree whose root is a block
+// node holding the top-level entries. It accepts exactly the documents
+// readDocument accepts, and reports unterminated blocks, lists and strings,
+// stray closing brackets and malformed keys as a diagnosticError.
+func parseSyntax(r io.Reader) (*syntaxNode, error) {
+	sr := &syntaxReader{scanner: bufio.NewScanner(r)}
+	root := &syntaxNode{Kind: blockSyntax, Line: 1}
+	sr.readEntries(root, false)
+	root.EndLine = sr.line
+	if err := sr.scanner.Err(); err != nil {
+		sr.problems = append(sr.problems, diagnostic{Line: sr.line + 1, Severity: severityError, Code: codeReadError, Message: err.Error()})
+	}
+	if len(sr.problems) > 0 {
+		// Enclosing constructs are reported after their contents; restore
+		// source order.
+		sort.SliceStable(sr.problems, func(i, j int) bool { return sr.problems[i].Line < sr.problems[j].Line })
+		return nil, sr.problems
+	}
+	return root, nil
+}
+
+// syntaxReader tracks the scanner state while reading a syntax tree.
+type syntaxReader struct {
+	scanner *bufio.Scanner
+	line    int
+	// raw is the current line as read.
+	raw      string
+	problems diagnosticError
+}
+
+// next returns the next raw line.
+func (sr *syntaxReader) next() (string, bool) {
+	if !sr.scanner.Scan() {
+		return "", false
+	}
+	sr.line++
+	sr.raw = sr.scanner.Text()
+	return sr.raw, true
+}
+
+// problem records a problem with the text of the current line from start
+// to end.
+func (sr *syntaxReader) problem(start, end int, code, format string, args ...any) {
+	sr.problems = append(sr.problems, spanDiagnostic(sr.line, sr.raw, start, end, code, format, args...))
+}
+
+// unterminated records a construct opened by token on a line that was
+// still open at the end of the input.
+func (sr *syntaxReader) unterminated(line int, raw, token, code, what, closer string) {
+	start := strings.LastIndex(raw, token)
+	sr.problems = append(sr.problems, spanDiagnostic(line, raw, start, start+len(token), code, "unterminated %s: missing %s", what, closer))
+}
+
+// readEntries reads statements into a block node until its closing brace,
+// or until the end of input for the document itself. It reports whether the
+// closing brace was found.
+func (sr *syntaxReader) readEntries(block *syntaxNode, nested bool) bool {
+	var comments []string
+	closed := false
+	for {
+		raw, ok := sr.next()
+		if !ok {
+			break
+		}
+		line := strings.TrimSpace(raw)
+		if nested && line == "}" {
+			closed = true
+			break
+		}
+		if skipLine(line) {
+			comments = append(comments, line)
+			continue
+		}
+
+		node := sr.readStatement(line)
+		node.Comments = comments
+		comments = nil
+		block.Children = append(block.Children, node)
+	}
+	block.Trailer = comments
+	return closed
+}
+
+// readStatement reads a key-value statement starting at line.
+func (sr *syntaxReader) readStatement(line string) *syntaxNode {
+	keyPart, valPart := splitStatement(line)
+	key, typ := splitKey(keyPart)
+	node := &syntaxNode{Key: key, Type: typ, Line: sr.line}
+	raw, start := sr.raw, strings.Index(sr.raw, keyPart)
+
+	switch {
+	case keyPart == "}" || keyPart == "]":
+		sr.problem(start, start+1, codeUnexpectedClose, "unexpected %s", keyPart)
+	case key == "":
+		sr.problem(start, start+len(keyPart), codeInvalidKey, "missing key before type annotation")
+	case strings.HasSuffix(keyPart, "!"):
+		sr.problem(start, start+len(keyPart), codeInvalidKey, "empty type annotation")
+	}
+
+	switch {
+	case strings.HasPrefix(valPart, "```"):
+		node.Kind = multilineSyntax
+		node.Fence = strings.TrimPrefix(valPart, "```")
+		var closed bool
+		if node.Lines, closed = sr.readMultiline(); !closed {
+			sr.unterminated(node.Line, raw, "```", codeUnterminatedString, "multiline string", "closing ```")
+		}
+	case valPart == "{":
+		node.Kind = blockSyntax
+		if !sr.readEntries(node, true) {
+			sr.unterminated(node.Line, raw, "{", codeUnterminatedBlock, "block", "}")
+		}
+	case valPart == "[":
+		node.Kind = listSyntax
+		if !sr.readItems(node) {
+			sr.unterminated(node.Line, raw, "[", codeUnterminatedList, "list", "]")
+		}
+	default:
+		node.Kind = scalarSyntax
+		node.Text = valPart
+	}
+	node.EndLine = sr.line
+	return node
+}
+
+// readMultiline reads the raw body lines of a multiline string. It reports
+// whether the closing fence was found.
+func (sr *syntaxReader) readMultiline() ([]string, bool) {
+	var lines []string
+	for {
+		raw, ok := sr.next()
+		if !ok {
+			return lines, false
+		}
+		if strings.TrimSpace(raw) == "```" {
+			return lines, true
+		}
+		lines = append(lines, raw)
+	}
+}
+
+// readItems reads the items of a list node until its closing bracket. It
+// reports whether the closing bracket was found.
+func (sr *syntaxReader) readItems(list *syntaxNode) bool {
+	var comments []string
+	closed := false
+	for {
+		raw, ok := sr.next()
+		if !ok {
+			break
+		}
+		line := strings.TrimSpace(raw)
+		if line == "]" {
+			closed = true
+			break
+		}
+		if skipLine(line) {
+			comments = append(comments, line)
+			continue
+		}
+
+		item := &syntaxNode{Comments: comments, Line: sr.line}
+		comments = nil
+		switch {
+		case strings.HasPrefix(line, "{"):
+			item.Kind = blockSyntax
+			if !sr.readEntries(item, true) {
+				sr.unterminated(item.Line, raw, "{", codeUnterminatedBlock, "block", "}")
+			}
+		case strings.HasPrefix(line, "["):
+			item.Kind = inlineListSyntax
+			for _, v := range parseInlineList(line) {
+				item.Items = append(item.Items, v.(string))
+			}
+		case line == "}":
+			start := strings.Index(raw, "}")
+			sr.problem(start, start+1, codeUnexpectedClose, "unexpected }")
+		default:
+			item.Kind = scalarSyntax
+			item.Text = line
+		}
+		item.EndLine = sr.line
+		list.Children = append(list.Children, item)
+	}
+	list.Trailer = comments
+	return closed
+}
+
+// syntaxFromDocument builds a syntax tree for a document. Block entries are
+// placed in sorted key order, as up.Block does not keep the source order.
+func syntaxFromDocument(doc *up.Document) *syntaxNode {
+	root := &syntaxNode{Kind: blockSyntax}
+	for _, node := range doc.Nodes {
+		root.Children = append(root.Children, syntaxFromValue(node.Key, node.Type, node.Value))
+	}
+	return root
+}
+
+// syntaxFromValue builds the syntax node for a value. Blocks are keyed by
+// their source form ("port!int"), as readDocument produces them.
+func syntaxFromValue(key, typ string, v up.Value) *syntaxNode {
+	node := &syntaxNode{Key: key, Type: typ}
+	switch value := v.(type) {
+	case up.Block:
+		node.Kind = blockSyntax
+		for _, k := range sortedKeys(value) {
+			childKey, childType := splitKey(k)
+			node.Children = append(node.Children, syntaxFromValue(childKey, childType, value[k]))
+		}
+	case up.List:
+		node.Kind = listSyntax
+		for _, item := range value {
+			node.Children = append(node.Children, syntaxFromItem(item))
+		}
+	case string:
+		if !needsMultiline(value) {
+			node.Text = value
+			break
+		}
+		node.Kind = multilineSyntax
+		node.Lines = strings.Split(value, "\n")
+		if n, ok := dedentWidth(typ); ok {
+			// The reader removes n characters from each line again.
+			for i, line := range node.Lines {
+				node.Lines[i] = strings.Repeat(" ", n) + line
+			}
+		}
+	case nil:
+	default:
+		node.Text = fmt.Sprintf("%v", value)
+	}
+	return node
+}
+
+// syntaxFromItem builds the syntax node for a list item.
+func syntaxFromItem(item up.Value) *syntaxNode {
+	switch value := item.(type) {
+	case up.Block:
+		return syntaxFromValue("", "", value)
+	case up.List:
+		node := &syntaxNode{Kind: inlineListSyntax}
+		for _, x := range value {
+			node.Items = append(node.Items, fmt.Sprintf("%v", x))
+		}
+		return node
+	case []any:
+		node := &syntaxNode{Kind: inlineListSyntax}
+		for _, x := range value {
+			node.Items = append(node.Items, fmt.Sprintf("%v", x))
+		}
+		return node
+	default:
+		return &syntaxNode{Text: fmt.Sprintf("%v", value)}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describeSyntax renders a syntax tree compactly, one node per line, with
+// its line range, for comparison in tests.
+func describeSyntax(node *syntaxNode, depth int, b *strings.Builder) {
+	for _, child := range node.Children {
+		parts := []string{fmt.Sprintf("%d-%d", child.Line, child.EndLine)}
+		if key := joinKey(child.Key, child.Type); key != "" {
+			parts = append(parts, key)
+		}
+		switch child.Kind {
+		case scalarSyntax:
+			parts = append(parts, fmt.Sprintf("%q", child.Text))
+		case multilineSyntax:
+			parts = append(parts, fmt.Sprintf("```%s %q", child.Fence, child.Lines))
+		case inlineListSyntax:
+			parts = append(parts, fmt.Sprintf("%q", child.Items))
+		}
+		if len(child.Comments) > 0 {
+			parts = append(parts, fmt.Sprintf("comments=%q", child.Comments))
+		}
+		fmt.Fprintf(b, "%s%s\n", strings.Repeat("  ", depth), strings.Join(parts, " "))
+		describeSyntax(child, depth+1, b)
+		if len(child.Trailer) > 0 {
+			fmt.Fprintf(b, "%strailer=%q\n", strings.Repeat("  ", depth+1), child.Trailer)
+		}
+	}
+}
+
+func TestParseSyntax(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "scalars and comments",
+			source: "# header\n\nname app\n  port!int   80  \n# end\n",
+			want: `3-3 name "app" comments=["# header" ""]
+4-4 port!int "80"
+trailer=["# end"]
+`,
+		},
+		{
+			name:   "nested block",
+			source: "server {\n  host h\n\n  # trailing\n}\n",
+			want: `1-5 server
+  2-2 host "h"
+  trailer=["" "# trailing"]
+`,
+		},
+		{
+			name:   "list items",
+			source: "items [\n  a\n  # inline\n  [x, y]\n  {\n    k v\n  }\n]\n",
+			want: `1-8 items
+  2-2 "a"
+  4-4 ["x" "y"] comments=["# inline"]
+  5-7
+    6-6 k "v"
+`,
+		},
+		{
+			name:   "multiline keeps raw lines",
+			source: "text ```sh\n  indented\n# not a comment\n```\n",
+			want:   "1-4 text ```sh [\"  indented\" \"# not a comment\"]\n",
+		},
+		{
+			name:   "empty",
+			source: "",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseSyntax(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var b strings.Builder
+			describeSyntax(root, 0, &b)
+			if len(root.Trailer) > 0 {
+				fmt.Fprintf(&b, "trailer=%q\n", root.Trailer)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		// want lists "line:column code message" of each problem.
+		want []string
+	}{
+		{"unterminated block", "a {\n  b c\n", []string{"1:3 UP101 unterminated block: missing }"}},
+		{"unterminated list", "a [\n  b\n", []string{"1:3 UP102 unterminated list: missing ]"}},
+		{"unterminated string", "a ```\nb\n", []string{"1:3 UP103 unterminated multiline string: missing closing ```"}},
+		{"stray brace", "a b\n}\n", []string{"2:1 UP104 unexpected }"}},
+		{"stray brace in list", "a [\n  }\n]\n", []string{"2:3 UP104 unexpected }"}},
+		{"missing key", "!int 1\n", []string{"1:1 UP105 missing key before type annotation"}},
+		{"empty annotation", "a! 1\n", []string{"1:1 UP105 empty type annotation"}},
+		{
+			name:   "source order",
+			source: "a {\n  b!\n",
+			want:   []string{"1:3 UP101 unterminated block: missing }", "2:3 UP105 empty type annotation"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSyntax(strings.NewReader(tt.source))
+			var diags diagnosticError
+			if !errors.As(err, &diags) {
+				t.Fatalf("expected diagnostics, got %v", err)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, fmt.Sprintf("%d:%d %s %s", d.Line, d.Column, d.Code, d.Message))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
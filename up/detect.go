@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// tomlHeader matches a TOML [table] or [[array]] header line.
+	tomlHeader = regexp.MustCompile(`^\[\[?\s*[A-Za-z0-9_."' -]+\s*\]\]?\s*(#.*)?$`)
+	// tomlAssignment matches a TOML key = value line.
+	tomlAssignment = regexp.MustCompile(`^[A-Za-z0-9_."'-]+\s*=`)
+	// yamlMappingKey matches a YAML key: value line. The colon must end the
+	// key itself, so a UP value containing ": " does not match.
+	yamlMappingKey = regexp.MustCompile(`^("[^"]*"|'[^']*'|[\w.-]+):(\s|$)`)
+	// upStatement matches UP statements that open a block, list or
+	// multiline string, which no other format writes this way.
+	upStatement = regexp.MustCompile("^[^\\s:=]+\\s+(\\{|\\[|```.*)$")
+	// upScalarStatement matches a UP key value statement, optionally with a
+	// type annotation.
+	upScalarStatement = regexp.MustCompile(`^[\w.-]+(![\w.-]+)?\s+[^\s=:]`)
+)
+
+// detectFormat determines the format of an input, first from the file
+// extension and then from its content. It returns the format name and a
+// short description of how it was chosen.
+func detectFormat(filename string, data []byte) (string, string, error) {
+	if filename != "" {
+		ext := strings.ToLower(filepath.Ext(filename))
+		if format, ok := extensionFormats[ext]; ok {
+			return format, "from file extension " + ext, nil
+		}
+	}
+
+	formats := sniffFormats(data)
+	switch len(formats) {
+	case 0:
+		return "up", "from content", nil
+	case 1:
+		return formats[0], "from content", nil
+	default:
+		return "", "", fmt.Errorf("ambiguous input format: content mixes %s syntax, use --from", strings.Join(formats, " and "))
+	}
+}
+
+// sniffFormats returns the formats whose syntax appears on the top-level
+// lines of data, in order of first appearance. A leading { or [ marks the
+// whole input as JSON, unless it starts a TOML [table] header and the input
+// is not valid JSON, and a leading UP statement marks it as UP; otherwise
+// a ---, key: or - line points to YAML, a [table] header or key = value line
+// to TOML, and a statement opening a block, list or multiline string to UP.
+// Indented lines and the bodies of UP multiline strings are not considered.
+// Lines may be of any length, so minified JSON is detected too.
+func sniffFormats(data []byte) []string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var formats []string
+	seen := make(map[string]bool)
+	add := func(format string) {
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+
+	first := true
+	inMultiline := false
+	for rest := string(data); rest != ""; {
+		var raw string
+		raw, rest, _ = strings.Cut(rest, "\n")
+		line := strings.TrimSpace(raw)
+		if inMultiline {
+			inMultiline = line != "```"
+			continue
+		}
+		if skipLine(line) {
+			continue
+		}
+
+		leading := first
+		if first {
+			first = false
+			if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") && (!tomlHeader.MatchString(line) || json.Valid(data)) {
+				return []string{"json"}
+			}
+		}
+		if raw != strings.TrimLeft(raw, " \t") {
+			continue
+		}
+
+		switch {
+		case line == "---", strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "%YAML"), strings.HasPrefix(line, "- "):
+			add("yaml")
+		case tomlHeader.MatchString(line), tomlAssignment.MatchString(line):
+			add("toml")
+		case yamlMappingKey.MatchString(line):
+			add("yaml")
+		case leading && (upStatement.MatchString(line) || upScalarStatement.MatchString(line)):
+			return []string{"up"}
+		case upStatement.MatchString(line):
+			add("up")
+			inMultiline = strings.Contains(line, "```")
+		}
+	}
+	return formats
+}
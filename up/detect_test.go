@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+		want     string
+	}{
+		{"extension", "config.yml", "name app\n", "yaml"},
+		{"upper-case extension", "CONFIG.TOML", "name app\n", "toml"},
+		{"unknown extension", "config.conf", "name: app\n", "yaml"},
+		{"json object", "", "{\"a\": 1}\n", "json"},
+		{"json array", "", "\n  [1, 2]\n", "json"},
+		{"json array of strings", "", "[\"a\"]\n", "json"},
+		{"json array of arrays", "", "[[\"a\"]]\n", "json"},
+		{"minified json", "", "{\"a\":\"" + strings.Repeat("x", 1<<17) + "\"}", "json"},
+		{"yaml document", "", "---\nname: app\n", "yaml"},
+		{"yaml mapping", "", "# config\nname: app\nport: 8080\n", "yaml"},
+		{"yaml nested mapping", "", "server:\n  port: 8080\n", "yaml"},
+		{"yaml quoted key", "", "\"a b\": 1\n", "yaml"},
+		{"yaml sequence", "", "- a\n- b\n", "yaml"},
+		{"toml table", "", "[server]\nport = 8080\n", "toml"},
+		{"toml quoted table", "", "[\"a\"]\nport = 8080\n", "toml"},
+		{"yaml long line", "", "name: " + strings.Repeat("x", 1<<17) + "\n", "yaml"},
+		{"toml assignment", "", "name = \"app\"\n", "toml"},
+		{"up scalar", "", "name app\n", "up"},
+		{"up value with colon", "", "message Error: failed\n", "up"},
+		{"up annotated", "", "port!int 8080\n", "up"},
+		{"up block", "", "server {\n  port!int 8080\n}\n", "up"},
+		{"up first line wins", "", "name app\nurl: http://example.com\n", "up"},
+		{"up multiline body ignored", "", "# note\ntext ```\nkey: value\n```\n", "up"},
+		{"byte order mark", "", "\xef\xbb\xbfname: app\n", "yaml"},
+		{"empty", "", "", "up"},
+		{"comments only", "", "# nothing\n", "up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := detectFormat(tt.filename, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFormatErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"yaml and toml", "name: app\nport = 8080\n", "ambiguous input format: content mixes yaml and toml syntax"},
+		{"toml and up", "name = \"app\"\nserver {\n}\n", "ambiguous input format: content mixes toml and up syntax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := detectFormat("", []byte(tt.data))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diagnostic codes. UP1xx are syntax errors, UP2xx schema violations and
+// UP3xx lint findings.
+const (
+	codeReadError          = "UP100"
+	codeUnterminatedBlock  = "UP101"
+	codeUnterminatedList   = "UP102"
+	codeUnterminatedString = "UP103"
+	codeUnexpectedClose    = "UP104"
+	codeInvalidKey         = "UP105"
+
+	codeTypeMismatch      = "UP201"
+	codeMissingKey        = "UP202"
+	codeUnknownKey        = "UP203"
+	codeMissingAnnotation = "UP204"
+	codeNotInEnum         = "UP205"
+	codePatternMismatch   = "UP206"
+	codeLength            = "UP207"
+	codeRange             = "UP208"
+
+	codeDuplicateKey    = "UP301"
+	codeUnknownType     = "UP302"
+	codeEmptyBlock      = "UP303"
+	codeKeyNaming       = "UP304"
+	codeNestingDepth    = "UP305"
+	codeSuspiciousValue = "UP306"
+)
+
+// diagnosticRule describes a diagnostic code.
+type diagnosticRule struct {
+	Code string
+	// Name is a short kebab-case name for the rule, used to configure lint
+	// rules.
+	Name        string
+	Description string
+	// Severity is the severity diagnostics with the code have unless
+	// configured otherwise.
+	Severity string
+}
+
+// diagnosticRules describes every diagnostic code, in code order.
+var diagnosticRules = []diagnosticRule{
+	{codeReadError, "read-error", "The input could not be read", severityError},
+	{codeUnterminatedBlock, "unterminated-block", "A block is missing its closing }", severityError},
+	{codeUnterminatedList, "unterminated-list", "A list is missing its closing ]", severityError},
+	{codeUnterminatedString, "unterminated-string", "A multiline string is missing its closing ```", severityError},
+	{codeUnexpectedClose, "unexpected-close", "A } or ] does not close anything", severityError},
+	{codeInvalidKey, "invalid-key", "A key or type annotation is malformed", severityError},
+	{codeTypeMismatch, "type-mismatch", "A value does not have the type the schema requires", severityError},
+	{codeMissingKey, "missing-key", "A key the schema requires is missing", severityError},
+	{codeUnknownKey, "unknown-key", "A key is not described by the schema", severityError},
+	{codeMissingAnnotation, "missing-annotation", "A value lacks the type annotation the schema requires", severityError},
+	{codeNotInEnum, "not-in-enum", "A value is not one of the values the schema allows", severityError},
+	{codePatternMismatch, "pattern-mismatch", "A value does not match the schema pattern", severityError},
+	{codeLength, "length", "A string or list length is out of the schema range", severityError},
+	{codeRange, "range", "A value is out of the schema range", severityError},
+	{codeDuplicateKey, "duplicate-key", "A key is defined more than once in the same block", severityError},
+	{codeUnknownType, "unknown-type", "A type annotation is neither built in nor a configured custom type", severityError},
+	{codeEmptyBlock, "empty-block", "A block has no entries", severityWarning},
+	{codeKeyNaming, "key-naming", "A key does not follow the naming style of the file", severityWarning},
+	{codeNestingDepth, "nesting-depth", "A value is nested too deeply", severityWarning},
+	{codeSuspiciousValue, "suspicious-value", "A value looks like a bool or an int but has no type annotation", severityWarning},
+}
+
+// ruleByName returns the diagnostic rule with the given name.
+func ruleByName(name string) (diagnosticRule, bool) {
+	for _, rule := range diagnosticRules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return diagnosticRule{}, false
+}
+
+// Diagnostic severities.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityNote    = "note"
+)
+
+// errorFormats lists the values accepted by --error-format.
+var errorFormats = []string{"text", "json", "github"}
+
+// diagnostic is a problem found in a source file. Lines and columns are
+// 1-based and count characters; EndColumn is the column just past the end
+// of the range. A zero Line means the problem concerns the whole file.
+type diagnostic struct {
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	EndColumn int    `json:"end_column,omitempty"`
+	Severity  string `json:"severity"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+	// source is the text of Line, shown in snippets.
+	source string
+}
+
+// location formats the position of the diagnostic as file:line:column.
+func (d diagnostic) location() string {
+	loc := d.File
+	if loc == "" {
+		loc = "<stdin>"
+	}
+	if d.Line > 0 {
+		loc += fmt.Sprintf(":%d", d.Line)
+		if d.Column > 0 {
+			loc += fmt.Sprintf(":%d", d.Column)
+		}
+	}
+	return loc
+}
+
+// diagnosticError is an error made of one or more diagnostics. Commands
+// return it, possibly wrapped, and Run reports it in the selected format.
+type diagnosticError []diagnostic
+
+// Error summarizes the first diagnostic.
+func (d diagnosticError) Error() string {
+	msg := fmt.Sprintf("%s: %s", d[0].location(), d[0].Message)
+	if len(d) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(d)-1)
+	}
+	return msg
+}
+
+// inFile returns the diagnostics attributed to a file, with their source
+// lines taken from its contents.
+func (d diagnosticError) inFile(filename string, data []byte) diagnosticError {
+	lines := sourceLines(data)
+	result := make(diagnosticError, len(d))
+	for i, diag := range d {
+		diag.File = filename
+		if diag.Line > 0 && diag.Line <= len(lines) {
+			diag.source = lines[diag.Line-1]
+		}
+		result[i] = diag
+	}
+	return result
+}
+
+// withSource attributes the diagnostics in err, if any, to a file. Other
+// errors are returned unchanged.
+func withSource(err error, filename string, data []byte) error {
+	var diags diagnosticError
+	if errors.As(err, &diags) {
+		return diags.inFile(filename, data)
+	}
+	return err
+}
+
+// inputName returns the name used for an input in diagnostics.
+func inputName(filename string) string {
+	if filename == "" {
+		return "<stdin>"
+	}
+	return filename
+}
+
+// outputName returns the name used for an output in error messages.
+func outputName(filename string) string {
+	if filename == "" {
+		return "<stdout>"
+	}
+	return filename
+}
+
+// sourceLines splits a source file into lines.
+func sourceLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+// column converts a byte offset in a line into a 1-based column.
+func column(line string, offset int) int {
+	return utf8.RuneCountInString(line[:offset]) + 1
+}
+
+// spanDiagnostic returns a diagnostic covering text[start:end] on a line.
+func spanDiagnostic(lineNum int, line string, start, end int, code, format string, args ...any) diagnostic {
+	return diagnostic{
+		Line:      lineNum,
+		Column:    column(line, start),
+		EndLine:   lineNum,
+		EndColumn: column(line, end),
+		Severity:  severityError,
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		source:    line,
+	}
+}
+
+// nodeDiagnostic returns a diagnostic for a syntax node. It covers the
+// whole statement of a scalar, and the key of anything else.
+func nodeDiagnostic(lines []string, node *syntaxNode, code, message string) diagnostic {
+	raw := lines[node.Line-1]
+	start := len(raw) - len(strings.TrimLeft(raw, " \t"))
+	text := strings.TrimSpace(raw)
+	if node.Key != "" && node.Kind != scalarSyntax {
+		text, _ = splitStatement(text)
+	}
+	return spanDiagnostic(node.Line, raw, start, start+len(text), code, "%s", message)
+}
+
+// reportedError is returned by Run once an error has been reported in the
+// selected error format, so that main only sets the exit status.
+type reportedError struct {
+	err error
+}
+
+// Error returns the message of the reported error.
+func (e reportedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the reported error.
+func (e reportedError) Unwrap() error {
+	return e.err
+}
+
+// reportError writes an error returned by a command in the selected error
+// format. Plain errors are left to main in the text format, and become
+// diagnostics without a location in the others.
+func (a *App) reportError(w io.Writer, err error) error {
+	var diags diagnosticError
+	if !errors.As(err, &diags) {
+		if a.errorFormat == "text" {
+			return err
+		}
+		diags = diagnosticError{{Severity: severityError, Message: err.Error()}}
+	}
+	if werr := writeDiagnostics(w, a.errorFormat, diags); werr != nil {
+		return werr
+	}
+	return reportedError{err}
+}
+
+// writeDiagnostics writes diagnostics as text with source snippets, as a
+// JSON array, or as GitHub Actions workflow commands.
+func writeDiagnostics(w io.Writer, format string, diags []diagnostic) error {
+	switch format {
+	case "json":
+		if diags == nil {
+			diags = []diagnostic{}
+		}
+		return writeIndentedJSON(w, diags)
+	case "github":
+		for _, d := range diags {
+			writeDiagnosticGitHub(w, d)
+		}
+	default:
+		for _, d := range diags {
+			writeDiagnosticText(w, d)
+		}
+	}
+	return nil
+}
+
+// writeDiagnosticText writes a diagnostic followed by its source line, with
+// the range underlined:
+//
+//	app.up:3:8: error[UP101]: unterminated block: missing }
+//	   3 | server {
+//	     |        ^
+func writeDiagnosticText(w io.Writer, d diagnostic) {
+	label := d.Severity
+	if d.Code != "" {
+		label += "[" + d.Code + "]"
+	}
+	fmt.Fprintf(w, "%s: %s: %s\n", d.location(), label, d.Message)
+	if d.Line == 0 || d.Column == 0 || d.source == "" {
+		return
+	}
+
+	gutter := fmt.Sprintf("%4d | ", d.Line)
+	fmt.Fprintf(w, "%s%s\n", gutter, d.source)
+
+	// Keep tabs in the indentation so the underline lines up.
+	var pad strings.Builder
+	runes := []rune(d.source)
+	for i := 0; i < d.Column-1 && i < len(runes); i++ {
+		if runes[i] == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	width := 1
+	if d.EndLine == d.Line && d.EndColumn > d.Column {
+		width = d.EndColumn - d.Column
+	}
+	fmt.Fprintf(w, "%s| %s%s\n", strings.Repeat(" ", len(gutter)-2), pad.String(), strings.Repeat("^", width))
+}
+
+// writeDiagnosticGitHub writes a diagnostic as a GitHub Actions workflow
+// command, which shows up as an annotation on the file in pull requests.
+func writeDiagnosticGitHub(w io.Writer, d diagnostic) {
+	command := "error"
+	switch d.Severity {
+	case severityWarning:
+		command = "warning"
+	case severityNote:
+		command = "notice"
+	}
+
+	var props []string
+	if d.File != "" && d.File != "<stdin>" {
+		props = append(props, "file="+githubEscape(d.File, true))
+	}
+	if d.Line > 0 {
+		props = append(props, fmt.Sprintf("line=%d", d.Line))
+		if d.EndLine > 0 {
+			props = append(props, fmt.Sprintf("endLine=%d", d.EndLine))
+		}
+	}
+	if d.Column > 0 {
+		props = append(props, fmt.Sprintf("col=%d", d.Column))
+		// GitHub's endColumn is inclusive.
+		if d.EndLine == d.Line && d.EndColumn > d.Column {
+			props = append(props, fmt.Sprintf("endColumn=%d", d.EndColumn-1))
+		}
+	}
+	if d.Code != "" {
+		props = append(props, "title="+githubEscape(d.Code, true))
+	}
+
+	line := "::" + command
+	if len(props) > 0 {
+		line += " " + strings.Join(props, ",")
+	}
+	fmt.Fprintf(w, "%s::%s\n", line, githubEscape(d.Message, false))
+}
+
+// githubEscape escapes a workflow command message or property value.
+func githubEscape(s string, property bool) string {
+	s = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+	if property {
+		s = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(s)
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testDiagnostics are reported in every error format.
+var testDiagnostics = []diagnostic{
+	{File: "app.up", Line: 3, Column: 8, EndLine: 3, EndColumn: 9, Severity: severityError, Code: codeUnterminatedBlock, Message: "unterminated block: missing }", source: "server {"},
+	{File: "app.up", Line: 2, Column: 3, EndLine: 2, EndColumn: 7, Severity: severityWarning, Code: codeKeyNaming, Message: "key: does not match, 50%", source: "\tkey value"},
+	{File: "<stdin>", Severity: severityNote, Message: "whole file"},
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	tests := []struct {
+		format string
+		diags  []diagnostic
+		want   string
+	}{
+		{
+			format: "text",
+			diags:  testDiagnostics,
+			want: `app.up:3:8: error[UP101]: unterminated block: missing }
+   3 | server {
+     |        ^
+app.up:2:3: warning[UP304]: key: does not match, 50%
+   2 | 	key value
+     | 	 ^^^^
+<stdin>: note: whole file
+`,
+		},
+		{
+			format: "github",
+			diags:  testDiagnostics,
+			want: `::error file=app.up,line=3,endLine=3,col=8,endColumn=8,title=UP101::unterminated block: missing }
+::warning file=app.up,line=2,endLine=2,col=3,endColumn=6,title=UP304::key: does not match, 50%25
+::notice::whole file
+`,
+		},
+		{
+			format: "json",
+			diags:  testDiagnostics[:1],
+			want: `[
+  {
+    "file": "app.up",
+    "line": 3,
+    "column": 8,
+    "end_line": 3,
+    "end_column": 9,
+    "severity": "error",
+    "code": "UP101",
+    "message": "unterminated block: missing }"
+  }
+]
+`,
+		},
+		{
+			format: "json",
+			want:   "[]\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeDiagnostics(&buf, tt.format, tt.diags); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReportError(t *testing.T) {
+	diags := diagnosticError(testDiagnostics[:1])
+	tests := []struct {
+		name     string
+		format   string
+		err      error
+		want     string
+		reported bool
+	}{
+		{"plain error as text", "text", errors.New("boom"), "", false},
+		{"plain error as json", "json", errors.New("boom"), "[\n  {\n    \"severity\": \"error\",\n    \"message\": \"boom\"\n  }\n]\n", true},
+		{"plain error for github", "github", errors.New("a\nb"), "::error::a%0Ab\n", true},
+		{"wrapped diagnostics", "github", fmt.Errorf("failed: %w", diags), "::error file=app.up,line=3,endLine=3,col=8,endColumn=8,title=UP101::unterminated block: missing }\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &App{errorFormat: tt.format}
+			var buf bytes.Buffer
+			err := a.reportError(&buf, tt.err)
+			var reported reportedError
+			if errors.As(err, &reported) != tt.reported {
+				t.Errorf("got %T, reported %v", err, tt.reported)
+			}
+			if !errors.Is(err, tt.err) && err != tt.err {
+				t.Errorf("error %v does not wrap %v", err, tt.err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticErrorInFile(t *testing.T) {
+	_, err := parseSyntax(strings.NewReader("a b\nc {\n"))
+	var diags diagnosticError
+	if !errors.As(withSource(err, "x.up", []byte("a b\nc {\n")), &diags) {
+		t.Fatalf("expected diagnostics, got %v", err)
+	}
+	if got, want := diags.Error(), "x.up:2:3: unterminated block: missing }"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if diags[0].source != "c {" {
+		t.Errorf("got source %q, want %q", diags[0].source, "c {")
+	}
+
+	more := diagnosticError{diags[0], diags[0]}
+	if got, want := more.Error(), "x.up:2:3: unterminated block: missing } (and 1 more)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if plain := errors.New("plain"); withSource(plain, "x.up", nil) != plain {
+		t.Error("withSource changed a plain error")
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := []struct {
+		line   string
+		offset int
+		want   int
+	}{
+		{"abc", 0, 1},
+		{"abc", 3, 4},
+		{"héllo x", 7, 7},
+		{"日本 x", 7, 4},
+	}
+	for _, tt := range tests {
+		if got := column(tt.line, tt.offset); got != tt.want {
+			t.Errorf("column(%q, %d) = %d, want %d", tt.line, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestErrorFormatFlag(t *testing.T) {
+	_, err := runApp(t, "", "--error-format", "xml", "version")
+	if err == nil || !strings.Contains(err.Error(), `invalid --error-format "xml" (expected text, json, github)`) {
+		t.Errorf("got error %v, want an invalid --error-format error", err)
+	}
+}
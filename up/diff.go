@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// ANSI colors used by the text diff listing.
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// handleDiff processes the diff command.
+func (a *App) handleDiff(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected two files to compare")
+	}
+
+	docs := make([]*up.Document, 2)
+	for i, filename := range c.Args().Slice() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		if docs[i], err = parseSource(filename, data); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+	}
+
+	changes := compareDocuments(docs[0], docs[1])
+
+	var buf bytes.Buffer
+	var err error
+	switch format := c.String("format"); format {
+	case "text":
+		color, cerr := a.useColor(c.String("color"))
+		if cerr != nil {
+			return cerr
+		}
+		writeChangesText(&buf, changes, color)
+	case "json":
+		err = writeChangesJSON(&buf, changes)
+	case "patch":
+		err = writeChangesPatch(&buf, changes)
+	default:
+		return fmt.Errorf("unknown diff format %q (expected text, json or patch)", format)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(a.output); err != nil {
+		return err
+	}
+
+	if len(changes) > 0 && c.Bool("exit-code") {
+		return fmt.Errorf("documents differ in %d place(s)", len(changes))
+	}
+	return nil
+}
+
+// useColor decides whether to color output for a --color setting: always,
+// never, or auto, which colors terminals unless NO_COLOR is set.
+func (a *App) useColor(setting string) (bool, error) {
+	switch setting {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		file, ok := a.output.(*os.File)
+		if !ok {
+			return false, nil
+		}
+		info, err := file.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("invalid --color %q (expected auto, always or never)", setting)
+	}
+}
+
+// writeChangesText writes one line per change, marked + for additions, - for
+// removals and ~ for everything else.
+func writeChangesText(w io.Writer, changes []change, color bool) {
+	for _, ch := range changes {
+		mark, code := "~", colorYellow
+		switch ch.Kind {
+		case changeAdded:
+			mark, code = "+", colorGreen
+		case changeRemoved:
+			mark, code = "-", colorRed
+		}
+		if color {
+			fmt.Fprintf(w, "%s%s %s%s\n", code, mark, ch, colorReset)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", mark, ch)
+		}
+	}
+}
+
+// changeJSON is the JSON form of a change.
+type changeJSON struct {
+	Path    string `json:"path"`
+	Pointer string `json:"pointer"`
+	Kind    string `json:"kind"`
+	OldType string `json:"old_type,omitempty"`
+	NewType string `json:"new_type,omitempty"`
+	Old     any    `json:"old,omitempty"`
+	New     any    `json:"new,omitempty"`
+}
+
+// writeChangesJSON writes the changes as a JSON array, with values typed
+// according to their annotations.
+func writeChangesJSON(w io.Writer, changes []change) error {
+	entries := make([]changeJSON, len(changes))
+	for i, ch := range changes {
+		entries[i] = changeJSON{
+			Path:    ch.Path,
+			Pointer: ch.Pointer,
+			Kind:    string(ch.Kind),
+			OldType: ch.OldType,
+			NewType: ch.NewType,
+			Old:     typedValue(ch.Path, ch.OldType, ch.Old),
+			New:     typedValue(ch.Path, ch.NewType, ch.New),
+		}
+	}
+	return writeIndentedJSON(w, entries)
+}
+
+// patchOperation is a JSON Patch (RFC 6902) operation.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// writeChangesPatch writes the changes as a JSON Patch that turns the JSON
+// form of the first document into that of the second.
+func writeChangesPatch(w io.Writer, changes []change) error {
+	ops := make([]patchOperation, 0, len(changes))
+	for _, ch := range changes {
+		switch ch.Kind {
+		case changeAdded:
+			ops = append(ops, patchOperation{Op: "add", Path: ch.Pointer, Value: patchValue(typedValue(ch.Path, ch.NewType, ch.New))})
+		case changeRemoved:
+			ops = append(ops, patchOperation{Op: "remove", Path: ch.Pointer})
+		default:
+			ops = append(ops, patchOperation{Op: "replace", Path: ch.Pointer, Value: patchValue(typedValue(ch.Path, ch.NewType, ch.New))})
+		}
+	}
+	return writeIndentedJSON(w, ops)
+}
+
+// patchValue returns the value of an add or replace operation, which must
+// be present even when it is null.
+func patchValue(v any) any {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	return v
+}
+
+// typedValue converts a value to its typed form, falling back to plain
+// strings when the value does not match its annotation.
+func typedValue(path, typ string, v up.Value) any {
+	if v == nil {
+		return nil
+	}
+	value, err := toValue(path, typ, v)
+	if err != nil {
+		value, _ = toValue(path, "", v)
+	}
+	return value
+}
+
+// writeIndentedJSON writes a value as indented JSON.
+func writeIndentedJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
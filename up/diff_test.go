@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	diffTestA = "name app\nport!int 80\ndebug!bool true\ntags [\n  a\n  b\n]\n"
+	diffTestB = "name app\nport!int 8080\ntags [\n  b\n  a\n]\nextra {\n  x!int 1\n}\n"
+)
+
+func TestDiff(t *testing.T) {
+	a := writeTestFile(t, "a.up", diffTestA)
+	b := writeTestFile(t, "b.up", diffTestB)
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "text",
+			args: []string{a, b},
+			want: "- debug: removed \"true\" (!bool)\n+ extra: added block of 1 key(s)\n~ port: changed from \"80\" (!int) to \"8080\" (!int)\n~ tags: list items reordered\n",
+		},
+		{
+			name: "color",
+			args: []string{"--color", "always", a, b},
+			want: "\x1b[31m- debug: removed \"true\" (!bool)\x1b[0m\n\x1b[32m+ extra: added block of 1 key(s)\x1b[0m\n\x1b[33m~ port: changed from \"80\" (!int) to \"8080\" (!int)\x1b[0m\n\x1b[33m~ tags: list items reordered\x1b[0m\n",
+		},
+		{
+			name: "json",
+			args: []string{"--format", "json", a, b},
+			want: `[
+  {
+    "path": "debug",
+    "pointer": "/debug",
+    "kind": "removed",
+    "old_type": "bool",
+    "old": true
+  },
+  {
+    "path": "extra",
+    "pointer": "/extra",
+    "kind": "added",
+    "new": {
+      "x": 1
+    }
+  },
+  {
+    "path": "port",
+    "pointer": "/port",
+    "kind": "changed",
+    "old_type": "int",
+    "new_type": "int",
+    "old": 80,
+    "new": 8080
+  },
+  {
+    "path": "tags",
+    "pointer": "/tags",
+    "kind": "reordered",
+    "old": [
+      "a",
+      "b"
+    ],
+    "new": [
+      "b",
+      "a"
+    ]
+  }
+]
+`,
+		},
+		{
+			name: "identical",
+			args: []string{"--exit-code", a, a},
+			want: "",
+		},
+		{
+			name:    "exit code",
+			args:    []string{"--exit-code", "--color", "never", a, b},
+			want:    "- debug: removed \"true\" (!bool)\n+ extra: added block of 1 key(s)\n~ port: changed from \"80\" (!int) to \"8080\" (!int)\n~ tags: list items reordered\n",
+			wantErr: "documents differ in 4 place(s)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, "", append([]string{"diff"}, tt.args...)...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffPatchRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"mixed changes", diffTestA, diffTestB},
+		{"list growth and shrinkage", "l!int [\n  1\n  2\n  3\n]\nm [\n  x\n]\n", "l!int [\n  1\n]\nm [\n  x\n  y\n  z\n]\n"},
+		{"nested blocks", "a {\n  b {\n    c 1\n    d 2\n  }\n}\n", "a {\n  b {\n    c 3\n  }\n  e 4\n}\n"},
+		{"null", "n!null null\n", "n x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := writeTestFile(t, "a.up", tt.a)
+			b := writeTestFile(t, "b.up", tt.b)
+			patch, err := runApp(t, "", "diff", "--format", "patch", a, b)
+			if err != nil {
+				t.Fatalf("diff: %v", err)
+			}
+			patchFile := filepath.Join(t.TempDir(), "patch.json")
+			if err := os.WriteFile(patchFile, []byte(patch), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			patched, err := runApp(t, "", "patch", "--patch", patchFile, "-i", a)
+			if err != nil {
+				t.Fatalf("patch: %v\n%s", err, patch)
+			}
+			rest, err := runApp(t, "", "diff", b, writeTestFile(t, "patched.up", patched))
+			if err != nil {
+				t.Fatalf("diff after patch: %v", err)
+			}
+			if rest != "" {
+				t.Errorf("patched document still differs:\n%s\npatch:\n%s", rest, patch)
+			}
+		})
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	a := writeTestFile(t, "a.up", diffTestA)
+	broken := writeTestFile(t, "broken.up", "a {\n")
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"one file", []string{a}, "expected two files to compare"},
+		{"unknown format", []string{"--format", "xml", a, a}, `unknown diff format "xml"`},
+		{"invalid color", []string{"--color", "sometimes", a, a}, `invalid --color "sometimes"`},
+		{"missing file", []string{a, a + ".missing"}, "failed to read input"},
+		{"syntax error", []string{a, broken}, "failed to parse " + broken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, "", append([]string{"diff"}, tt.args...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	up "github.com/uplang/go"
+)
+
+// readDocument parses a UP document like up.Parser, but keeps the type
+// annotations of nested keys. up.Block is keyed by the bare name, so an
+// annotated entry is stored under its source form ("port!int"). Syntax
+// errors are reported as a diagnosticError.
+func readDocument(r io.Reader) (*up.Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseSyntax(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	dr := &documentReader{scanner: bufio.NewScanner(bytes.NewReader(data))}
+
+	var nodes []up.Node
+	for {
+		line, ok := dr.next()
+		if !ok {
+			break
+		}
+		if skipLine(line) {
+			continue
+		}
+
+		lineNum := dr.line
+		node, err := dr.readNode(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return &up.Document{Nodes: nodes}, dr.scanner.Err()
+}
+
+// parseSource reads a document from its source, attributing syntax errors
+// to the named file.
+func parseSource(filename string, data []byte) (*up.Document, error) {
+	doc, err := readDocument(bytes.NewReader(data))
+	if err != nil {
+		return nil, withSource(err, filename, data)
+	}
+	return doc, nil
+}
+
+// documentReader tracks the scanner state while reading a document.
+type documentReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// next returns the next raw line.
+func (dr *documentReader) next() (string, bool) {
+	if !dr.scanner.Scan() {
+		return "", false
+	}
+	dr.line++
+	return dr.scanner.Text(), true
+}
+
+// readNode reads a key-value statement starting at line.
+func (dr *documentReader) readNode(line string) (up.Node, error) {
+	keyPart, valPart := splitStatement(strings.TrimSpace(line))
+	key, typ := splitKey(keyPart)
+
+	value, err := dr.readValue(typ, valPart)
+	if err != nil {
+		return up.Node{}, err
+	}
+	return up.Node{Key: key, Type: typ, Value: value}, nil
+}
+
+// readValue reads the value part of a statement, consuming further lines for
+// multiline strings, blocks and lists.
+func (dr *documentReader) readValue(typ, valPart string) (up.Value, error) {
+	switch {
+	case strings.HasPrefix(valPart, "```"):
+		return dr.readMultiline(typ), nil
+	case valPart == "{":
+		return dr.readBlock()
+	case valPart == "[":
+		return dr.readList()
+	default:
+		return valPart, nil
+	}
+}
+
+// readMultiline reads a triple-backtick string, dedenting it when the type
+// annotation is a number.
+func (dr *documentReader) readMultiline(typ string) string {
+	var content []string
+	for {
+		line, ok := dr.next()
+		if !ok || strings.TrimSpace(line) == "```" {
+			break
+		}
+		content = append(content, line)
+	}
+
+	text := strings.Join(content, "\n")
+	if n, ok := dedentWidth(typ); ok {
+		text = dedent(text, n)
+	}
+	return text
+}
+
+// readBlock reads the statements of a { ... } block.
+func (dr *documentReader) readBlock() (up.Block, error) {
+	block := make(up.Block)
+	for {
+		line, ok := dr.next()
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "}" {
+			break
+		}
+		if skipLine(line) {
+			continue
+		}
+
+		node, err := dr.readNode(line)
+		if err != nil {
+			return nil, err
+		}
+		block[joinKey(node.Key, node.Type)] = node.Value
+	}
+	return block, nil
+}
+
+// readList reads the items of a [ ... ] list.
+func (dr *documentReader) readList() (up.List, error) {
+	list := up.List{}
+	for {
+		line, ok := dr.next()
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "]" {
+			break
+		}
+		if skipLine(line) {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "{"):
+			block, err := dr.readBlock()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, block)
+		case strings.HasPrefix(line, "["):
+			list = append(list, parseInlineList(line))
+		default:
+			list = append(list, line)
+		}
+	}
+	return list, nil
+}
+
+// skipLine reports whether a line is blank or a comment.
+func skipLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// splitStatement splits a statement into its key and value parts.
+func splitStatement(line string) (string, string) {
+	if idx := strings.IndexAny(line, " \t"); idx >= 0 {
+		return line[:idx], strings.TrimSpace(line[idx:])
+	}
+	return line, ""
+}
+
+// splitKey splits a "key!type" key into its name and type annotation.
+func splitKey(key string) (string, string) {
+	if idx := strings.Index(key, "!"); idx >= 0 {
+		return key[:idx], key[idx+1:]
+	}
+	return key, ""
+}
+
+// joinKey builds the "key!type" form of a key.
+func joinKey(key, typ string) string {
+	if typ == "" {
+		return key
+	}
+	return key + "!" + typ
+}
+
+// parseInlineList parses an inline [a, b, c] list.
+func parseInlineList(s string) up.List {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if strings.TrimSpace(s) == "" {
+		return up.List{}
+	}
+
+	items := strings.Split(s, ",")
+	list := make(up.List, len(items))
+	for i, item := range items {
+		list[i] = strings.TrimSpace(item)
+	}
+	return list
+}
+
+// dedentWidth returns the dedent width encoded in a numeric type annotation.
+func dedentWidth(typ string) (int, bool) {
+	n, err := strconv.Atoi(typ)
+	return n, err == nil
+}
+
+// dedent removes n leading characters from every line that is long enough.
+func dedent(s string, n int) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if len(line) >= n {
+			lines[i] = line[n:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// pathSegment is one step of an edit path: a key or a list index.
+type pathSegment struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// String formats the segment as it appears in a path.
+func (s pathSegment) String() string {
+	if s.IsIndex {
+		return fmt.Sprintf("[%d]", s.Index)
+	}
+	return s.Key
+}
+
+// parseEditPath parses a path of keys and list indexes such as
+// server.listeners[0].port. Keys that need it can be quoted: a."b.c".
+func parseEditPath(expr string) ([]pathSegment, error) {
+	var path []pathSegment
+	qp := &queryParser{expr: expr}
+	for qp.pos < len(qp.expr) {
+		switch c := qp.expr[qp.pos]; {
+		case c == '.' && len(path) > 0:
+			qp.pos++
+			if qp.pos >= len(qp.expr) || strings.ContainsRune(".[", rune(qp.expr[qp.pos])) {
+				return nil, fmt.Errorf("invalid path %q: missing key at offset %d", expr, qp.pos)
+			}
+		case c == '[':
+			end := strings.IndexByte(qp.expr[qp.pos:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: missing ]", expr)
+			}
+			index, err := strconv.Atoi(qp.expr[qp.pos+1 : qp.pos+end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q: invalid index at offset %d", expr, qp.pos)
+			}
+			path = append(path, pathSegment{Index: index, IsIndex: true})
+			qp.pos += end + 1
+		case c == '"':
+			key, err := qp.quoted()
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", expr, err)
+			}
+			path = append(path, pathSegment{Key: key})
+		default:
+			key := qp.name()
+			if key == "" || strings.ContainsAny(key, "!") {
+				return nil, fmt.Errorf("invalid path %q: unexpected %q at offset %d", expr, c, qp.pos)
+			}
+			path = append(path, pathSegment{Key: key})
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return path, nil
+}
+
+// formatEditPath formats path segments for error messages.
+func formatEditPath(path []pathSegment) string {
+	var b strings.Builder
+	for i, seg := range path {
+		if i > 0 && !seg.IsIndex {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg.String())
+	}
+	return b.String()
+}
+
+// sourceDocument is a document being edited: its lines and syntax tree.
+// Edits replace, insert and remove whole lines, so everything outside the
+// edited node keeps its exact formatting.
+type sourceDocument struct {
+	lines   []string
+	root    *syntaxNode
+	newline string
+}
+
+// readSourceDocument reads a document for editing, with syntax errors
+// attributed to the named file.
+func readSourceDocument(filename string, r io.Reader) (*sourceDocument, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseSyntax(bytes.NewReader(data))
+	if err != nil {
+		return nil, withSource(err, filename, data)
+	}
+
+	src := &sourceDocument{root: root, newline: "\n"}
+	if bytes.Contains(data, []byte("\r\n")) {
+		src.newline = "\r\n"
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		src.lines = append(src.lines, scanner.Text())
+	}
+	return src, scanner.Err()
+}
+
+// Bytes returns the edited document.
+func (src *sourceDocument) Bytes() []byte {
+	if len(src.lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(src.lines, src.newline) + src.newline)
+}
+
+// splice replaces lines [from, to) (0-based) with the given lines.
+func (src *sourceDocument) splice(from, to int, lines []string) {
+	src.lines = append(src.lines[:from], append(lines, src.lines[to:]...)...)
+}
+
+// indentOf returns the leading whitespace of a 1-based line.
+func (src *sourceDocument) indentOf(line int) string {
+	raw := src.lines[line-1]
+	return raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]
+}
+
+// childIndent returns the indentation for a new entry of a block: that of
+// its last entry, or one level deeper than the block itself.
+func (src *sourceDocument) childIndent(block *syntaxNode) (string, string) {
+	if block == src.root {
+		return "", defaultIndentUnit()
+	}
+	base := src.indentOf(block.Line)
+	if n := len(block.Children); n > 0 {
+		indent := src.indentOf(block.Children[n-1].Line)
+		if unit := strings.TrimPrefix(indent, base); unit != "" && len(indent) > len(base) {
+			return indent, unit
+		}
+	}
+	return base + defaultIndentUnit(), defaultIndentUnit()
+}
+
+// defaultIndentUnit is the indentation of one level in new content.
+func defaultIndentUnit() string {
+	return strings.Repeat(" ", defaultFormatOptions.Indent)
+}
+
+// render formats a node at an indentation, leaving multiline string bodies
+// as they are.
+func render(node *syntaxNode, indent, unit string) []string {
+	var buf bytes.Buffer
+	f := &formatter{w: bufio.NewWriter(&buf), opts: defaultFormatOptions, prefix: indent, unit: unit}
+	f.writeNode(node, 0)
+	f.w.Flush()
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+// find returns the node at path and its parent, or the deepest existing
+// block on the path and the number of segments that were found.
+func (src *sourceDocument) find(path []pathSegment) (node, parent *syntaxNode, found int, err error) {
+	node = src.root
+	for i, seg := range path {
+		parent = node
+		switch {
+		case seg.IsIndex && node.Kind == listSyntax:
+			if seg.Index >= len(node.Children) {
+				return nil, nil, i, fmt.Errorf("%s: index %d out of range for list of %d item(s)", formatEditPath(path[:i+1]), seg.Index, len(node.Children))
+			}
+			node = node.Children[seg.Index]
+		case !seg.IsIndex && node.Kind == blockSyntax:
+			var next *syntaxNode
+			for _, child := range node.Children {
+				if child.Key == seg.Key {
+					next = child
+				}
+			}
+			if next == nil {
+				return node, nil, i, nil
+			}
+			node = next
+		case i == 0:
+			return nil, nil, i, fmt.Errorf("%s: the document is not a list", formatEditPath(path[:1]))
+		default:
+			kind := "a list"
+			if !seg.IsIndex {
+				kind = "a block"
+			}
+			return nil, nil, i, fmt.Errorf("%s is not %s", formatEditPath(path[:i]), kind)
+		}
+	}
+	return node, parent, len(path), nil
+}
+
+// set assigns a scalar value at path. An existing entry keeps its type
+// annotation unless typ is given, and the value must be valid for it. A
+// block or list is only replaced with force, and then loses its annotation
+// unless typ is given. Missing blocks on the way are created.
+func (src *sourceDocument) set(path []pathSegment, value, typ string, typeSet, force bool) error {
+	node, parent, found, err := src.find(path)
+	if err != nil {
+		return err
+	}
+
+	if found == len(path) {
+		switch node.Kind {
+		case blockSyntax, listSyntax, inlineListSyntax:
+			if !force {
+				kind := "a list"
+				if node.Kind == blockSyntax {
+					kind = "a block"
+				}
+				return fmt.Errorf("%s is %s; use --force to replace it with a scalar", formatEditPath(path), kind)
+			}
+		default:
+			if !typeSet {
+				typ = node.Type
+				if isDedent(typ) && !needsMultiline(value) {
+					typ = ""
+				}
+			}
+		}
+		if parent.Kind == listSyntax && typeSet {
+			return fmt.Errorf("%s: list items take the type of their list", formatEditPath(path))
+		}
+		if parent.Kind == listSyntax && !listItemOK(value) {
+			return fmt.Errorf("%s: %q cannot be written as a list item", formatEditPath(path), value)
+		}
+		if parent.Kind == listSyntax && schemaTypes[parent.Type] && !validScalar(parent.Type, value) {
+			return fmt.Errorf("%s: %q is not a valid %s, the type of the list", formatEditPath(path), value, parent.Type)
+		}
+		if schemaTypes[typ] && !validScalar(typ, value) {
+			return fmt.Errorf("%s: %q is not a valid %s; use --type to change the annotation", formatEditPath(path), value, typ)
+		}
+		replacement := syntaxFromValue(node.Key, typ, value)
+		if parent.Kind == listSyntax {
+			replacement.Type = ""
+		}
+		src.splice(node.Line-1, node.EndLine, render(replacement, src.indentOf(node.Line), src.unitOf(parent)))
+		return nil
+	}
+
+	rest := path[found:]
+	for _, seg := range rest {
+		if seg.IsIndex {
+			return fmt.Errorf("%s: cannot create list items", formatEditPath(path[:found+1]))
+		}
+	}
+	created := syntaxFromValue(rest[len(rest)-1].Key, typ, value)
+	for i := len(rest) - 2; i >= 0; i-- {
+		created = &syntaxNode{Key: rest[i].Key, Kind: blockSyntax, Children: []*syntaxNode{created}}
+	}
+
+	src.appendEntries(node, []*syntaxNode{created})
+	return nil
+}
+
+// appendEntries inserts entries at the end of a block.
+func (src *sourceDocument) appendEntries(block *syntaxNode, entries []*syntaxNode) {
+	indent, unit := src.childIndent(block)
+	var lines []string
+	for _, entry := range entries {
+		lines = append(lines, render(entry, indent, unit)...)
+	}
+	at := block.EndLine
+	if block == src.root || strings.TrimSpace(src.lines[block.EndLine-1]) != "}" {
+		at = block.EndLine + 1
+	}
+	src.splice(at-1, at-1, lines)
+}
+
+// insertItems inserts items into a list before the item at index i, or
+// before the closing bracket when i is the number of items.
+func (src *sourceDocument) insertItems(list *syntaxNode, i int, items []*syntaxNode) {
+	indent, unit := src.childIndent(list)
+	var lines []string
+	for _, item := range items {
+		lines = append(lines, render(item, indent, unit)...)
+	}
+	at := list.EndLine
+	if i < len(list.Children) {
+		at = src.startLine(list.Children[i])
+	}
+	src.splice(at-1, at-1, lines)
+}
+
+// unitOf returns the indentation unit used inside a block or list.
+func (src *sourceDocument) unitOf(parent *syntaxNode) string {
+	_, unit := src.childIndent(parent)
+	return unit
+}
+
+// remove deletes the node at path together with the comment lines directly
+// above it. Duplicate keys are all removed.
+func (src *sourceDocument) remove(path []pathSegment) error {
+	node, parent, found, err := src.find(path)
+	if err != nil {
+		return err
+	}
+	if found < len(path) {
+		return fmt.Errorf("%s: not found", formatEditPath(path))
+	}
+
+	targets := []*syntaxNode{node}
+	if parent.Kind == blockSyntax {
+		targets = nil
+		for _, child := range parent.Children {
+			if child.Key == node.Key {
+				targets = append(targets, child)
+			}
+		}
+	}
+	// Remove from the bottom up so earlier line numbers stay valid.
+	for i := len(targets) - 1; i >= 0; i-- {
+		src.removeNode(targets[i])
+	}
+	return nil
+}
+
+// removeNode deletes the lines of a node together with the comment lines
+// directly above it.
+func (src *sourceDocument) removeNode(node *syntaxNode) {
+	src.splice(src.startLine(node)-1, node.EndLine, nil)
+}
+
+// startLine returns the 1-based line a node starts on, counting the
+// comment lines directly above it.
+func (src *sourceDocument) startLine(node *syntaxNode) int {
+	start := node.Line
+	for j := len(node.Comments) - 1; j >= 0 && node.Comments[j] != ""; j-- {
+		start--
+	}
+	return start
+}
+
+// handleSet processes the set command.
+func (a *App) handleSet(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected a path and a value")
+	}
+	path, err := parseEditPath(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	value, typ := c.Args().Get(1), c.String("type")
+	if typ != "" && schemaTypes[typ] && !validScalar(typ, value) {
+		return fmt.Errorf("invalid %s %q", typ, value)
+	}
+	if strings.ContainsAny(typ, " \t!") {
+		return fmt.Errorf("invalid type %q", typ)
+	}
+
+	return a.editDocument(c, func(src *sourceDocument) error {
+		return src.set(path, value, typ, c.IsSet("type"), c.Bool("force"))
+	})
+}
+
+// handleDelete processes the delete command.
+func (a *App) handleDelete(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a path")
+	}
+	path, err := parseEditPath(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	return a.editDocument(c, func(src *sourceDocument) error {
+		return src.remove(path)
+	})
+}
+
+// editDocument reads the input, applies an edit and writes the result to
+// the output, or back to the input file with --write.
+func (a *App) editDocument(c *cli.Context, edit func(*sourceDocument) error) error {
+	if c.Bool("write") && (c.String("input") == "" || c.IsSet("output")) {
+		return fmt.Errorf("--write requires --input and cannot be combined with --output")
+	}
+
+	input, err := a.getInput(c.String("input"))
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	src, err := readSourceDocument(inputName(c.String("input")), input)
+	a.closeIfFile(input)
+	if err != nil {
+		return fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	if err := edit(src); err != nil {
+		return err
+	}
+
+	outputPath := c.String("output")
+	if c.Bool("write") {
+		outputPath = c.String("input")
+	}
+	output, err := a.getOutput(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	if _, err := output.Write(src.Bytes()); err != nil {
+		output.Abort()
+		return err
+	}
+	return output.Close()
+}
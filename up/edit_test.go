@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEditPath(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+		err  bool
+	}{
+		{expr: "a", want: "a"},
+		{expr: "a.b.c", want: "a.b.c"},
+		{expr: "a[0].b", want: "a[0].b"},
+		{expr: `labels."app.kubernetes.io/name"`, want: "labels.app.kubernetes.io/name"},
+		{expr: "", err: true},
+		{expr: "a..b", err: true},
+		{expr: "a.", err: true},
+		{expr: "a[x]", err: true},
+		{expr: "a[-1]", err: true},
+		{expr: "a[0", err: true},
+		{expr: "a!int", err: true},
+	}
+	for _, tt := range tests {
+		path, err := parseEditPath(tt.expr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseEditPath(%q): expected an error", tt.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseEditPath(%q): unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if got := formatEditPath(path); got != tt.want {
+			t.Errorf("parseEditPath(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+const editTestDocument = `# settings
+debug!bool true
+name app
+server {
+  # listen port
+  port!int 80
+}
+ports!int [
+  1
+  2
+]
+`
+
+func TestSourceDocumentSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		value   string
+		typ     string
+		typeSet bool
+		force   bool
+		want    string
+	}{
+		{
+			name: "keeps annotation", path: "debug", value: "false",
+			want: strings.Replace(editTestDocument, "debug!bool true", "debug!bool false", 1),
+		},
+		{
+			name: "nested", path: "server.port", value: "8080",
+			want: strings.Replace(editTestDocument, "port!int 80", "port!int 8080", 1),
+		},
+		{
+			name: "new type", path: "debug", value: "maybe", typ: "string", typeSet: true,
+			want: strings.Replace(editTestDocument, "debug!bool true", "debug!string maybe", 1),
+		},
+		{
+			name: "list item", path: "ports[1]", value: "3",
+			want: strings.Replace(editTestDocument, "  2\n", "  3\n", 1),
+		},
+		{
+			name: "new key", path: "server.host", value: "localhost",
+			want: strings.Replace(editTestDocument, "port!int 80\n", "port!int 80\n  host localhost\n", 1),
+		},
+		{
+			name: "new block", path: "tls.enabled", value: "true", typ: "bool", typeSet: true,
+			want: editTestDocument + "tls {\n  enabled!bool true\n}\n",
+		},
+		{
+			name: "forced", path: "server", value: "none", force: true,
+			want: strings.Replace(editTestDocument, "server {\n  # listen port\n  port!int 80\n}\n", "server none\n", 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := readSourceDocument("test.up", strings.NewReader(editTestDocument))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, err := parseEditPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := src.set(path, tt.value, tt.typ, tt.typeSet, tt.force); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := string(src.Bytes()); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceDocumentSetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		value   string
+		typ     string
+		typeSet bool
+	}{
+		{name: "invalid for kept annotation", path: "debug", value: "maybe"},
+		{name: "invalid for list type", path: "ports[0]", value: "x"},
+		{name: "typed list item", path: "ports[0]", value: "3", typ: "int", typeSet: true},
+		{name: "block without force", path: "server", value: "x"},
+		{name: "list without force", path: "ports", value: "x"},
+		{name: "index out of range", path: "ports[5]", value: "1"},
+		{name: "create list item", path: "missing[0]", value: "1"},
+		{name: "scalar is not a block", path: "name.first", value: "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := readSourceDocument("test.up", strings.NewReader(editTestDocument))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, err := parseEditPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := src.set(path, tt.value, tt.typ, tt.typeSet, false); err == nil {
+				t.Errorf("expected an error, got:\n%s", src.Bytes())
+			}
+		})
+	}
+}
+
+func TestSourceDocumentRemove(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"debug", strings.Replace(editTestDocument, "# settings\ndebug!bool true\n", "", 1)},
+		{"server.port", strings.Replace(editTestDocument, "  # listen port\n  port!int 80\n", "", 1)},
+		{"ports[0]", strings.Replace(editTestDocument, "  1\n", "", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			src, err := readSourceDocument("test.up", strings.NewReader(editTestDocument))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, err := parseEditPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := src.remove(path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := string(src.Bytes()); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+
+	src, err := readSourceDocument("test.up", strings.NewReader(editTestDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.remove([]pathSegment{{Key: "missing"}}); err == nil {
+		t.Error("removing a missing key: expected an error")
+	}
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// invocationPattern matches a namespace function call such as
+// @string.upper or @math.add(1, 2).
+var invocationPattern = regexp.MustCompile(`^@([A-Za-z_][A-Za-z0-9_-]*)\.([A-Za-z_][A-Za-z0-9_.-]*)(?:\((.*)\))?$`)
+
+// namespace evaluates the functions of a single @namespace.
+type namespace interface {
+	// Call invokes a function and returns its result as a format-neutral
+	// value (string, bool, int64, float64, nil, []any or object).
+	Call(ctx context.Context, call invocation) (any, error)
+}
+
+// invocation is a single namespace function call found in a document.
+type invocation struct {
+	Namespace string
+	Function  string
+	Args      []string
+	// Key is the key path of the value being evaluated.
+	Key string
+	// Type is the type annotation declared on the key, if any.
+	Type string
+}
+
+// handleEval processes the eval command.
+func (a *App) handleEval(c *cli.Context) error {
+	root, err := a.readSyntax(c.String("input"))
+	if err != nil {
+		return err
+	}
+
+	opts := evalOptions{nsPath: filepath.SplitList(c.String("ns-path"))}
+	if !c.IsSet("ns-path") {
+		pc, err := a.project()
+		if err != nil {
+			return err
+		}
+		if pc.nsPath != nil {
+			opts.nsPath = pc.nsPath
+		}
+	}
+	if c.IsSet("seed") {
+		seed := c.Uint64("seed")
+		opts.seed = &seed
+	}
+	if c.IsSet("now") {
+		if opts.now, err = parseNow(c.String("now")); err != nil {
+			return err
+		}
+	}
+
+	ev := newEvaluator(opts)
+	if err := ev.evalDocument(c.Context, root); err != nil {
+		return fmt.Errorf("evaluation failed: %w", err)
+	}
+
+	if !c.Bool("json") && !c.Bool("pretty") {
+		return a.writeEncoded(c.String("output"), func(w io.Writer) error {
+			return formatSyntax(w, root, defaultFormatOptions)
+		})
+	}
+	obj, err := valueFromSyntax("", "", root)
+	if err != nil {
+		return err
+	}
+	return a.writeConvertedObject(c.String("output"), jsonCodec{}, obj.(object), c.Bool("pretty"))
+}
+
+// evaluator substitutes namespace function calls in a document with their
+// results.
+type evaluator struct {
+	opts       evalOptions
+	builtins   map[string]namespace
+	namespaces map[string]namespace
+}
+
+// evalOptions configures an evaluator.
+type evalOptions struct {
+	// nsPath lists the directories searched for namespace executables.
+	nsPath []string
+	// seed, when set, seeds @random and is passed on to plugins.
+	seed *uint64
+	// now, when set, is the frozen clock used by @time and passed on to
+	// plugins.
+	now time.Time
+}
+
+// newEvaluator creates an evaluator that looks up namespaces on the
+// namespace path before falling back to the built-in namespaces.
+func newEvaluator(opts evalOptions) *evaluator {
+	clock := time.Now
+	if !opts.now.IsZero() {
+		clock = func() time.Time { return opts.now }
+	}
+
+	var source rand.Source = rand.NewPCG(rand.Uint64(), rand.Uint64())
+	if opts.seed != nil {
+		source = rand.NewPCG(*opts.seed, 0)
+	}
+
+	return &evaluator{
+		opts:       opts,
+		builtins:   builtinNamespaces(clock, rand.New(source)),
+		namespaces: make(map[string]namespace),
+	}
+}
+
+// parseNow parses the value of the --now flag.
+func parseNow(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --now %q: expected an RFC 3339 timestamp or a date", s)
+}
+
+// evalDocument evaluates the entries of a document's syntax tree in place.
+// Entries are evaluated in source order, so seeded evaluations are
+// repeatable, and everything but the evaluated values, including comments,
+// is kept.
+func (e *evaluator) evalDocument(ctx context.Context, root *syntaxNode) error {
+	return e.evalEntries(ctx, "", root)
+}
+
+// evalEntries evaluates the entries of a block.
+func (e *evaluator) evalEntries(ctx context.Context, path string, block *syntaxNode) error {
+	for i, child := range block.Children {
+		node, _, err := e.evalNode(ctx, keyPath(path, child.Key), child.Type, child)
+		if err != nil {
+			return err
+		}
+		block.Children[i] = node
+	}
+	return nil
+}
+
+// evalNode evaluates a node and returns the node holding the result with
+// its type annotation. typ is the annotation of the node, or of the list
+// holding it. A declared annotation is kept, and function results must be
+// valid values of it; otherwise the annotation comes from the type of the
+// function result. The items of a list take the list's annotation, and a
+// list without one is annotated with the type its items evaluate to.
+func (e *evaluator) evalNode(ctx context.Context, path, typ string, node *syntaxNode) (*syntaxNode, string, error) {
+	switch node.Kind {
+	case blockSyntax:
+		return node, typ, e.evalEntries(ctx, path, node)
+	case listSyntax:
+		types := make(map[string]bool)
+		for i, child := range node.Children {
+			item, itemType, err := e.evalNode(ctx, fmt.Sprintf("%s[%d]", path, i), typ, child)
+			if err != nil {
+				return nil, "", err
+			}
+			if item.Kind != blockSyntax {
+				types[itemType] = true
+			}
+			node.Children[i] = item
+		}
+		if typ == "" {
+			listType, err := listType(path, types)
+			if err != nil {
+				return nil, "", err
+			}
+			node.Type = listType
+		}
+		return node, node.Type, nil
+	case inlineListSyntax:
+		types := make(map[string]bool)
+		for i, item := range node.Items {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			itemType, result, err := e.evalString(ctx, itemPath, typ, item)
+			if err != nil {
+				return nil, "", err
+			}
+			if result != nil {
+				_, value, err := fromValue(itemPath, result)
+				if err != nil {
+					return nil, "", err
+				}
+				s, ok := value.(string)
+				if !ok {
+					return nil, "", fmt.Errorf("%s: a block or list result cannot be an inline list item", itemPath)
+				}
+				node.Items[i] = s
+			}
+			types[itemType] = true
+		}
+		if typ != "" {
+			return node, typ, nil
+		}
+		listType, err := listType(path, types)
+		return node, listType, err
+	default:
+		text := node.Text
+		if node.Kind == multilineSyntax {
+			text = strings.Join(node.Lines, "\n")
+			if n, ok := dedentWidth(typ); ok {
+				text = dedent(text, n)
+			}
+		}
+		resultType, result, err := e.evalString(ctx, path, typ, text)
+		if err != nil || result == nil {
+			return node, resultType, err
+		}
+		return resultNode(path, node, resultType, result)
+	}
+}
+
+// evalString evaluates a scalar and returns its resulting type annotation.
+// The result is the format-neutral value to write in place of the scalar,
+// or nil when the scalar is not a function call and stays as written.
+func (e *evaluator) evalString(ctx context.Context, path, typ, s string) (string, any, error) {
+	if strings.HasPrefix(s, "@@") {
+		return typ, s[1:], nil
+	}
+	call, ok, err := parseInvocation(s)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !ok {
+		return typ, nil, nil
+	}
+	call.Key, call.Type = path, typ
+
+	result, err := e.call(ctx, call)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: @%s.%s: %w", path, call.Namespace, call.Function, err)
+	}
+	resultType, value, err := fromValue(path, result)
+	if err != nil {
+		return "", nil, err
+	}
+	if typ != "" {
+		if err := checkResultType(typ, value); err != nil {
+			return "", nil, fmt.Errorf("%s: @%s.%s: %w", path, call.Namespace, call.Function, err)
+		}
+		resultType = typ
+	}
+	return resultType, result, nil
+}
+
+// resultNode builds the node that replaces an evaluated scalar, keeping its
+// key and comments. List items take the annotation of their list.
+func resultNode(path string, orig *syntaxNode, typ string, result any) (*syntaxNode, string, error) {
+	var node *syntaxNode
+	var err error
+	switch {
+	case orig.Key == "":
+		node, err = syntaxFromNeutralItem(path, result)
+	case isBlockOrList(result):
+		if node, err = syntaxFromNeutral(path, orig.Key, result); err == nil {
+			node.Type = typ
+		}
+	default:
+		var value up.Value
+		if _, value, err = fromValue(path, result); err == nil {
+			node = syntaxFromValue(orig.Key, typ, value)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	node.Comments, node.Line, node.EndLine = orig.Comments, orig.Line, orig.EndLine
+	return node, typ, nil
+}
+
+// isBlockOrList reports whether a format-neutral value is an object or an
+// array.
+func isBlockOrList(v any) bool {
+	switch v.(type) {
+	case object, []any:
+		return true
+	}
+	return false
+}
+
+// checkResultType reports an error when a function result is not a valid
+// value of the type annotation declared on its key. A list's annotation
+// applies to its scalar items.
+func checkResultType(typ string, v up.Value) error {
+	switch v := v.(type) {
+	case up.Block:
+		if typ == "null" || schemaTypes[typ] && typ != "block" && typ != "any" {
+			return fmt.Errorf("result is a block, not a valid %s", typ)
+		}
+	case up.List:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				if err := checkResultType(typ, s); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if typ == "null" && v != "null" || schemaTypes[typ] && !validScalar(typ, v) {
+			return fmt.Errorf("result %q is not a valid %s", v, typ)
+		}
+	}
+	return nil
+}
+
+// call dispatches an invocation to its namespace.
+func (e *evaluator) call(ctx context.Context, call invocation) (any, error) {
+	ns, err := e.namespace(call.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.Call(ctx, call)
+}
+
+// namespace returns the namespace with the given name, looking it up the
+// first time it is used. An executable on the namespace path overrides a
+// built-in namespace of the same name.
+func (e *evaluator) namespace(name string) (namespace, error) {
+	if ns, ok := e.namespaces[name]; ok {
+		return ns, nil
+	}
+
+	var ns namespace
+	if path, ok := findNamespace(e.opts.nsPath, name); ok {
+		ns = &execNamespace{path: path, seed: e.opts.seed, now: e.opts.now}
+	} else if builtin, ok := e.builtins[name]; ok {
+		ns = builtin
+	} else {
+		return nil, fmt.Errorf("namespace %q not found in %s and is not built in", name, strings.Join(e.opts.nsPath, string(filepath.ListSeparator)))
+	}
+	e.namespaces[name] = ns
+	return ns, nil
+}
+
+// parseInvocation parses a value of the form @ns.func or @ns.func(args).
+// Arguments are separated by commas and may be double-quoted to include
+// commas or surrounding spaces.
+func parseInvocation(s string) (invocation, bool, error) {
+	m := invocationPattern.FindStringSubmatch(s)
+	if m == nil {
+		return invocation{}, false, nil
+	}
+
+	args, err := splitArgs(m[3])
+	if err != nil {
+		return invocation{}, false, fmt.Errorf("invalid arguments in %s: %w", s, err)
+	}
+	return invocation{Namespace: m[1], Function: m[2], Args: args}, true, nil
+}
+
+// splitArgs splits a comma-separated argument list.
+func splitArgs(s string) ([]string, error) {
+	args := []string{}
+	if strings.TrimSpace(s) == "" {
+		return args, nil
+	}
+
+	for _, field := range splitOutsideQuotes(s, ',') {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, `"`) {
+			var arg string
+			if err := json.Unmarshal([]byte(field), &arg); err != nil {
+				return nil, fmt.Errorf("bad quoted argument %s", field)
+			}
+			field = arg
+		}
+		args = append(args, field)
+	}
+	return args, nil
+}
+
+// splitOutsideQuotes splits s at every sep that is not inside a
+// double-quoted string.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var fields []string
+	start, quoted, escaped := 0, false, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// findNamespace looks for the executable of a namespace in the given
+// directories. For a namespace "math" it accepts dir/math, dir/up-ns-math
+// and dir/math/math, the layout of a built namespace project.
+func findNamespace(dirs []string, name string) (string, bool) {
+	candidates := []string{name, "up-ns-" + name, filepath.Join(name, name)}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		for _, candidate := range candidates {
+			path := filepath.Join(dir, candidate)
+			if runtime.GOOS == "windows" {
+				path += ".exe"
+			}
+			if isExecutable(path) {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isExecutable reports whether path is a regular file that can be executed.
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode().Perm()&0o111 != 0
+}
+
+// execNamespace is a namespace implemented by an external executable.
+//
+// For every call the executable is started with a JSON request on stdin:
+//
+//	{"namespace": "math", "function": "add", "args": ["1", "2"], "key": "total", "type": "int"}
+//
+// and must write a JSON response to stdout, either {"value": <any JSON value>}
+// or {"error": "message"}. A non-zero exit status is reported as an error
+// together with anything written to stderr. When evaluation is seeded or the
+// clock is frozen, the request also carries "seed" and "now" so that plugins
+// can be deterministic too.
+type execNamespace struct {
+	path string
+	seed *uint64
+	now  time.Time
+}
+
+// namespaceRequest is the JSON request sent to a namespace executable.
+type namespaceRequest struct {
+	Namespace string   `json:"namespace"`
+	Function  string   `json:"function"`
+	Args      []string `json:"args"`
+	Key       string   `json:"key"`
+	Type      string   `json:"type,omitempty"`
+	Seed      *uint64  `json:"seed,omitempty"`
+	Now       string   `json:"now,omitempty"`
+}
+
+// namespaceResponse is the JSON response of a namespace executable.
+type namespaceResponse struct {
+	Value json.RawMessage `json:"value"`
+	Error string          `json:"error"`
+}
+
+// formatNow formats a frozen clock for a plugin request, or returns "" when
+// the clock is not frozen.
+func formatNow(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// Call runs the executable for a single invocation.
+func (n *execNamespace) Call(ctx context.Context, call invocation) (any, error) {
+	req, err := json.Marshal(namespaceRequest{
+		Namespace: call.Namespace,
+		Function:  call.Function,
+		Args:      call.Args,
+		Key:       call.Key,
+		Type:      call.Type,
+		Seed:      n.seed,
+		Now:       formatNow(n.now),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, n.path)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", n.path, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", n.path, err)
+	}
+
+	var resp namespaceResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %w", n.path, err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Value == nil {
+		return nil, fmt.Errorf("%s: response has no value", n.path)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(resp.Value))
+	dec.UseNumber()
+	return decodeJSONValue(dec)
+}
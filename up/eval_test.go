@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInvocation(t *testing.T) {
+	tests := []struct {
+		value string
+		want  *invocation
+		err   bool
+	}{
+		{value: "plain"},
+		{value: "user@example.com"},
+		{value: "@string.upper", want: &invocation{Namespace: "string", Function: "upper", Args: []string{}}},
+		{value: "@math.add(1, 2)", want: &invocation{Namespace: "math", Function: "add", Args: []string{"1", "2"}}},
+		{value: `@string.join(", ", a, "b c")`, want: &invocation{Namespace: "string", Function: "join", Args: []string{", ", "a", "b c"}}},
+		{value: `@string.upper("x)`, err: true},
+	}
+	for _, tt := range tests {
+		call, ok, err := parseInvocation(tt.value)
+		switch {
+		case tt.err:
+			if err == nil {
+				t.Errorf("parseInvocation(%q): expected an error", tt.value)
+			}
+		case err != nil:
+			t.Errorf("parseInvocation(%q): unexpected error: %v", tt.value, err)
+		case tt.want == nil && ok:
+			t.Errorf("parseInvocation(%q) = %+v, want no invocation", tt.value, call)
+		case tt.want != nil && (!ok || !reflect.DeepEqual(call, *tt.want)):
+			t.Errorf("parseInvocation(%q) = %+v, %v; want %+v", tt.value, call, ok, *tt.want)
+		}
+	}
+}
+
+// evalTestSource evaluates a UP source with a fixed seed and clock.
+func evalTestSource(t *testing.T, source string, nsPath ...string) (string, error) {
+	t.Helper()
+	root, err := parseSyntax(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := uint64(1)
+	ev := newEvaluator(evalOptions{
+		nsPath: nsPath,
+		seed:   &seed,
+		now:    time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	})
+	if err := ev.evalDocument(context.Background(), root); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := formatSyntax(&b, root, defaultFormatOptions); err != nil {
+		t.Fatal(err)
+	}
+	return b.String(), nil
+}
+
+func TestEvalDocument(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"result type", "n @math.add(1, 2)\n", "n!int 3\n"},
+		{"declared type", "n!float @math.add(1, 2)\n", "n!float 3\n"},
+		{"declared string", "s!string @math.add(1, 2)\n", "s!string 3\n"},
+		{"timestamp", "t @time.today\n", "t!ts 2024-03-01\n"},
+		{"escaped", "e @@string.upper\n", "e @string.upper\n"},
+		{"nested", "b {\n  u @string.upper(x)\n}\n", "b {\n  u X\n}\n"},
+		{"list result type", "l [\n  @math.add(1, 2)\n  @math.mul(2, 3)\n]\n", "l!int [\n  3\n  6\n]\n"},
+		{"list widened", "l [\n  @math.add(1, 2)\n  @math.div(1, 2)\n]\n", "l!float [\n  3\n  0.5\n]\n"},
+		{"list declared", "l!int [\n  @math.add(1, 2)\n  5\n]\n", "l!int [\n  3\n  5\n]\n"},
+		{"list of strings", "l [\n  @string.upper(a)\n  b\n]\n", "l [\n  A\n  b\n]\n"},
+		{"inline list", "l [\n  [@math.abs(-3), @math.abs(4)]\n]\n", "l!int [\n  [3, 4]\n]\n"},
+		{"key order", "z @string.upper(z)\na 1\nm {\n  y @math.add(1, 1)\n  b x\n}\n", "z Z\na 1\nm {\n  y!int 2\n  b x\n}\n"},
+		{"comments", "# header\n\n# the total\nn @math.add(1, 2)\nl [\n  # first\n  @string.upper(a)\n]\n# end\n", "# header\n\n# the total\nn!int 3\nl [\n  # first\n  A\n]\n# end\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalTestSource(t, tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"declared type mismatch", "n!int @string.upper(x)\n", `n: @string.upper: result "X" is not a valid int`},
+		{"declared bool mismatch", "b!bool @math.add(1, 2)\n", `result "3" is not a valid bool`},
+		{"list item mismatch", "l!int [\n  @string.upper(a)\n]\n", `l[0]: @string.upper: result "A" is not a valid int`},
+		{"mixed list", "l [\n  @math.add(1, 2)\n  a\n]\n", "cannot represent a list mixing int, string items"},
+		{"unknown namespace", "x @nope.f\n", `namespace "nope" not found`},
+		{"unknown function", "x @string.nope\n", `unknown function "nope"`},
+		{"function error", "x @math.div(1, 0)\n", "division by zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := evalTestSource(t, tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalSeeded(t *testing.T) {
+	source := "n @random.int(1, 1000000)\nu @random.uuid\n"
+	first, err := evalTestSource(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := evalTestSource(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("seeded evaluations differ:\n%s\n%s", first, second)
+	}
+}
+
+func TestEvalPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin is a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat > /dev/null\necho '{\"value\": 42}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "answer"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	failing := "#!/bin/sh\ncat > /dev/null\necho '{\"error\": \"no answer\"}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "up-ns-broken"), []byte(failing), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := evalTestSource(t, "a @answer.get\n", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a!int 42\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := evalTestSource(t, "a @broken.get\n", dir); err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Errorf("got error %v, want the plugin's error", err)
+	}
+}
+
+func TestParseNow(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		err  bool
+	}{
+		{s: "2024-03-01T12:00:00Z", want: "2024-03-01T12:00:00Z"},
+		{s: "2024-03-01T12:00:00.5+02:00", want: "2024-03-01T12:00:00.5+02:00"},
+		{s: "2024-03-01", want: "2024-03-01T00:00:00Z"},
+		{s: "yesterday", err: true},
+		{s: "2024-03-01 12:00", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNow(tt.s)
+		if tt.err != (err != nil) || !tt.err && formatNow(got) != tt.want {
+			t.Errorf("parseNow(%q) = %s, %v; want %s, error %v", tt.s, formatNow(got), err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestEvalDeterministicFlags(t *testing.T) {
+	source := "n @random.int(1, 1000000)\ns @random.string(12)\nt @time.now\nd @time.today\n"
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := runApp(t, source, append([]string{"eval"}, args...)...)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", args, err)
+		}
+		return out
+	}
+
+	first := run("--seed", "7", "--now", "2024-03-01T12:00:00Z")
+	if second := run("--seed", "7", "--now", "2024-03-01T12:00:00Z"); first != second {
+		t.Errorf("same seed and clock gave different results:\n%s\n%s", first, second)
+	}
+	for _, want := range []string{"t!ts 2024-03-01T12:00:00Z\n", "d!ts 2024-03-01\n"} {
+		if !strings.Contains(first, want) {
+			t.Errorf("result does not contain %q:\n%s", want, first)
+		}
+	}
+	if other := run("--seed", "8", "--now", "2024-03-01T12:00:00Z"); other == first {
+		t.Errorf("different seeds gave the same result:\n%s", first)
+	}
+	if _, err := runApp(t, source, "eval", "--now", "soon"); err == nil || !strings.Contains(err.Error(), `invalid --now "soon"`) {
+		t.Errorf("got error %v, want an invalid --now error", err)
+	}
+}
+
+func TestEvalPluginReceivesSeedAndNow(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin is a shell script")
+	}
+	dir := t.TempDir()
+	// The plugin answers with the request it received.
+	script := "#!/bin/sh\nprintf '{\"value\": %s}' \"$(cat | sed 's/\"/\\\\\"/g; s/.*/\"&\"/')\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "echo"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runApp(t, "r @echo.request\n", "eval", "--ns-path", dir, "--seed", "42", "--now", "2024-03-01T12:00:00Z")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{`"seed":42`, `"now":"2024-03-01T12:00:00Z"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plugin request does not contain %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestEvalMathErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"n @math.add(9223372036854775807, 1)\n", "n: @math.add: integer overflow"},
+		{"n @math.sqrt(-1)\n", "n: @math.sqrt: result NaN is not a finite number"},
+		{"n @math.pow(10, 400)\n", "n: @math.pow: result +Inf is not a finite number"},
+	}
+	for _, tt := range tests {
+		for _, args := range [][]string{{"eval"}, {"eval", "--json"}} {
+			_, err := runApp(t, tt.source, args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%v %q: got error %v, want one containing %q", args, tt.source, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestEvalJSONKeepsKeyOrder(t *testing.T) {
+	got, err := runApp(t, "z @math.add(1, 2)\na {\n  y x\n  b @string.upper(b)\n}\n", "eval", "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"z":3,"a":{"y":"x","b":"B"}}`; strings.TrimSpace(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ignoreFile is the name of the file listing ignore patterns for a directory
+// tree, one per line.
+const ignoreFile = ".upignore"
+
+// expandPaths resolves file, directory and glob arguments to a sorted list of
+// files without duplicates. Directories are searched recursively for *.up
+// files, skipping hidden directories and paths matching the ignore patterns;
+// files named explicitly are used whatever their extension.
+func expandPaths(args, ignore []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(p string) {
+		p = filepath.Clean(p)
+		if !seen[p] {
+			seen[p] = true
+			files = append(files, p)
+		}
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			found, err := findUPFiles(match, ignore)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range found {
+				add(p)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// findUPFiles returns the *.up files below dir that are not ignored. The
+// patterns in dir's .upignore file apply in addition to ignore.
+func findUPFiles(dir string, ignore []string) ([]string, error) {
+	patterns, err := readIgnoreFile(filepath.Join(dir, ignoreFile))
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, ignore...)
+
+	var files []string
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") || ignored(patterns, rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) == ".up" && !ignored(patterns, rel, false) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// readIgnoreFile reads the patterns of an ignore file. Blank lines and lines
+// starting with # are skipped. A missing file holds no patterns.
+func readIgnoreFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); !skipLine(line) {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// ignored reports whether a slash-separated path relative to the walked
+// directory matches an ignore pattern. Patterns use path.Match syntax. A
+// pattern containing a slash matches the whole relative path, otherwise it
+// matches the last element at any depth; a trailing slash restricts it to
+// directories.
+func ignored(patterns []string, rel string, isDir bool) bool {
+	for _, pattern := range patterns {
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = strings.TrimSuffix(pattern, "/")
+		if dirOnly && !isDir {
+			continue
+		}
+
+		name := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			pattern, name = strings.TrimPrefix(pattern, "/"), rel
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// atomicFile is a file written under a temporary name and renamed into place
+// when closed, so an interrupted write never leaves a truncated file behind
+// and the destination may also be the file being read.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+// createAtomic creates an atomicFile that replaces path when closed. An
+// existing file keeps its permissions.
+func createAtomic(filename string) (*atomicFile, error) {
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &atomicFile{File: tmp, path: filename}, nil
+}
+
+// Close flushes the file to disk and renames it into place.
+func (f *atomicFile) Close() error {
+	err := f.File.Sync()
+	if closeErr := f.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// Abort closes and removes the temporary file, leaving the destination
+// untouched. It is used instead of Close when writing failed.
+func (f *atomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// writeFileAtomic replaces a file's contents atomically.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := createAtomic(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Close()
+}
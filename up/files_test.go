@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files, given by slash-separated path, below dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.up":                 "",
+		"b.json":               "",
+		"sub/c.up":             "",
+		"sub/deep/d.up":        "",
+		"vendor/e.up":          "",
+		".hidden/f.up":         "",
+		"gen/g.up":             "",
+		"gen/keep/h.up":        "",
+		"gen/skip.gen.up":      "",
+		"withignore/i.up":      "",
+		"withignore/j.up":      "",
+		"withignore/.upignore": "# generated\n\nj.up\n",
+	})
+
+	tests := []struct {
+		name   string
+		args   []string
+		ignore []string
+		want   []string
+	}{
+		{
+			name: "directory",
+			args: []string{"sub"},
+			want: []string{"sub/c.up", "sub/deep/d.up"},
+		},
+		{
+			name:   "ignore patterns",
+			args:   []string{"."},
+			ignore: []string{"vendor/", "*.gen.up", "/gen/keep"},
+			want:   []string{"a.up", "gen/g.up", "sub/c.up", "sub/deep/d.up", "withignore/i.up", "withignore/j.up"},
+		},
+		{
+			name: "ignore file",
+			args: []string{"withignore"},
+			want: []string{"withignore/i.up"},
+		},
+		{
+			name: "glob and duplicates",
+			args: []string{"*.up", "a.up", "b.json"},
+			want: []string{"a.up", "b.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			for _, arg := range tt.args {
+				args = append(args, filepath.Join(dir, arg))
+			}
+			got, err := expandPaths(args, tt.ignore)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i, p := range got {
+				rel, _ := filepath.Rel(dir, p)
+				got[i] = filepath.ToSlash(rel)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPathsErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{"no matches", filepath.Join(dir, "*.up"), "no files match"},
+		{"bad pattern", filepath.Join(dir, "[.up"), "invalid pattern"},
+		{"missing file", filepath.Join(dir, "missing.up"), "missing.up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := expandPaths([]string{tt.arg}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.gen.up", "a/b.gen.up", false, true},
+		{"*.gen.up", "a/b.up", false, false},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"a/b.up", "a/b.up", false, true},
+		{"a/b.up", "x/a/b.up", false, false},
+		{"/a/*", "a/c.up", false, true},
+	}
+	for _, tt := range tests {
+		if got := ignored([]string{tt.pattern}, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, %q, %v) = %v, want %v", tt.pattern, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.up")
+	if err := os.WriteFile(filename, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filename, []byte("new\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new\n" {
+		t.Errorf("got %q, want %q", data, "new\n")
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("permissions not kept: %v %v", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestAtomicFileAbort(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.up")
+	if err := os.WriteFile(filename, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createAtomic(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	f.Abort()
+
+	if data, _ := os.ReadFile(filename); string(data) != "old\n" {
+		t.Errorf("aborted write replaced the file: %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/urfave/cli/v2"
+)
+
+// formatOptions controls how a syntax tree is written.
+type formatOptions struct {
+	// Indent is the number of spaces per nesting level.
+	Indent int
+	// SortKeys orders block entries by key. Comments move with the entry
+	// they precede, and list items keep their order.
+	SortKeys bool
+}
+
+// defaultFormatOptions are the options used unless overridden.
+var defaultFormatOptions = formatOptions{Indent: 2}
+
+// formatSyntax writes a syntax tree in canonical form. Runs of blank lines
+// collapse to one, blank lines at the start and end of a block are dropped,
+// and everything is re-indented. Formatting the output again yields the same
+// bytes.
+func formatSyntax(w io.Writer, root *syntaxNode, opts formatOptions) error {
+	f := &formatter{w: bufio.NewWriter(w), opts: opts, unit: strings.Repeat(" ", opts.Indent)}
+	f.writeChildren(root, 0)
+	f.writeComments(root.Trailer, 0, len(root.Children) == 0, true)
+	return f.w.Flush()
+}
+
+// formatter holds the state of a formatSyntax call.
+type formatter struct {
+	w    *bufio.Writer
+	opts formatOptions
+	// prefix is written before every line except multiline string bodies.
+	prefix string
+	// unit is the indentation of one nesting level.
+	unit string
+}
+
+// line writes a single line at the given depth.
+func (f *formatter) line(depth int, text string) {
+	if text != "" {
+		f.w.WriteString(f.prefix)
+		f.w.WriteString(strings.Repeat(f.unit, depth))
+		f.w.WriteString(text)
+	}
+	f.w.WriteByte('\n')
+}
+
+// writeChildren writes the entries of a block or the items of a list. When
+// sorting, comments separated from the first entry by a blank line are kept
+// at the top as a header instead of moving with the entry.
+func (f *formatter) writeChildren(parent *syntaxNode, depth int) {
+	children := parent.Children
+	var header []string
+	if f.opts.SortKeys && parent.Kind == blockSyntax && len(children) > 0 {
+		comments := children[0].Comments
+		for i := len(comments) - 1; i >= 0; i-- {
+			if comments[i] == "" {
+				header = comments[:i+1]
+				break
+			}
+		}
+		f.writeComments(header, depth, true, false)
+
+		children = append([]*syntaxNode(nil), children...)
+		sort.SliceStable(children, func(i, j int) bool { return children[i].Key < children[j].Key })
+	}
+
+	first := !hasComment(header)
+	for _, child := range children {
+		comments := child.Comments
+		if header != nil && child == parent.Children[0] {
+			comments = comments[len(header):]
+		}
+		f.writeComments(comments, depth, first, false)
+		f.writeNode(child, depth)
+		first = false
+	}
+}
+
+// hasComment reports whether comment lines hold anything but blank lines.
+func hasComment(comments []string) bool {
+	for _, c := range comments {
+		if c != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeComments writes comment lines, collapsing runs of blank lines. Blank
+// lines are dropped entirely at the start of a block (first) and at its end
+// (last).
+func (f *formatter) writeComments(comments []string, depth int, first, last bool) {
+	blank := false
+	for _, c := range comments {
+		if c == "" {
+			blank = true
+			continue
+		}
+		if blank && !first {
+			f.line(depth, "")
+		}
+		blank, first = false, false
+		f.line(depth, c)
+	}
+	if blank && !first && !last {
+		f.line(depth, "")
+	}
+}
+
+// writeNode writes a block entry or list item and its value.
+func (f *formatter) writeNode(node *syntaxNode, depth int) {
+	prefix := joinKey(node.Key, node.Type)
+	if prefix != "" {
+		prefix += " "
+	}
+
+	switch node.Kind {
+	case multilineSyntax:
+		f.line(depth, prefix+"```"+node.Fence)
+		for _, l := range node.Lines {
+			f.w.WriteString(l)
+			f.w.WriteByte('\n')
+		}
+		f.line(depth, "```")
+	case blockSyntax:
+		f.line(depth, prefix+"{")
+		f.writeChildren(node, depth+1)
+		f.writeComments(node.Trailer, depth+1, len(node.Children) == 0, true)
+		f.line(depth, "}")
+	case listSyntax:
+		f.line(depth, prefix+"[")
+		f.writeChildren(node, depth+1)
+		f.writeComments(node.Trailer, depth+1, len(node.Children) == 0, true)
+		f.line(depth, "]")
+	case inlineListSyntax:
+		f.line(depth, prefix+"["+strings.Join(node.Items, ", ")+"]")
+	case conflictSyntax:
+		// Markers start at the beginning of the line, where git and editors
+		// look for them.
+		for i, version := range node.Children {
+			f.w.WriteString(node.Items[i] + "\n")
+			if version != nil {
+				f.writeNode(version, depth)
+			}
+		}
+		f.w.WriteString(node.Items[2] + "\n")
+	default:
+		f.line(depth, strings.TrimSuffix(prefix+node.Text, " "))
+	}
+}
+
+// formatSource validates and formats the source of a UP document, with
+// syntax errors attributed to the named file.
+func (a *App) formatSource(filename string, data []byte, opts formatOptions) ([]byte, error) {
+	if _, err := a.parser.ParseDocument(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	tree, err := parseSyntax(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", withSource(err, filename, data))
+	}
+
+	var buf bytes.Buffer
+	if err := formatSyntax(&buf, tree, opts); err != nil {
+		return nil, fmt.Errorf("failed to format document: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// formatResult is the outcome of formatting one file.
+type formatResult struct {
+	changed   bool
+	formatted []byte
+	diff      []byte
+	err       error
+}
+
+// formatFiles formats the files, directories and globs given as arguments
+// on a bounded pool of workers. With --write changed files are replaced
+// atomically. With --check it lists the files whose formatting differs and
+// fails if there are any; with --diff it prints what would change. Without
+// any of these the formatted files are written to the output. Results are
+// reported in file name order.
+func (a *App) formatFiles(c *cli.Context, args []string, opts formatOptions) error {
+	check, diff, write := c.Bool("check"), c.Bool("diff"), c.Bool("write")
+
+	files, err := expandPaths(args, c.StringSlice("ignore"))
+	if err != nil {
+		return err
+	}
+
+	results := make([]formatResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, min(c.Int("jobs"), len(files))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				data, err := os.ReadFile(files[i])
+				if err != nil {
+					results[i].err = err
+					continue
+				}
+				formatted, err := a.formatSource(files[i], data, opts)
+				if err != nil {
+					results[i].err = err
+					continue
+				}
+				if !check && !diff && !write {
+					results[i].formatted = formatted
+				}
+
+				results[i].changed = !bytes.Equal(data, formatted)
+				if results[i].changed && diff {
+					var buf bytes.Buffer
+					if err := writeDiff(&buf, files[i], data, formatted); err != nil {
+						results[i].err = err
+						continue
+					}
+					results[i].diff = buf.Bytes()
+				}
+				if results[i].changed && write {
+					results[i].err = writeFileAtomic(files[i], formatted)
+				}
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var unformatted, failed int
+	var diags diagnosticError
+	for i, result := range results {
+		if result.err != nil {
+			// Syntax errors are reported together, with their source lines.
+			var fileDiags diagnosticError
+			if errors.As(result.err, &fileDiags) {
+				diags = append(diags, fileDiags...)
+			} else {
+				fmt.Fprintf(c.App.ErrWriter, "%s: %v\n", files[i], result.err)
+			}
+			failed++
+			continue
+		}
+		if !check && !diff && !write {
+			if _, err := a.output.Write(result.formatted); err != nil {
+				return err
+			}
+			continue
+		}
+		if !result.changed {
+			continue
+		}
+		unformatted++
+		if check {
+			fmt.Fprintln(a.output, files[i])
+		}
+		if _, err := a.output.Write(result.diff); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case len(diags) > 0:
+		return diags
+	case failed > 0:
+		return fmt.Errorf("%d file(s) could not be formatted", failed)
+	case check && !write && unformatted > 0:
+		return fmt.Errorf("%d file(s) are not formatted", unformatted)
+	}
+	return nil
+}
+
+// writeDiff writes a unified diff between a file and its new contents.
+func writeDiff(w io.Writer, filename string, before, after []byte) error {
+	return difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        diffLines(before),
+		B:        diffLines(after),
+		FromFile: filename + ".orig",
+		ToFile:   filename,
+		Context:  3,
+	})
+}
+
+// diffLines splits contents into newline-terminated lines for a diff.
+// Unlike difflib.SplitLines it adds no empty line after a final newline, and
+// only terminates a last line that lacks one.
+func diffLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	up "github.com/uplang/go"
+)
+
+func TestFormatSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		opts   formatOptions
+		want   string
+	}{
+		{
+			name:   "reindents and trims",
+			source: "server {\n      host   h\n\tport!int 80   \n}\n",
+			want:   "server {\n  host h\n  port!int 80\n}\n",
+		},
+		{
+			name:   "keeps comments",
+			source: "# header\nname app # not a comment\nserver {\n  # port\n  port!int 80\n  # trailing\n}\n# end\n",
+			want:   "# header\nname app # not a comment\nserver {\n  # port\n  port!int 80\n  # trailing\n}\n# end\n",
+		},
+		{
+			name:   "collapses blank lines",
+			source: "\n\na 1\n\n\n\nb 2\nc {\n\n  d 3\n\n}\n\n\n",
+			want:   "a 1\n\nb 2\nc {\n  d 3\n}\n",
+		},
+		{
+			name:   "indent width",
+			source: "a {\n  b [\n    c\n  ]\n}\n",
+			opts:   formatOptions{Indent: 4},
+			want:   "a {\n    b [\n        c\n    ]\n}\n",
+		},
+		{
+			name:   "multiline body untouched",
+			source: "a {\n    text ```\n  keep   this  \n    ```\n}\n",
+			want:   "a {\n  text ```\n  keep   this  \n  ```\n}\n",
+		},
+		{
+			name:   "inline list",
+			source: "m [\n  [1,2,  3]\n]\n",
+			want:   "m [\n  [1, 2, 3]\n]\n",
+		},
+		{
+			name:   "sort keys moves comments with entries",
+			source: "# header\n\n# about b\nb 2\na 1\nc {\n  z 1\n  y 2\n}\nl [\n  b\n  a\n]\n",
+			opts:   formatOptions{Indent: 2, SortKeys: true},
+			want:   "# header\n\na 1\n# about b\nb 2\nc {\n  y 2\n  z 1\n}\nl [\n  b\n  a\n]\n",
+		},
+		{
+			name:   "empty block",
+			source: "a {\n\n}\n",
+			want:   "a {\n}\n",
+		},
+		{
+			name:   "only comments",
+			source: "\n# just this\n\n",
+			want:   "# just this\n",
+		},
+	}
+	a := &App{parser: up.NewParser()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.Indent == 0 {
+				tt.opts.Indent = defaultFormatOptions.Indent
+			}
+			got, err := a.formatSource("test.up", []byte(tt.source), tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			again, err := a.formatSource("test.up", got, tt.opts)
+			if err != nil {
+				t.Fatalf("formatting the output: %v", err)
+			}
+			if string(again) != string(got) {
+				t.Errorf("formatting is not idempotent:\n%s\nthen:\n%s", got, again)
+			}
+		})
+	}
+}
+
+func TestFormatSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"unterminated block", "a {\n  b c\n", "test.up:1:3: unterminated block: missing }"},
+		{"stray bracket", "a b\n]\n", "test.up:2:1: unexpected ]"},
+	}
+	a := &App{parser: up.NewParser()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.formatSource("test.up", []byte(tt.source), defaultFormatOptions)
+			var diags diagnosticError
+			if !errors.As(err, &diags) {
+				t.Fatalf("expected diagnostics, got %v", err)
+			}
+			if got := diags.Error(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCheckAndDiff(t *testing.T) {
+	const messy = "a   1\nb {\n    c 2\n}\n"
+	const tidy = "a 1\nb {\n  c 2\n}\n"
+	tests := []struct {
+		name    string
+		stdin   string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "check formatted", stdin: tidy, args: []string{"--check"}},
+		{name: "check unformatted", stdin: messy, args: []string{"--check"}, want: "<stdin>\n", wantErr: "<stdin> is not formatted"},
+		{name: "diff formatted", stdin: tidy, args: []string{"--diff"}},
+		{
+			name:  "diff unformatted",
+			stdin: messy,
+			args:  []string{"--diff"},
+			want:  "--- <stdin>.orig\n+++ <stdin>\n@@ -1,4 +1,4 @@\n-a   1\n+a 1\n b {\n-    c 2\n+  c 2\n }\n",
+		},
+		{
+			name:    "check and diff",
+			stdin:   messy,
+			args:    []string{"-l", "-d"},
+			want:    "<stdin>\n--- <stdin>.orig\n+++ <stdin>\n@@ -1,4 +1,4 @@\n-a   1\n+a 1\n b {\n-    c 2\n+  c 2\n }\n",
+			wantErr: "<stdin> is not formatted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, tt.stdin, append([]string{"format"}, tt.args...)...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	tidy := filepath.Join(dir, "tidy.up")
+	messy := filepath.Join(dir, "messy.up")
+	for name, content := range map[string]string{tidy: "a 1\n", messy: "a   1\n"} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := runApp(t, "", "format", "--check", tidy, messy)
+	if err == nil || err.Error() != "1 file(s) are not formatted" {
+		t.Errorf("got error %v, want one unformatted file", err)
+	}
+	if got != messy+"\n" {
+		t.Errorf("got %q, want only %s listed", got, messy)
+	}
+
+	got, err = runApp(t, "", "format", "--diff", tidy, messy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "--- " + messy + ".orig\n+++ " + messy + "\n@@ -1 +1 @@\n-a   1\n+a 1\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if data, _ := os.ReadFile(messy); string(data) != "a   1\n" {
+		t.Errorf("--diff changed the file: %q", data)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"", nil},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b\n"}},
+		{"a\n\n", []string{"a\n", "\n"}},
+	}
+	for _, tt := range tests {
+		got := diffLines([]byte(tt.data))
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("diffLines(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestFormatWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.up":          "a   1\n",
+		"sub/b.up":      "b {\n c 2\n}\n",
+		"sub/tidy.up":   "t 1\n",
+		"vendor/v.up":   "v   1\n",
+		"broken/bad.up": "x {\n",
+	})
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	out, err := runApp(t, "", "format", "-w", "--ignore", "vendor/", "--ignore", "broken/", "-j", "2", dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "" {
+		t.Errorf("unexpected output %q", out)
+	}
+	for name, want := range map[string]string{
+		"a.up":        "a 1\n",
+		"sub/b.up":    "b {\n  c 2\n}\n",
+		"sub/tidy.up": "t 1\n",
+		"vendor/v.up": "v   1\n",
+	} {
+		if got := read(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	_, err = runApp(t, "", "format", "-w", dir)
+	var diags diagnosticError
+	if !errors.As(err, &diags) || len(diags) != 1 || diags[0].File != filepath.Join(dir, "broken", "bad.up") {
+		t.Errorf("got error %v, want a syntax error in broken/bad.up", err)
+	}
+	if got := read("vendor/v.up"); got != "v 1\n" {
+		t.Errorf("other files were not written despite the error: %q", got)
+	}
+	if got := read("broken/bad.up"); got != "x {\n" {
+		t.Errorf("broken file was changed: %q", got)
+	}
+}
+
+func TestFormatArgumentErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"write without files", []string{"-w"}, "--write requires file arguments or --input without --output"},
+		{"files with output", []string{"-o", "x.up", "a.up"}, "--input and --output cannot be combined with file arguments"},
+		{"negative indent", []string{"--indent", "-1"}, "invalid indent -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, "a 1\n", append([]string{"format"}, tt.args...)...)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
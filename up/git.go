@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// handleGitMergeDriver processes the git merge-driver command. It merges
+// the ancestor (%O), current (%A) and other (%B) versions of a file key by
+// key and writes the result over the current version, as git expects. Only
+// entries both sides changed differently are left in conflict, marked with
+// conflict markers. Files that do not parse fall back to git merge-file.
+func (a *App) handleGitMergeDriver(c *cli.Context) error {
+	if c.NArg() != 3 {
+		return fmt.Errorf("expected the ancestor, current and other versions (%%O %%A %%B)")
+	}
+	args := c.Args().Slice()
+	size := c.Int("marker-size")
+	if size < 1 {
+		return fmt.Errorf("invalid marker size %d", size)
+	}
+
+	trees := make([]*syntaxNode, 3)
+	for i, filename := range args {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		if _, err := parseSource(filename, data); err != nil {
+			// Syntax errors already name the file.
+			var diags diagnosticError
+			if !errors.As(err, &diags) {
+				err = fmt.Errorf("%s: %w", filename, err)
+			}
+			fmt.Fprintf(c.App.ErrWriter, "%v; falling back to a line-based merge\n", err)
+			return mergeFile(args, size)
+		}
+		if trees[i], err = parseSyntax(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+	}
+
+	markers := []string{
+		strings.Repeat("<", size) + " ours",
+		strings.Repeat("=", size),
+		strings.Repeat(">", size) + " theirs",
+	}
+	merged, conflicts := merge3Block(trees[0], trees[1], trees[2], markers)
+
+	pc, err := a.project()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := formatSyntax(&buf, merged, pc.format); err != nil {
+		return fmt.Errorf("failed to format document: %w", err)
+	}
+	if err := writeFileAtomic(args[1], buf.Bytes()); err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d conflicting key(s)", conflicts)
+	}
+	return nil
+}
+
+// mergeFile merges the versions line by line with git merge-file, leaving
+// the result in the current version.
+func mergeFile(args []string, size int) error {
+	cmd := exec.Command("git", "merge-file", fmt.Sprintf("--marker-size=%d", size),
+		"-L", "ours", "-L", "base", "-L", "theirs", args[1], args[0], args[2])
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git merge-file: %w", err)
+	}
+	return nil
+}
+
+// merge3Block merges the entries of our and their version of a block, given
+// the version they share. Our entries keep their order, followed by the
+// entries only they added. It returns the merged block and the number of
+// conflicts in it.
+func merge3Block(base, ours, theirs *syntaxNode, markers []string) (*syntaxNode, int) {
+	merged := *ours
+	merged.Children = nil
+	conflicts := 0
+
+	add := func(b, o, t *syntaxNode) {
+		node, n := merge3Entry(b, o, t, markers)
+		if node != nil {
+			merged.Children = append(merged.Children, node)
+		}
+		conflicts += n
+	}
+	for _, o := range ours.Children {
+		add(childByKey(base, o.Key), o, childByKey(theirs, o.Key))
+	}
+	for _, t := range theirs.Children {
+		if childByKey(ours, t.Key) == nil {
+			add(childByKey(base, t.Key), nil, t)
+		}
+	}
+	return &merged, conflicts
+}
+
+// merge3Entry merges our and their version of an entry, any of which may be
+// nil when the entry is absent. A change on one side wins over no change on
+// the other. Blocks both sides changed are merged key by key; any other
+// entry both sides changed differently becomes a conflict.
+func merge3Entry(base, ours, theirs *syntaxNode, markers []string) (*syntaxNode, int) {
+	switch {
+	case sameSyntax(ours, theirs):
+		return ours, 0
+	case sameSyntax(base, ours):
+		// Keep comments we edited on an entry only they changed.
+		if ours != nil && theirs != nil && !slices.Equal(ours.Comments, base.Comments) {
+			node := *theirs
+			node.Comments = ours.Comments
+			return &node, 0
+		}
+		return theirs, 0
+	case sameSyntax(base, theirs):
+		return ours, 0
+	}
+
+	if ours != nil && theirs != nil && ours.Kind == blockSyntax && theirs.Kind == blockSyntax &&
+		(base == nil || base.Kind == blockSyntax) {
+		if base == nil {
+			base = &syntaxNode{Kind: blockSyntax}
+		}
+		typ, ok := merge3Type(base.Type, ours.Type, theirs.Type)
+		if ok {
+			node, n := merge3Block(base, ours, theirs, markers)
+			node.Type = typ
+			return node, n
+		}
+	}
+
+	conflict := &syntaxNode{Kind: conflictSyntax, Children: []*syntaxNode{ours, theirs}, Items: markers}
+	if ours != nil {
+		conflict.Comments = ours.Comments
+	} else {
+		conflict.Comments = theirs.Comments
+	}
+	return conflict, 1
+}
+
+// merge3Type merges the type annotations of two versions of a block.
+func merge3Type(base, ours, theirs string) (string, bool) {
+	switch {
+	case ours == theirs || base == theirs:
+		return ours, true
+	case base == ours:
+		return theirs, true
+	}
+	return "", false
+}
+
+// childByKey returns the last entry of a block with the given key, or nil.
+func childByKey(block *syntaxNode, key string) *syntaxNode {
+	var found *syntaxNode
+	for _, child := range block.Children {
+		if child.Key == key {
+			found = child
+		}
+	}
+	return found
+}
+
+// sameSyntax reports whether two nodes hold the same entry, ignoring
+// comments and layout. Either may be nil.
+func sameSyntax(a, b *syntaxNode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Key != b.Key || a.Type != b.Type || a.Kind != b.Kind || a.Text != b.Text || a.Fence != b.Fence ||
+		!slices.Equal(a.Lines, b.Lines) || !slices.Equal(a.Items, b.Items) || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Children {
+		if !sameSyntax(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleGitTextconv processes the git textconv command. It writes the
+// canonical form of a file, formatted with sorted keys, so that git diff
+// shows only meaningful changes. Files that do not parse are written as
+// they are.
+func (a *App) handleGitTextconv(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a file")
+	}
+	data, err := os.ReadFile(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	pc, err := a.project()
+	if err != nil {
+		return err
+	}
+	canonical, err := a.formatSource(c.Args().First(), data, formatOptions{Indent: pc.format.Indent, SortKeys: true})
+	if err != nil {
+		canonical = data
+	}
+	_, err = a.output.Write(canonical)
+	return err
+}
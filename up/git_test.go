@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// writeMergeVersions writes the ancestor, current and other versions of a
+// file for the merge driver and returns their paths.
+func writeMergeVersions(t *testing.T, base, ours, theirs string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for name, content := range map[string]string{"O.up": base, "A.up": ours, "B.up": theirs} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"O.up", "A.up", "B.up"} {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	return paths
+}
+
+func TestGitMergeDriver(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		args               []string
+		want               string
+		wantErr            string
+	}{
+		{
+			name:   "independent changes",
+			base:   "a 1\nb 2\n",
+			ours:   "# about a\na 10\nb 2\n",
+			theirs: "a 1\nb 20\nc 3\n",
+			want:   "# about a\na 10\nb 20\nc 3\n",
+		},
+		{
+			name:   "same change on both sides",
+			base:   "a 1\n",
+			ours:   "a 1\nn 5\n",
+			theirs: "a 1\nn 5\n",
+			want:   "a 1\nn 5\n",
+		},
+		{
+			name:   "nested blocks merge key by key",
+			base:   "s {\n  x 1\n  y 1\n}\n",
+			ours:   "s {\n  x 2\n  y 1\n}\n",
+			theirs: "s {\n  x 1\n  y 2\n}\n",
+			want:   "s {\n  x 2\n  y 2\n}\n",
+		},
+		{
+			name:   "removal on both sides",
+			base:   "a 1\nb 2\n",
+			ours:   "b 2\n",
+			theirs: "a 1\n",
+			want:   "",
+		},
+		{
+			name:    "conflicting values",
+			base:    "a 1\nb 1\n",
+			ours:    "a 2\nb 1\n",
+			theirs:  "a 3\nb 1\n",
+			want:    "<<<<<<< ours\na 2\n=======\na 3\n>>>>>>> theirs\nb 1\n",
+			wantErr: "1 conflicting key(s)",
+		},
+		{
+			name:    "change against removal",
+			base:    "a 1\nb 2\n",
+			ours:    "b 3\n",
+			theirs:  "a 1\n",
+			want:    "<<<<<<< ours\nb 3\n=======\n>>>>>>> theirs\n",
+			wantErr: "1 conflicting key(s)",
+		},
+		{
+			name:    "marker size",
+			base:    "a 1\n",
+			ours:    "a 2\n",
+			theirs:  "a 3\n",
+			args:    []string{"--marker-size", "3"},
+			want:    "<<< ours\na 2\n===\na 3\n>>> theirs\n",
+			wantErr: "1 conflicting key(s)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := writeMergeVersions(t, tt.base, tt.ours, tt.theirs)
+			args := append(append([]string{"git", "merge-driver"}, tt.args...), paths...)
+			_, err := runApp(t, "", args...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			got, err := os.ReadFile(paths[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitMergeDriverFallback(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	paths := writeMergeVersions(t, "a 1\nb {\n", "a 2\nb {\n", "a 1\nb {\n")
+	if _, err := runApp(t, "", "git", "merge-driver", paths[0], paths[1], paths[2]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := os.ReadFile(paths[1]); string(got) != "a 2\nb {\n" {
+		t.Errorf("got %q, want the line-based merge", got)
+	}
+}
+
+func TestGitMergeDriverErrors(t *testing.T) {
+	paths := writeMergeVersions(t, "a 1\n", "a 1\n", "a 1\n")
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"too few versions", paths[:2], "expected the ancestor, current and other versions (%O %A %B)"},
+		{"invalid marker size", append([]string{"--marker-size", "0"}, paths...), "invalid marker size 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, "", append([]string{"git", "merge-driver"}, tt.args...)...)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitTextconv(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"sorts and formats", "b   2\na {\n    z 1\n    y 2\n}\n", "a {\n  y 2\n  z 1\n}\nb 2\n"},
+		{"unparsable files pass through", "a {\n", "a {\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, "", "git", "textconv", writeTestFile(t, "test.up", tt.source))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
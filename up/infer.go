@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// handleSchemaInfer processes the schema infer command.
+func (a *App) handleSchemaInfer(c *cli.Context) error {
+	root := newInferred()
+	if c.NArg() == 0 {
+		doc, err := a.readInput("")
+		if err != nil {
+			return err
+		}
+		root.observeDocument(doc)
+	}
+	for _, filename := range c.Args().Slice() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		doc, err := parseSource(filename, data)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+		root.observeDocument(doc)
+	}
+
+	if c.Bool("verbose") {
+		fmt.Fprintf(c.App.ErrWriter, "inferred schema from %d sample(s)\n", root.blocks)
+	}
+
+	return a.writeConverted(c.String("output"), codecs["up"], root.schemaDocument(), false)
+}
+
+// schemaDocument builds the up-schema document for the observed samples.
+func (n *inferred) schemaDocument() *up.Document {
+	specs := n.fieldSpecs()
+	doc := &up.Document{}
+	for _, key := range sortedKeys(specs) {
+		doc.Nodes = append(doc.Nodes, up.Node{Key: key, Value: specs[key]})
+	}
+	return doc
+}
+
+// inferred accumulates what the samples show about one key.
+type inferred struct {
+	seen   int
+	types  map[string]int
+	blocks int
+	fields map[string]*inferred
+	items  *inferred
+}
+
+// newInferred returns an empty observation.
+func newInferred() *inferred {
+	return &inferred{types: make(map[string]int), fields: make(map[string]*inferred)}
+}
+
+// observeDocument records a sample document as an occurrence of a block.
+func (n *inferred) observeDocument(doc *up.Document) {
+	block := make(up.Block, len(doc.Nodes))
+	for _, node := range doc.Nodes {
+		if node.Type == "schema" {
+			continue
+		}
+		block[joinKey(node.Key, node.Type)] = node.Value
+	}
+	n.observe("", block)
+}
+
+// observe records one occurrence of a value with its type annotation. A
+// list's annotation applies to its scalar items.
+func (n *inferred) observe(typ string, v up.Value) {
+	n.seen++
+	switch value := v.(type) {
+	case up.Block:
+		n.types["block"]++
+		n.blocks++
+		for k, child := range value {
+			key, childType := splitKey(k)
+			field, ok := n.fields[key]
+			if !ok {
+				field = newInferred()
+				n.fields[key] = field
+			}
+			field.observe(childType, child)
+		}
+	case up.List:
+		n.types["list"]++
+		if n.items == nil {
+			n.items = newInferred()
+		}
+		for _, item := range value {
+			n.items.observe(typ, item)
+		}
+	default:
+		n.types[scalarSchemaType(typ)]++
+	}
+}
+
+// scalarSchemaType maps a scalar's type annotation to a schema type.
+// Unannotated scalars and dedented multiline strings are strings. Schemas
+// cannot express !null or custom annotations, so scalars carrying them
+// accept any value.
+func scalarSchemaType(typ string) string {
+	switch {
+	case typ == "" || isDedent(typ):
+		return "string"
+	case !schemaTypes[typ] || typ == "block" || typ == "list":
+		return "any"
+	}
+	return typ
+}
+
+// schemaType resolves the observed types to a single schema type. Integers
+// seen alongside floats widen to float; any other mix becomes any.
+func (n *inferred) schemaType() string {
+	switch {
+	case len(n.types) == 1:
+		for typ := range n.types {
+			return typ
+		}
+	case len(n.types) == 2 && n.types["int"] > 0 && n.types["float"] > 0:
+		return "float"
+	}
+	return "any"
+}
+
+// spec builds the field spec for the observations. parents is the number of
+// blocks the key could have appeared in, or zero for list items, which have
+// no required status.
+func (n *inferred) spec(parents int) up.Block {
+	typ := n.schemaType()
+	spec := up.Block{"type": typ}
+	if parents > 0 {
+		if n.seen >= parents {
+			spec["required!bool"] = "true"
+		} else {
+			spec["description"] = "optional, present in " + strconv.Itoa(n.seen) + " of " + strconv.Itoa(parents)
+		}
+	}
+	// Fields and items would turn a type of any back into block or list,
+	// rejecting the other kinds of values observed.
+	if typ == "block" && len(n.fields) > 0 {
+		spec["fields"] = n.fieldSpecs()
+	}
+	if typ == "list" && n.items != nil && n.items.seen > 0 {
+		spec["items"] = n.items.spec(0)
+	}
+	return spec
+}
+
+// fieldSpecs builds the field specs for the keys observed in a block.
+func (n *inferred) fieldSpecs() up.Block {
+	keys := make([]string, 0, len(n.fields))
+	for key := range n.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := make(up.Block, len(keys))
+	for _, key := range keys {
+		fields[key] = n.fields[key].spec(n.blocks)
+	}
+	return fields
+}
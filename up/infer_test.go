@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inferTestSchema infers a schema from samples and loads it back from its
+// UP form.
+func inferTestSchema(t *testing.T, samples ...string) *schema {
+	t.Helper()
+	root := newInferred()
+	for _, sample := range samples {
+		doc, err := parseSource("sample.up", []byte(sample))
+		if err != nil {
+			t.Fatalf("failed to parse sample: %v", err)
+		}
+		root.observeDocument(doc)
+	}
+
+	var buf bytes.Buffer
+	if err := codecs["up"].Encode(&buf, root.schemaDocument(), false); err != nil {
+		t.Fatalf("failed to encode schema: %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "inferred.up-schema")
+	if err := os.WriteFile(filename, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSchema(filename)
+	if err != nil {
+		t.Fatalf("failed to load inferred schema:\n%s\n%v", buf.String(), err)
+	}
+	return s
+}
+
+func TestInferredSchemaAcceptsSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []string
+	}{
+		{"scalars", []string{"name app\nport!int 8080\nratio!float 0.5\ndebug!bool true\n"}},
+		{"time types", []string{"timeout!dur 30s\ncreated!ts 2024-01-01T00:00:00Z\nid!uuid 123e4567-e89b-12d3-a456-426614174000\n"}},
+		{"null", []string{"value!null null\n"}},
+		{"custom annotation", []string{"color!rgb #ff0000\n"}},
+		{"null and string", []string{"value!null null\n", "value text\n"}},
+		{"int and float", []string{"n!int 1\n", "n!float 1.5\n"}},
+		{"mixed kinds", []string{"v!int 1\n", "v {\n  a b\n}\n", "v [\n  x\n]\n"}},
+		{"optional keys", []string{"a 1\nb 2\n", "a 3\n"}},
+		{"nested", []string{"server {\n  host localhost\n  port!int 80\n  tls {\n    enabled!bool false\n  }\n}\n"}},
+		{"typed list", []string{"ports!int [\n  80\n  443\n]\n"}},
+		{"list of blocks", []string{"users [\n  {\n    name a\n  }\n  {\n    name b\n    admin!bool true\n  }\n]\n"}},
+		{"multiline", []string{"text!2 ```\n  hello\n```\n"}},
+		{"empty list", []string{"items []\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := inferTestSchema(t, tt.samples...)
+			for _, sample := range tt.samples {
+				doc, err := parseSource("sample.up", []byte(sample))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, strict := range []bool{false, true} {
+					if violations := validateDocument(doc, s, strict); len(violations) > 0 {
+						t.Errorf("strict=%v: sample %q rejected by its own schema: %v", strict, sample, violations)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestScalarSchemaType(t *testing.T) {
+	tests := []struct {
+		typ, want string
+	}{
+		{"", "string"},
+		{"string", "string"},
+		{"2", "string"},
+		{"int", "int"},
+		{"uuid", "uuid"},
+		{"null", "any"},
+		{"rgb", "any"},
+		{"block", "any"},
+	}
+	for _, tt := range tests {
+		if got := scalarSchemaType(tt.typ); got != tt.want {
+			t.Errorf("scalarSchemaType(%q) = %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+}
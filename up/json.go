@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	up "github.com/uplang/go"
+)
+
+// jsonCodec reads and writes JSON documents.
+type jsonCodec struct{}
+
+// Decode reads a JSON object as a UP document.
+func (c jsonCodec) Decode(r io.Reader) (*up.Document, error) {
+	objs, err := c.DecodeObjects(r)
+	if err != nil {
+		return nil, err
+	}
+	return documentFromObject(objs[0])
+}
+
+// DecodeObjects reads a JSON object, keeping the key order.
+func (jsonCodec) DecodeObjects(r io.Reader) ([]object, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	value, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+
+	obj, ok := value.(object)
+	if !ok {
+		return nil, fmt.Errorf("top-level value must be an object, got %s", jsonKind(value))
+	}
+	return []object{obj}, nil
+}
+
+// Encode writes a UP document as JSON, converting annotated scalars into
+// JSON numbers, booleans and nulls.
+func (c jsonCodec) Encode(w io.Writer, doc *up.Document, pretty bool) error {
+	obj, err := documentToObject(doc)
+	if err != nil {
+		return err
+	}
+	return c.EncodeObject(w, obj, pretty)
+}
+
+// EncodeObject writes an ordered object as JSON.
+func (jsonCodec) EncodeObject(w io.Writer, obj object, pretty bool) error {
+	var data []byte
+	var err error
+	if pretty {
+		data, err = json.MarshalIndent(obj, "", "  ")
+	} else {
+		data, err = json.Marshal(obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// decodeJSONValue reads the next JSON value, decoding objects as ordered
+// objects instead of maps.
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{Key: keyTok.(string), Value: value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case json.Delim('['):
+		items := []any{}
+		for dec.More() {
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return items, nil
+	default:
+		return tok, nil
+	}
+}
+
+// MarshalJSON writes the object with its members in order.
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(m.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonKind describes the kind of a decoded JSON value for error messages.
+func jsonKind(v any) string {
+	switch v.(type) {
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
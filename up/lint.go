@@ -0,0 +1,840 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// lintConfigFile is the name of the lint configuration file, looked up in
+// the current directory and its parents.
+const lintConfigFile = ".uplint.up"
+
+// annotationTypes lists the built-in type annotations and directives,
+// including those of templates. Numeric dedent annotations are accepted as
+// well.
+var annotationTypes = map[string]bool{
+	"string": true, "int": true, "float": true, "bool": true, "null": true,
+	"dur": true, "ts": true, "uuid": true,
+	"schema": true, "merge": true,
+	"base": true, "overlay": true, "include": true, "patch": true, "params": true,
+}
+
+// keyStyles maps the key naming styles to their descriptions.
+var keyStyles = map[string]string{
+	"snake":  "snake_case",
+	"camel":  "camelCase",
+	"kebab":  "kebab-case",
+	"pascal": "PascalCase",
+}
+
+// intPattern matches the integers suspicious-value reports. Leading zeros
+// suggest identifiers such as postal codes and are left alone.
+var intPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+
+// lintConfig selects the lint rules to run and their settings.
+type lintConfig struct {
+	// severities maps rule names to their severity, or "off".
+	severities map[string]string
+	// types holds the custom type annotations.
+	types map[string]bool
+	// keyStyle is the naming style keys must follow, or "consistent" for
+	// the style most keys of a file follow.
+	keyStyle string
+	// maxDepth is the deepest nesting allowed.
+	maxDepth int
+	// duplicates is the definition of a duplicate key --fix keeps: first,
+	// last, or merge to merge duplicate blocks. Without a policy duplicate
+	// keys are not fixed, as fixing them deletes values.
+	duplicates string
+}
+
+// defaultLintConfig returns the configuration used without a config file.
+func defaultLintConfig() *lintConfig {
+	return &lintConfig{
+		severities: make(map[string]string),
+		types:      make(map[string]bool),
+		keyStyle:   "consistent",
+		maxDepth:   6,
+	}
+}
+
+// severity returns the severity of a rule, or "off".
+func (lc *lintConfig) severity(rule diagnosticRule) string {
+	if severity, ok := lc.severities[rule.Name]; ok {
+		return severity
+	}
+	return rule.Severity
+}
+
+// loadLintConfig reads a lint configuration file:
+//
+//	types [
+//	  email
+//	]
+//	rules {
+//	  empty-block off
+//	  key-naming {
+//	    severity error
+//	    style snake
+//	  }
+//	  nesting-depth {
+//	    max!int 4
+//	  }
+//	}
+//
+// A rule is given a severity (error, warning, note or off) directly, or a
+// block of settings. key-naming accepts style (snake, camel, kebab, pascal
+// or consistent), nesting-depth accepts max, and duplicate-key accepts fix
+// (first, last or merge).
+func loadLintConfig(filename string) (*lintConfig, error) {
+	if filename == "" {
+		return defaultLintConfig(), nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseSource(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lint config %s: %w", filename, err)
+	}
+
+	settings := make(up.Block, len(doc.Nodes))
+	for _, node := range doc.Nodes {
+		settings[joinKey(node.Key, node.Type)] = node.Value
+	}
+	lc, err := parseLintConfig(settings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return lc, nil
+}
+
+// parseLintConfig parses the settings of a lint configuration.
+func parseLintConfig(settings up.Block) (*lintConfig, error) {
+	lc := defaultLintConfig()
+	for _, k := range sortedKeys(settings) {
+		switch key, _ := splitKey(k); key {
+		case "types":
+			types, err := schemaStrings(settings[k])
+			if err != nil {
+				return nil, fmt.Errorf("types %w", err)
+			}
+			for _, t := range types {
+				lc.types[t] = true
+			}
+		case "rules":
+			rules, ok := settings[k].(up.Block)
+			if !ok {
+				return nil, fmt.Errorf("rules must be a block")
+			}
+			for _, k := range sortedKeys(rules) {
+				name, _ := splitKey(k)
+				if err := lc.setRule(name, rules[k]); err != nil {
+					return nil, fmt.Errorf("rules.%s: %w", name, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return lc, nil
+}
+
+// setRule applies the configuration of a rule: a severity, or a block of
+// settings.
+func (lc *lintConfig) setRule(name string, value up.Value) error {
+	rule, ok := ruleByName(name)
+	if !ok || !isLintRule(name) {
+		return fmt.Errorf("unknown rule")
+	}
+	settings, ok := value.(up.Block)
+	if !ok {
+		severity, err := schemaString(value)
+		if err != nil {
+			return fmt.Errorf("must be a severity or a block")
+		}
+		settings = up.Block{"severity": severity}
+	}
+
+	for _, k := range sortedKeys(settings) {
+		key, _ := splitKey(k)
+		var err error
+		switch {
+		case key == "severity":
+			var severity string
+			if severity, err = schemaString(settings[k]); err == nil {
+				switch severity {
+				case severityError, severityWarning, severityNote, "off":
+					lc.severities[rule.Name] = severity
+				default:
+					err = fmt.Errorf("unknown severity %q (expected error, warning, note or off)", severity)
+				}
+			}
+		case key == "style" && rule.Code == codeKeyNaming:
+			var style string
+			if style, err = schemaString(settings[k]); err == nil {
+				if _, ok := keyStyles[style]; !ok && style != "consistent" {
+					err = fmt.Errorf("unknown style %q (expected snake, camel, kebab, pascal or consistent)", style)
+				}
+				lc.keyStyle = style
+			}
+		case key == "fix" && rule.Code == codeDuplicateKey:
+			var policy string
+			if policy, err = schemaString(settings[k]); err == nil {
+				err = checkDuplicatePolicy(policy)
+				lc.duplicates = policy
+			}
+		case key == "max" && rule.Code == codeNestingDepth:
+			var depth *int
+			if depth, err = schemaInt(settings[k]); err == nil {
+				if *depth < 1 {
+					err = fmt.Errorf("must be at least 1")
+				}
+				lc.maxDepth = *depth
+			}
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// checkDuplicatePolicy reports an error for an unknown duplicate-key fix
+// policy.
+func checkDuplicatePolicy(policy string) error {
+	if policy != "first" && policy != "last" && policy != "merge" {
+		return fmt.Errorf("unknown policy %q (expected first, last or merge)", policy)
+	}
+	return nil
+}
+
+// findLintConfig returns the path of the nearest lint configuration file
+// in the current directory or its parents, or "" if there is none.
+func findLintConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, lintConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// lintRule checks a syntax tree for one kind of problem. Rules are named
+// after their diagnostic rule.
+type lintRule struct {
+	name  string
+	check func(l *linter, root *syntaxNode)
+	// fix, if set, rewrites the tree to remove the problems check reports,
+	// as far as that is safe. It returns the number of fixes made.
+	fix func(l *linter, root *syntaxNode) int
+}
+
+// lintRules lists the lint rules in the order they run.
+var lintRules = []lintRule{
+	{"duplicate-key", lintDuplicateKeys, fixDuplicateKeys},
+	{"unknown-type", lintUnknownTypes, nil},
+	{"empty-block", lintEmptyBlocks, fixEmptyBlocks},
+	{"key-naming", lintKeyNaming, fixKeyNaming},
+	{"nesting-depth", lintNestingDepth, nil},
+	{"suspicious-value", lintSuspiciousValues, fixSuspiciousValues},
+}
+
+// isLintRule reports whether a diagnostic rule is a lint rule.
+func isLintRule(name string) bool {
+	for _, rule := range lintRules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// linter collects the findings of the lint rules in a file.
+type linter struct {
+	config *lintConfig
+	lines  []string
+	// code and severity are those of the running rule.
+	code     string
+	severity string
+	diags    diagnosticError
+}
+
+// lintSource runs the enabled lint rules on a file. Syntax errors are
+// returned instead of lint findings, as the rules need a complete tree.
+func lintSource(filename string, data []byte, lc *lintConfig) diagnosticError {
+	root, err := parseSyntax(bytes.NewReader(data))
+	if err != nil {
+		var diags diagnosticError
+		if errors.As(err, &diags) {
+			return diags.inFile(filename, data)
+		}
+		return diagnosticError{{File: filename, Severity: severityError, Code: codeReadError, Message: err.Error()}}
+	}
+
+	l := &linter{config: lc, lines: sourceLines(data)}
+	for _, rule := range lintRules {
+		info, _ := ruleByName(rule.name)
+		l.code, l.severity = info.Code, lc.severity(info)
+		if l.severity != "off" {
+			rule.check(l, root)
+		}
+	}
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return l.diags.inFile(filename, data)
+}
+
+// reportKey records a finding on the key and type annotation of an entry,
+// or on the whole of a list item.
+func (l *linter) reportKey(node *syntaxNode, format string, args ...any) {
+	var d diagnostic
+	if node.Key == "" {
+		d = nodeDiagnostic(l.lines, node, l.code, fmt.Sprintf(format, args...))
+	} else {
+		raw := l.lines[node.Line-1]
+		keyPart, _ := splitStatement(strings.TrimSpace(raw))
+		start := strings.Index(raw, keyPart)
+		d = spanDiagnostic(node.Line, raw, start, start+len(keyPart), l.code, format, args...)
+	}
+	d.Severity = l.severity
+	l.diags = append(l.diags, d)
+}
+
+// reportNode records a finding on a whole scalar entry or list item.
+func (l *linter) reportNode(node *syntaxNode, format string, args ...any) {
+	d := nodeDiagnostic(l.lines, node, l.code, fmt.Sprintf(format, args...))
+	d.Severity = l.severity
+	l.diags = append(l.diags, d)
+}
+
+// walkSyntax calls fn for every node below node, parents first, with its
+// depth: 1 for the entries of node. The nodes below a node are skipped
+// when fn returns false for it.
+func walkSyntax(node *syntaxNode, depth int, fn func(node *syntaxNode, depth int) bool) {
+	for _, child := range node.Children {
+		if child != nil && fn(child, depth) {
+			walkSyntax(child, depth+1, fn)
+		}
+	}
+}
+
+// lintDuplicateKeys reports keys defined more than once in a block.
+func lintDuplicateKeys(l *linter, root *syntaxNode) {
+	check := func(block *syntaxNode) {
+		first := make(map[string]*syntaxNode)
+		for _, child := range block.Children {
+			if child.Key == "" {
+				continue
+			}
+			if prev, ok := first[child.Key]; ok {
+				l.reportKey(child, "duplicate key %q, first defined on line %d", child.Key, prev.Line)
+			} else {
+				first[child.Key] = child
+			}
+		}
+	}
+	check(root)
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		if node.Kind == blockSyntax {
+			check(node)
+		}
+		return true
+	})
+}
+
+// lintUnknownTypes reports type annotations that are neither built in nor
+// configured custom types.
+func lintUnknownTypes(l *linter, root *syntaxNode) {
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		if typ := node.Type; typ != "" && !annotationTypes[typ] && !isDedent(typ) && !l.config.types[typ] {
+			l.reportKey(node, "unknown type annotation !%s", typ)
+		}
+		return true
+	})
+}
+
+// lintEmptyBlocks reports blocks without entries.
+func lintEmptyBlocks(l *linter, root *syntaxNode) {
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		if node.Kind == blockSyntax && len(node.Children) == 0 {
+			l.reportKey(node, "empty block")
+		}
+		return true
+	})
+}
+
+// identifierKey matches the keys whose naming style can be told.
+var identifierKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// keyStyle returns the naming style of a key: snake, camel, kebab or
+// pascal, "mixed" for a key combining them, or "" for a key that fits any
+// style, such as a single lowercase word, an uppercase constant or a key
+// that is not an identifier.
+func keyStyle(key string) string {
+	if !identifierKey.MatchString(key) || strings.IndexFunc(key, unicode.IsLower) < 0 {
+		return ""
+	}
+	upper := strings.IndexFunc(key, unicode.IsUpper) >= 0
+	snake, kebab := strings.Contains(key, "_"), strings.Contains(key, "-")
+	switch {
+	case snake && kebab, (snake || kebab) && upper:
+		return "mixed"
+	case snake:
+		return "snake"
+	case kebab:
+		return "kebab"
+	case unicode.IsUpper(rune(key[0])):
+		return "pascal"
+	case upper:
+		return "camel"
+	}
+	return ""
+}
+
+// wantedKeyStyle returns the naming style keys must follow: the configured
+// one, or the one most keys of the tree follow. It returns "" if no key
+// shows a style.
+func (l *linter) wantedKeyStyle(root *syntaxNode) string {
+	if l.config.keyStyle != "consistent" {
+		return l.config.keyStyle
+	}
+	counts := make(map[string]int)
+	var seen []string
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		if style := keyStyle(node.Key); style != "" && style != "mixed" {
+			if counts[style] == 0 {
+				seen = append(seen, style)
+			}
+			counts[style]++
+		}
+		return true
+	})
+
+	// The most common style wins; ties go to the first seen.
+	want := ""
+	for _, style := range seen {
+		if counts[style] > counts[want] {
+			want = style
+		}
+	}
+	return want
+}
+
+// lintKeyNaming reports keys that do not follow the configured naming
+// style, or the style most keys of the file follow.
+func lintKeyNaming(l *linter, root *syntaxNode) {
+	want := l.wantedKeyStyle(root)
+	if want == "" {
+		return
+	}
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		if style := keyStyle(node.Key); style != "" && style != want {
+			l.reportKey(node, "key %q is not %s", node.Key, keyStyles[want])
+		}
+		return true
+	})
+}
+
+// lintNestingDepth reports entries and items nested deeper than allowed,
+// without descending into them.
+func lintNestingDepth(l *linter, root *syntaxNode) {
+	walkSyntax(root, 1, func(node *syntaxNode, depth int) bool {
+		if depth > l.config.maxDepth {
+			l.reportKey(node, "nested %d levels deep (at most %d allowed)", depth, l.config.maxDepth)
+			return false
+		}
+		return true
+	})
+}
+
+// lintSuspiciousValues reports unannotated scalars that look like bools or
+// ints, and so are probably meant as such rather than as strings.
+func lintSuspiciousValues(l *linter, root *syntaxNode) {
+	check := func(node *syntaxNode) {
+		if typ := suggestedType(node.Text); typ != "" {
+			l.reportNode(node, "%q looks like a !%s value but has no annotation", node.Text, typ)
+		}
+	}
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		switch {
+		case node.Key != "" && node.Type == "" && node.Kind == scalarSyntax:
+			check(node)
+		case node.Type == "" && node.Kind == listSyntax:
+			// Items take the annotation of their list.
+			for _, item := range node.Children {
+				if item.Kind == scalarSyntax {
+					check(item)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// suggestedType returns the type annotation an unannotated scalar probably
+// needs: bool or int, or "" if it looks like a string.
+func suggestedType(text string) string {
+	switch {
+	case text == "true" || text == "false":
+		return "bool"
+	case intPattern.MatchString(text):
+		return "int"
+	}
+	return ""
+}
+
+// fixDuplicateKeys keeps one definition of each duplicate key: the first,
+// the last, which is the one that takes effect, or for blocks with the merge
+// policy, a block holding the entries of all of them. Without a policy it
+// fixes nothing.
+func fixDuplicateKeys(l *linter, root *syntaxNode) int {
+	if l.config.duplicates == "" {
+		return 0
+	}
+	fixes := 0
+	var fix func(block *syntaxNode)
+	fix = func(block *syntaxNode) {
+		for i := 0; i < len(block.Children); i++ {
+			node := block.Children[i]
+			if node.Key == "" {
+				continue
+			}
+			for j := i + 1; j < len(block.Children); j++ {
+				dup := block.Children[j]
+				if dup.Key != node.Key {
+					continue
+				}
+				switch {
+				case l.config.duplicates == "merge" && node.Kind == blockSyntax && dup.Kind == blockSyntax:
+					node.Comments = append(node.Comments, dup.Comments...)
+					node.Children = append(node.Children, dup.Children...)
+					node.Trailer = append(node.Trailer, dup.Trailer...)
+					dup.Comments = nil
+				case l.config.duplicates != "first":
+					// Move the later definition, with the comments of both,
+					// to the place of the earlier one.
+					dup.Comments = append(node.Comments, dup.Comments...)
+					node.Comments = nil
+					block.Children[i], block.Children[j] = dup, node
+					node = dup
+				}
+				removeChild(block, j)
+				j--
+				fixes++
+			}
+		}
+		for _, child := range block.Children {
+			if child.Kind == blockSyntax || child.Kind == listSyntax {
+				fix(child)
+			}
+		}
+	}
+	fix(root)
+	return fixes
+}
+
+// fixEmptyBlocks removes empty blocks, and blocks left empty by removing
+// them. Empty blocks in lists are kept, as removing them would change the
+// length of the list.
+func fixEmptyBlocks(l *linter, root *syntaxNode) int {
+	fixes := 0
+	var fix func(node *syntaxNode)
+	fix = func(node *syntaxNode) {
+		for i := 0; i < len(node.Children); i++ {
+			child := node.Children[i]
+			fix(child)
+			if child.Key != "" && child.Kind == blockSyntax && len(child.Children) == 0 {
+				removeChild(node, i)
+				i--
+				fixes++
+			}
+		}
+	}
+	fix(root)
+	return fixes
+}
+
+// fixKeyNaming renames the keys that do not follow the wanted naming style.
+// Keys whose new name is already taken in their block are left alone.
+func fixKeyNaming(l *linter, root *syntaxNode) int {
+	want := l.wantedKeyStyle(root)
+	if want == "" {
+		return 0
+	}
+	fixes := 0
+	fix := func(block *syntaxNode) {
+		taken := make(map[string]bool, len(block.Children))
+		for _, child := range block.Children {
+			taken[child.Key] = true
+		}
+		for _, child := range block.Children {
+			if style := keyStyle(child.Key); style == "" || style == want {
+				continue
+			}
+			if renamed := renameKey(child.Key, want); !taken[renamed] {
+				taken[renamed] = true
+				child.Key = renamed
+				fixes++
+			}
+		}
+	}
+	fix(root)
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		if node.Kind == blockSyntax {
+			fix(node)
+		}
+		return true
+	})
+	return fixes
+}
+
+// renameKey converts a key to a naming style.
+func renameKey(key, style string) string {
+	words := keyWords(key)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if style == "pascal" || style == "camel" && i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+	switch style {
+	case "snake":
+		return strings.Join(words, "_")
+	case "kebab":
+		return strings.Join(words, "-")
+	}
+	return strings.Join(words, "")
+}
+
+// keyWords splits a key into words at underscores, hyphens and case
+// changes, keeping acronyms together: "HTTPServer_port" gives HTTP, Server
+// and port.
+func keyWords(key string) []string {
+	var words []string
+	runes := []rune(key)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		switch {
+		case i == len(runes) || runes[i] == '_' || runes[i] == '-':
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case i > start && unicode.IsUpper(runes[i]) &&
+			(!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])):
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return words
+}
+
+// fixSuspiciousValues annotates the scalars suspicious-value reports.
+// Lists are annotated when all their items are scalars that need the same
+// annotation.
+func fixSuspiciousValues(l *linter, root *syntaxNode) int {
+	fixes := 0
+	walkSyntax(root, 1, func(node *syntaxNode, _ int) bool {
+		switch {
+		case node.Key != "" && node.Type == "" && node.Kind == scalarSyntax:
+			if typ := suggestedType(node.Text); typ != "" {
+				node.Type = typ
+				fixes++
+			}
+		case node.Type == "" && node.Kind == listSyntax && len(node.Children) > 0:
+			typ := suggestedType(node.Children[0].Text)
+			for _, item := range node.Children {
+				if item.Kind != scalarSyntax || suggestedType(item.Text) != typ {
+					typ = ""
+				}
+			}
+			if typ != "" {
+				node.Type = typ
+				fixes += len(node.Children)
+			}
+		}
+		return true
+	})
+	return fixes
+}
+
+// removeChild removes the i-th child of a node. Its comments stay in place,
+// moving to the next child or to the end of the node.
+func removeChild(node *syntaxNode, i int) {
+	comments := node.Children[i].Comments
+	node.Children = slices.Delete(node.Children, i, i+1)
+	if i < len(node.Children) {
+		node.Children[i].Comments = append(comments, node.Children[i].Comments...)
+	} else {
+		node.Trailer = append(comments, node.Trailer...)
+	}
+}
+
+// fixSource applies the fixes of the enabled lint rules to a file. It
+// returns the fixed file, formatted with opts, and the number of fixes
+// made, or the file unchanged if there was nothing to fix.
+func fixSource(filename string, data []byte, lc *lintConfig, opts formatOptions) ([]byte, int, error) {
+	root, err := parseSyntax(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, withSource(err, filename, data)
+	}
+
+	l := &linter{config: lc, lines: sourceLines(data)}
+	fixes := 0
+	for _, rule := range lintRules {
+		info, _ := ruleByName(rule.name)
+		if rule.fix != nil && lc.severity(info) != "off" {
+			fixes += rule.fix(l, root)
+		}
+	}
+	if fixes == 0 {
+		return data, 0, nil
+	}
+
+	var buf bytes.Buffer
+	if err := formatSyntax(&buf, root, opts); err != nil {
+		return nil, 0, fmt.Errorf("failed to format document: %w", err)
+	}
+	return buf.Bytes(), fixes, nil
+}
+
+// handleLint processes the lint command. Every input is checked by the
+// enabled rules, and findings are reported as diagnostics or as a SARIF
+// log. The command fails if any finding is an error. With --fix the safe
+// fixes are applied first, rewriting the files, and what remains is
+// reported; with --dry-run the fixes are shown as a diff instead.
+func (a *App) handleLint(c *cli.Context) error {
+	format := c.String("format")
+	if format != "text" && format != "sarif" {
+		return fmt.Errorf("unknown lint format %q (expected text or sarif)", format)
+	}
+
+	pc, err := a.project()
+	if err != nil {
+		return err
+	}
+	configPath := c.String("config")
+	if configPath == "" {
+		configPath = findLintConfig()
+	}
+	lc := pc.lint
+	if configPath != "" || lc == nil {
+		if lc, err = loadLintConfig(configPath); err != nil {
+			return fmt.Errorf("failed to load lint config: %w", err)
+		}
+	}
+
+	if c.IsSet("duplicates") {
+		policy := c.String("duplicates")
+		if err := checkDuplicatePolicy(policy); err != nil {
+			return fmt.Errorf("invalid --duplicates: %w", err)
+		}
+		overridden := *lc
+		overridden.duplicates = policy
+		lc = &overridden
+	}
+
+	inputs := []string{""}
+	if c.NArg() > 0 {
+		if inputs, err = expandPaths(c.Args().Slice(), nil); err != nil {
+			return err
+		}
+	}
+
+	dryRun := c.Bool("dry-run")
+	fix := c.Bool("fix") || dryRun
+
+	var diags diagnosticError
+	errs := 0
+	for _, filename := range inputs {
+		data, err := a.readSource(filename)
+		if err != nil {
+			return err
+		}
+		if fix {
+			if data, err = a.fixFile(filename, data, lc, pc.format, dryRun); err != nil {
+				return err
+			}
+		}
+		for _, d := range lintSource(inputName(filename), data, lc) {
+			if d.Severity == severityError {
+				errs++
+			}
+			diags = append(diags, d)
+		}
+	}
+
+	if format == "sarif" {
+		if err := a.writeSARIFFile(c.String("output"), diags); err != nil {
+			return err
+		}
+		if errs > 0 {
+			return fmt.Errorf("lint failed: %d error(s)", errs)
+		}
+		return nil
+	}
+	if errs > 0 {
+		return fmt.Errorf("lint failed: %w", diags)
+	}
+	return writeDiagnostics(c.App.ErrWriter, a.errorFormat, diags)
+}
+
+// fixFile applies the lint fixes to a file, or stdin when filename is
+// empty, and returns the contents to lint. Fixed files are replaced, and
+// fixed input from stdin is written to the output. In a dry run the diff of
+// the fixes is written to the output instead, and the original contents
+// are returned. Files with syntax errors are returned unchanged.
+func (a *App) fixFile(filename string, data []byte, lc *lintConfig, opts formatOptions, dryRun bool) ([]byte, error) {
+	fixed, fixes, err := fixSource(inputName(filename), data, lc, opts)
+	var diags diagnosticError
+	switch {
+	case errors.As(err, &diags):
+		return data, nil
+	case err != nil:
+		return nil, err
+	case fixes == 0 && filename != "":
+		return data, nil
+	}
+
+	switch {
+	case dryRun:
+		if fixes > 0 {
+			if err := writeDiff(a.output, inputName(filename), data, fixed); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	case filename == "":
+		_, err = a.output.Write(fixed)
+	default:
+		err = writeFileAtomic(filename, fixed)
+	}
+	return fixed, err
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	up "github.com/uplang/go"
+)
+
+func TestFixSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		duplicates string
+		input      string
+		want       string
+		fixes      int
+	}{
+		{
+			name:  "duplicates kept without a policy",
+			input: "name a\nname b\n",
+			want:  "name a\nname b\n",
+		},
+		{
+			name:       "last",
+			duplicates: "last",
+			input:      "# first\nname a\nport!int 1\nname b\n",
+			want:       "# first\nname b\nport!int 1\n",
+			fixes:      1,
+		},
+		{
+			name:       "first",
+			duplicates: "first",
+			input:      "name a\nname b\n",
+			want:       "name a\n",
+			fixes:      1,
+		},
+		{
+			name:       "merge blocks",
+			duplicates: "merge",
+			input:      "server {\n  host a\n}\nserver {\n  port!int 1\n}\n",
+			want:       "server {\n  host a\n  port!int 1\n}\n",
+			fixes:      1,
+		},
+		{
+			name:       "nested",
+			duplicates: "last",
+			input:      "server {\n  host a\n  host b\n}\n",
+			want:       "server {\n  host b\n}\n",
+			fixes:      1,
+		},
+		{
+			name:  "empty block",
+			input: "name a\nempty {\n}\n",
+			want:  "name a\n",
+			fixes: 1,
+		},
+		{
+			name:  "key naming",
+			input: "max_idle!int 1\nmin_idle!int 2\nmaxOpen!int 3\n",
+			want:  "max_idle!int 1\nmin_idle!int 2\nmax_open!int 3\n",
+			fixes: 1,
+		},
+		{
+			name:  "suspicious values",
+			input: "enabled true\nports [\n  80\n  443\n]\n",
+			want:  "enabled!bool true\nports!int [\n  80\n  443\n]\n",
+			fixes: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := defaultLintConfig()
+			lc.duplicates = tt.duplicates
+			got, fixes, err := fixSource("test.up", []byte(tt.input), lc, defaultFormatOptions)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if fixes != tt.fixes {
+				t.Errorf("got %d fixes, want %d", fixes, tt.fixes)
+			}
+		})
+	}
+}
+
+func TestLintDuplicatesFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "reported without a policy",
+			args:    []string{"lint", "--fix"},
+			want:    "name a\nname b\n",
+			wantErr: "duplicate key \"name\"",
+		},
+		{
+			name: "fixed with a policy",
+			args: []string{"lint", "--fix", "--duplicates", "first"},
+			want: "name a\n",
+		},
+		{
+			name:    "unknown policy",
+			args:    []string{"lint", "--fix", "--duplicates", "newest"},
+			wantErr: `invalid --duplicates: unknown policy "newest"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runApp(t, "name a\nname b\n", tt.args...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+			if out != tt.want {
+				t.Errorf("got output %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLintConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings up.Block
+		wantErr  string
+	}{
+		{"empty", up.Block{}, ""},
+		{"severity", up.Block{"rules": up.Block{"empty-block": "off"}}, ""},
+		{"duplicate policy", up.Block{"rules": up.Block{"duplicate-key": up.Block{"fix": "merge"}}}, ""},
+		{"unknown policy", up.Block{"rules": up.Block{"duplicate-key": up.Block{"fix": "newest"}}}, `unknown policy "newest"`},
+		{"unknown rule", up.Block{"rules": up.Block{"no-such-rule": "off"}}, "unknown rule"},
+		{"unknown severity", up.Block{"rules": up.Block{"empty-block": "fatal"}}, `unknown severity "fatal"`},
+		{"unknown style", up.Block{"rules": up.Block{"key-naming": up.Block{"style": "shouty"}}}, `unknown style "shouty"`},
+		{"depth too small", up.Block{"rules": up.Block{"nesting-depth": up.Block{"max!int": "0"}}}, "must be at least 1"},
+		{"unknown setting", up.Block{"colors": "on"}, `unknown setting "colors"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseLintConfig(tt.settings)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLintSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		config func(lc *lintConfig)
+		// want lists "line:column severity[code] message" of each finding.
+		want []string
+	}{
+		{
+			name:   "clean",
+			source: "name app\nport!int 80\nserver {\n  host_name h\n}\n",
+		},
+		{
+			name:   "duplicate keys",
+			source: "name a\nserver {\n  host a\n  host!string b\n}\nname b\n",
+			want: []string{
+				`4:3 error[UP301] duplicate key "host", first defined on line 3`,
+				`6:1 error[UP301] duplicate key "name", first defined on line 1`,
+			},
+		},
+		{
+			name:   "unknown types",
+			source: "a!rgb fff\nb!email x\nc!4 ```\n    x\n```\nd!dur 1s\n",
+			config: func(lc *lintConfig) { lc.types["email"] = true },
+			want:   []string{"1:1 error[UP302] unknown type annotation !rgb"},
+		},
+		{
+			name:   "empty blocks",
+			source: "a {\n}\nl [\n  {\n  }\n]\n",
+			want:   []string{"1:1 warning[UP303] empty block", "4:3 warning[UP303] empty block"},
+		},
+		{
+			name:   "consistent key naming",
+			source: "max_idle!int 1\nmin_idle!int 2\nmaxOpen!int 3\nname x\n",
+			want:   []string{`3:1 warning[UP304] key "maxOpen" is not snake_case`},
+		},
+		{
+			name:   "configured key naming",
+			source: "max_idle!int 1\nmaxOpen!int 3\n",
+			config: func(lc *lintConfig) { lc.keyStyle = "kebab" },
+			want: []string{
+				`1:1 warning[UP304] key "max_idle" is not kebab-case`,
+				`2:1 warning[UP304] key "maxOpen" is not kebab-case`,
+			},
+		},
+		{
+			name:   "nesting depth",
+			source: "a {\n  b {\n    c {\n      d x\n    }\n  }\n}\n",
+			config: func(lc *lintConfig) { lc.maxDepth = 2 },
+			want:   []string{"3:5 warning[UP305] nested 3 levels deep (at most 2 allowed)"},
+		},
+		{
+			name:   "suspicious values",
+			source: "on true\nzip 01234\ncount 12\nports [\n  80\n]\nids!string [\n  1\n]\n",
+			want: []string{
+				`1:1 warning[UP306] "true" looks like a !bool value but has no annotation`,
+				`3:1 warning[UP306] "12" looks like a !int value but has no annotation`,
+				`5:3 warning[UP306] "80" looks like a !int value but has no annotation`,
+			},
+		},
+		{
+			name:   "severity override",
+			source: "a {\n}\nb true\n",
+			config: func(lc *lintConfig) {
+				lc.severities["empty-block"] = severityError
+				lc.severities["suspicious-value"] = "off"
+			},
+			want: []string{"1:1 error[UP303] empty block"},
+		},
+		{
+			name:   "syntax errors instead of findings",
+			source: "a {\n}\nb {\n",
+			want:   []string{"3:3 error[UP101] unterminated block: missing }"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := defaultLintConfig()
+			if tt.config != nil {
+				tt.config(lc)
+			}
+			var got []string
+			for _, d := range lintSource("test.up", []byte(tt.source), lc) {
+				if d.File != "test.up" {
+					t.Errorf("finding attributed to %q", d.File)
+				}
+				got = append(got, fmt.Sprintf("%d:%d %s[%s] %s", d.Line, d.Column, d.Severity, d.Code, d.Message))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestKeyStyle(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"name", ""},
+		{"MAX", ""},
+		{"max_idle", "snake"},
+		{"max-idle", "kebab"},
+		{"maxIdle", "camel"},
+		{"MaxIdle", "pascal"},
+		{"max_Idle", "mixed"},
+		{"max-idle_x", "mixed"},
+		{"app.kubernetes.io", ""},
+	}
+	for _, tt := range tests {
+		if got := keyStyle(tt.key); got != tt.want {
+			t.Errorf("keyStyle(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	tests := []struct {
+		key, style, want string
+	}{
+		{"maxIdle", "snake", "max_idle"},
+		{"max_idle", "camel", "maxIdle"},
+		{"max_idle", "pascal", "MaxIdle"},
+		{"HTTPServer_port", "kebab", "http-server-port"},
+		{"userID", "snake", "user_id"},
+	}
+	for _, tt := range tests {
+		if got := renameKey(tt.key, tt.style); got != tt.want {
+			t.Errorf("renameKey(%q, %q) = %q, want %q", tt.key, tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestLintCommand(t *testing.T) {
+	config := writeTestFile(t, lintConfigFile, "types [\n  rgb\n]\nrules {\n  empty-block off\n  key-naming {\n    severity error\n    style camel\n  }\n}\n")
+	tests := []struct {
+		name    string
+		source  string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "config file",
+			source: "color!rgb fff\nempty {\n}\nmaxIdle!int 1\n",
+			args:   []string{"--config", config},
+		},
+		{
+			name:    "findings fail the command",
+			source:  "max_idle!int 1\n",
+			args:    []string{"--config", config},
+			wantErr: `lint failed: <stdin>:1:1: key "max_idle" is not camelCase`,
+		},
+		{
+			name:   "warnings only",
+			source: "empty {\n}\n",
+		},
+		{
+			name:   "dry run",
+			source: "on true\n",
+			args:   []string{"--dry-run"},
+			want:   "--- <stdin>.orig\n+++ <stdin>\n@@ -1 +1 @@\n-on true\n+on!bool true\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, tt.source, append([]string{"lint"}, tt.args...)...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
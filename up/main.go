@@ -2,12 +2,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/urfave/cli/v2"
@@ -31,15 +36,20 @@ type App struct {
 	output   io.Writer
 	input    io.Reader
 	exitFunc func(int)
+	// errorFormat is the --error-format errors are reported in.
+	errorFormat string
+	// config is the project config, loaded by project.
+	config *projectConfig
 }
 
 // NewApp creates a new CLI application with dependency injection.
 func NewApp(p *up.Parser, out io.Writer, in io.Reader, exitFunc func(int)) *App {
 	return &App{
-		parser:   p,
-		output:   out,
-		input:    in,
-		exitFunc: exitFunc,
+		parser:      p,
+		output:      out,
+		input:       in,
+		exitFunc:    exitFunc,
+		errorFormat: "text",
 	}
 }
 
@@ -65,6 +75,16 @@ The commands are:
     parse       parse UP documents and output as JSON
     format      format UP documents (alias: fmt)
     validate    validate UP documents against schemas (alias: vet)
+    schema      infer schemas from sample documents
+    check       verify documents survive formatting and conversion
+    lint        check documents for style and quality problems
+    query       select values with path expressions
+    set         set a value in place
+    delete      delete a key in place
+    diff        compare documents structurally
+    patch       apply JSON Patch or Merge Patch documents
+    merge       deep-merge documents
+    git         git merge driver and diff support
     eval        evaluate dynamic namespaces
     convert     convert between UP and other formats
     lsp         start the UP language server
@@ -77,6 +97,16 @@ Use "up <command> -h" for more information about a command.`,
 			a.parseCommand(),
 			a.formatCommand(),
 			a.validateCommand(),
+			a.schemaCommand(),
+			a.checkCommand(),
+			a.lintCommand(),
+			a.queryCommand(),
+			a.setCommand(),
+			a.deleteCommand(),
+			a.diffCommand(),
+			a.patchCommand(),
+			a.mergeCommand(),
+			a.gitCommand(),
 			a.evalCommand(),
 			a.convertCommand(),
 			a.templateCommand(),
@@ -85,12 +115,29 @@ Use "up <command> -h" for more information about a command.`,
 			a.toolCommand(),
 			a.versionCommand(),
 		},
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "error-format",
+				Value:   "text",
+				Usage:   "Report errors as text, json or github (workflow annotations)",
+				EnvVars: []string{"UP_ERROR_FORMAT"},
+			},
+		},
 		Before: func(c *cli.Context) error {
+			a.errorFormat = c.String("error-format")
+			if !slices.Contains(errorFormats, a.errorFormat) {
+				err := fmt.Errorf("invalid --error-format %q (expected %s)", a.errorFormat, strings.Join(errorFormats, ", "))
+				a.errorFormat = "text"
+				return err
+			}
 			return nil
 		},
 	}
 
-	return app.Run(args)
+	if err := app.Run(args); err != nil {
+		return a.reportError(app.ErrWriter, err)
+	}
+	return nil
 }
 
 // parseCommand creates the parse command.
@@ -136,8 +183,43 @@ func (a *App) formatCommand() *cli.Command {
 				Aliases: []string{"o"},
 				Usage:   "Output file (default: stdout)",
 			},
+			&cli.IntFlag{
+				Name:  "indent",
+				Value: defaultFormatOptions.Indent,
+				Usage: "Indentation spaces (default: from the project config, or 2)",
+			},
+			&cli.BoolFlag{
+				Name:  "sort-keys",
+				Usage: "Sort keys alphabetically",
+			},
+			&cli.BoolFlag{
+				Name:    "check",
+				Aliases: []string{"l"},
+				Usage:   "List files whose formatting differs and exit non-zero if there are any",
+			},
+			&cli.BoolFlag{
+				Name:    "diff",
+				Aliases: []string{"d"},
+				Usage:   "Print unified diffs of the changes formatting would make",
+			},
+			&cli.BoolFlag{
+				Name:    "write",
+				Aliases: []string{"w"},
+				Usage:   "Rewrite files in place",
+			},
+			&cli.StringSliceFlag{
+				Name:  "ignore",
+				Usage: "Skip paths matching `PATTERN` when searching directories",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Value:   runtime.NumCPU(),
+				Usage:   "Number of files formatted concurrently",
+			},
 		},
-		Action: a.handleFormat,
+		ArgsUsage: "[file|dir|glob...]",
+		Action:    a.handleFormat,
 	}
 }
 
@@ -153,8 +235,308 @@ func (a *App) validateCommand() *cli.Command {
 				Aliases: []string{"i"},
 				Usage:   "Input file (default: stdin)",
 			},
+			&cli.StringFlag{
+				Name:    "schema",
+				Aliases: []string{"s"},
+				Usage:   "Schema file (default: <input>.up-schema or a !schema directive)",
+			},
+			&cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Reject unknown keys and require type annotations",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: "text",
+				Usage: "Output format: text or sarif",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output file for the SARIF log (default: stdout)",
+			},
+		},
+		ArgsUsage: "[file|dir|glob...]",
+		Action:    a.handleValidate,
+	}
+}
+
+// schemaCommand creates the schema command.
+func (a *App) schemaCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "schema",
+		Usage: "Work with up-schema files",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "infer",
+				Usage:     "Infer a draft schema from sample documents",
+				ArgsUsage: "[sample.up...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Output file (default: stdout)",
+					},
+					&cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "Report the number of samples read",
+					},
+				},
+				Action: a.handleSchemaInfer,
+			},
+		},
+	}
+}
+
+// checkCommand creates the check command.
+func (a *App) checkCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "check",
+		Usage: "Check documents for problems",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "roundtrip",
+				Usage:     "Verify that documents survive formatting and JSON conversion",
+				ArgsUsage: "file|dir|glob...",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "Also list the files that pass",
+					},
+				},
+				Action: a.handleCheckRoundtrip,
+			},
+		},
+	}
+}
+
+// lintCommand creates the lint command.
+func (a *App) lintCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "lint",
+		Usage: "Check UP documents for style and quality problems",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "Lint config file (default: the nearest " + lintConfigFile + ")",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: "text",
+				Usage: "Output format: text or sarif",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output file for the SARIF log (default: stdout)",
+			},
+			&cli.BoolFlag{
+				Name:  "fix",
+				Usage: "Apply safe fixes, rewriting the files",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show the fixes --fix would apply as a diff",
+			},
+			&cli.StringFlag{
+				Name:  "duplicates",
+				Usage: "Fix duplicate keys with `POLICY`: first, last or merge (default: only report them)",
+			},
+		},
+		ArgsUsage: "[file|dir|glob...]",
+		Action:    a.handleLint,
+	}
+}
+
+// queryCommand creates the query command.
+func (a *App) queryCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "query",
+		Aliases:   []string{"q"},
+		Usage:     "Select values from a UP document with a path expression",
+		ArgsUsage: "<expr>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "Input file (default: stdin)",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output each result as JSON",
+			},
+			&cli.BoolFlag{
+				Name:  "pretty",
+				Usage: "Pretty print JSON output",
+			},
+			&cli.BoolFlag{
+				Name:    "raw",
+				Aliases: []string{"r"},
+				Usage:   "Output scalars as plain text, one per line",
+			},
+			&cli.BoolFlag{
+				Name:    "exit-status",
+				Aliases: []string{"e"},
+				Usage:   "Exit non-zero when nothing matches",
+			},
+		},
+		Action: a.handleQuery,
+	}
+}
+
+// editFlags returns the flags shared by the editing commands.
+func editFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "input",
+			Aliases: []string{"i"},
+			Usage:   "Input file (default: stdin)",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output file (default: stdout)",
+		},
+		&cli.BoolFlag{
+			Name:    "write",
+			Aliases: []string{"w"},
+			Usage:   "Write the result back to the input file",
+		},
+	}
+}
+
+// setCommand creates the set command.
+func (a *App) setCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "set",
+		Usage:     "Set a value, keeping the rest of the document intact",
+		ArgsUsage: "<path> <value>",
+		Flags: append(editFlags(),
+			&cli.StringFlag{
+				Name:    "type",
+				Aliases: []string{"t"},
+				Usage:   "Type annotation for the value (default: keep the existing one)",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Replace a block or list with the scalar value",
+			},
+		),
+		Action: a.handleSet,
+	}
+}
+
+// deleteCommand creates the delete command.
+func (a *App) deleteCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "delete",
+		Aliases:   []string{"del", "rm"},
+		Usage:     "Delete a key or list item, keeping the rest of the document intact",
+		ArgsUsage: "<path>",
+		Flags:     editFlags(),
+		Action:    a.handleDelete,
+	}
+}
+
+// diffCommand creates the diff command.
+func (a *App) diffCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "Compare two UP documents structurally",
+		ArgsUsage: "<a.up> <b.up>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Value: "text",
+				Usage: "Output format (text, json, patch)",
+			},
+			&cli.StringFlag{
+				Name:  "color",
+				Value: "auto",
+				Usage: "Color text output (auto, always, never)",
+			},
+			&cli.BoolFlag{
+				Name:  "exit-code",
+				Usage: "Exit non-zero when the documents differ",
+			},
+		},
+		Action: a.handleDiff,
+	}
+}
+
+// patchCommand creates the patch command.
+func (a *App) patchCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "patch",
+		Usage: "Apply a JSON Patch (RFC 6902) or JSON Merge Patch (RFC 7386) to a UP document",
+		Flags: append(editFlags(),
+			&cli.StringFlag{
+				Name:     "patch",
+				Aliases:  []string{"p"},
+				Usage:    "Patch `FILE`: a JSON array of operations or a merge patch object",
+				Required: true,
+			},
+		),
+		Action: a.handlePatch,
+	}
+}
+
+// mergeCommand creates the merge command.
+func (a *App) mergeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "merge",
+		Usage:     "Deep-merge overlay documents into a base document",
+		ArgsUsage: "<base.up> [overlay.up...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output file (default: stdout)",
+			},
+			&cli.StringSliceFlag{
+				Name:    "strategy",
+				Aliases: []string{"s"},
+				Usage:   "Merge strategy as `PATH=STRATEGY`, or a default STRATEGY (merge, replace, append, unique, error)",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output as JSON instead of UP",
+			},
+			&cli.BoolFlag{
+				Name:  "pretty",
+				Usage: "Pretty print JSON output",
+			},
+		},
+		Action: a.handleMerge,
+	}
+}
+
+// gitCommand creates the git command.
+func (a *App) gitCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "git",
+		Usage: "Integrate with git merges and diffs",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "merge-driver",
+				Usage:     "Merge three versions of a UP file key by key (for merge.<driver>.driver)",
+				ArgsUsage: "%O %A %B",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "marker-size",
+						Value: 7,
+						Usage: "Length of conflict markers (%L)",
+					},
+				},
+				Action: a.handleGitMergeDriver,
+			},
+			{
+				Name:      "textconv",
+				Usage:     "Print the canonical form of a UP file (for diff.<driver>.textconv)",
+				ArgsUsage: "<file>",
+				Action:    a.handleGitTextconv,
+			},
 		},
-		Action: a.handleValidate,
 	}
 }
 
@@ -176,13 +558,26 @@ func (a *App) evalCommand() *cli.Command {
 				Usage:   "Output file (default: stdout)",
 			},
 			&cli.StringFlag{
-				Name:  "ns-path",
-				Usage: "Namespace search path",
-				Value: "./up-namespaces",
+				Name:    "ns-path",
+				Usage:   "Namespace search path (directories separated by the OS path list separator; default: from the project config, or ./up-namespaces)",
+				Value:   "./up-namespaces",
+				EnvVars: []string{"UP_NS_PATH"},
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output as JSON instead of UP",
 			},
 			&cli.BoolFlag{
 				Name:  "pretty",
-				Usage: "Pretty print output",
+				Usage: "Pretty print output as JSON",
+			},
+			&cli.Uint64Flag{
+				Name:  "seed",
+				Usage: "Seed for @random, for repeatable output",
+			},
+			&cli.StringFlag{
+				Name:  "now",
+				Usage: "Frozen clock for @time (RFC 3339 timestamp or date)",
 			},
 		},
 		Action: a.handleEval,
@@ -197,20 +592,18 @@ func (a *App) convertCommand() *cli.Command {
 		Usage:   "Convert between UP and other formats",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "input",
-				Aliases:  []string{"i"},
-				Usage:    "Input file",
-				Required: true,
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "Input file (default: stdin)",
 			},
 			&cli.StringFlag{
-				Name:     "output",
-				Aliases:  []string{"o"},
-				Usage:    "Output file",
-				Required: true,
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output file (default: stdout)",
 			},
 			&cli.StringFlag{
 				Name:  "from",
-				Usage: "Input format (json, yaml, toml) - auto-detected if not specified",
+				Usage: "Input format (up, json, yaml, toml) - auto-detected if not specified",
 			},
 			&cli.StringFlag{
 				Name:     "to",
@@ -221,6 +614,14 @@ func (a *App) convertCommand() *cli.Command {
 				Name:  "pretty",
 				Usage: "Pretty print output",
 			},
+			&cli.BoolFlag{
+				Name:  "split",
+				Usage: "Write each document of a multi-document input to its own numbered file",
+			},
+			&cli.BoolFlag{
+				Name:  "verbose",
+				Usage: "Report the detected input format on stderr",
+			},
 		},
 		Action: a.handleConvert,
 	}
@@ -302,57 +703,199 @@ func (a *App) handleParse(c *cli.Context) error {
 	}
 	defer a.closeIfFile(input)
 
-	output, err := a.getOutput(c.String("output"))
+	data, err := io.ReadAll(input)
 	if err != nil {
-		return fmt.Errorf("failed to open output: %w", err)
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	if _, err := parseSyntax(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to parse document: %w", withSource(err, inputName(c.String("input")), data))
 	}
-	defer a.closeIfFile(output)
 
-	doc, err := a.parser.ParseDocument(input)
+	doc, err := a.parser.ParseDocument(bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("failed to parse document: %w", err)
 	}
 
-	return a.writeJSON(output, doc, c.Bool("pretty"))
+	output, err := a.getOutput(c.String("output"))
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	if err := a.writeJSON(output, doc, c.Bool("pretty")); err != nil {
+		output.Abort()
+		return err
+	}
+	return output.Close()
 }
 
 // handleFormat processes the format command.
 func (a *App) handleFormat(c *cli.Context) error {
+	pc, err := a.project()
+	if err != nil {
+		return err
+	}
+	opts := pc.format
+	if c.IsSet("indent") {
+		opts.Indent = c.Int("indent")
+	}
+	if c.IsSet("sort-keys") {
+		opts.SortKeys = c.Bool("sort-keys")
+	}
+	if opts.Indent < 0 {
+		return fmt.Errorf("invalid indent %d", opts.Indent)
+	}
+	if c.NArg() > 0 {
+		if c.IsSet("input") || c.IsSet("output") {
+			return fmt.Errorf("--input and --output cannot be combined with file arguments")
+		}
+		return a.formatFiles(c, c.Args().Slice(), opts)
+	}
+	if c.Bool("write") {
+		if c.String("input") == "" || c.IsSet("output") {
+			return fmt.Errorf("--write requires file arguments or --input without --output")
+		}
+		return a.formatFiles(c, []string{c.String("input")}, opts)
+	}
+
 	input, err := a.getInput(c.String("input"))
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 	defer a.closeIfFile(input)
 
-	output, err := a.getOutput(c.String("output"))
+	data, err := io.ReadAll(input)
 	if err != nil {
-		return fmt.Errorf("failed to open output: %w", err)
+		return fmt.Errorf("failed to read input: %w", err)
 	}
-	defer a.closeIfFile(output)
 
-	doc, err := a.parser.ParseDocument(input)
+	name := inputName(c.String("input"))
+	formatted, err := a.formatSource(name, data, opts)
 	if err != nil {
-		return fmt.Errorf("failed to parse document: %w", err)
+		return err
 	}
 
-	return a.writeUP(output, doc)
+	if c.Bool("check") || c.Bool("diff") {
+		if bytes.Equal(data, formatted) {
+			return nil
+		}
+		if c.Bool("check") {
+			fmt.Fprintln(a.output, name)
+		}
+		if c.Bool("diff") {
+			if err := writeDiff(a.output, name, data, formatted); err != nil {
+				return err
+			}
+		}
+		if c.Bool("check") {
+			return fmt.Errorf("%s is not formatted", name)
+		}
+		return nil
+	}
+
+	output, err := a.getOutput(c.String("output"))
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+
+	if _, err := output.Write(formatted); err != nil {
+		output.Abort()
+		return err
+	}
+	return output.Close()
 }
 
-// handleValidate processes the validate command.
+// handleValidate processes the validate command. It checks the syntax of
+// each input and validates it against its schema, reporting every problem
+// found as text diagnostics or as a SARIF log.
 func (a *App) handleValidate(c *cli.Context) error {
-	input, err := a.getInput(c.String("input"))
+	format := c.String("format")
+	if format != "text" && format != "sarif" {
+		return fmt.Errorf("unknown validate format %q (expected text or sarif)", format)
+	}
+
+	inputs := []string{c.String("input")}
+	if c.NArg() > 0 {
+		if c.String("input") != "" {
+			return fmt.Errorf("--input cannot be combined with file arguments")
+		}
+		files, err := expandPaths(c.Args().Slice(), nil)
+		if err != nil {
+			return err
+		}
+		inputs = files
+	}
+
+	schemas := make(map[string]*schema)
+	var diags diagnosticError
+	for _, filename := range inputs {
+		found, err := a.validateFile(c, filename, schemas)
+		if err != nil {
+			return err
+		}
+		diags = append(diags, found...)
+	}
+
+	if format == "sarif" {
+		if err := a.writeSARIFFile(c.String("output"), diags); err != nil {
+			return err
+		}
+		if len(diags) > 0 {
+			return fmt.Errorf("validation failed: %d problem(s)", len(diags))
+		}
+		return nil
+	}
+
+	if len(diags) > 0 {
+		return fmt.Errorf("validation failed: %w", diags)
+	}
+	if len(inputs) == 1 {
+		fmt.Fprintf(a.output, "✓ Document is valid\n")
+	} else {
+		fmt.Fprintf(a.output, "✓ %d documents are valid\n", len(inputs))
+	}
+	return nil
+}
+
+// validateFile checks the syntax of a file, or stdin when filename is empty,
+// and validates it against its schema. Loaded schemas are kept in schemas,
+// keyed by path. Problems in the file are returned as diagnostics; other
+// failures, such as an unreadable schema, as an error.
+func (a *App) validateFile(c *cli.Context, filename string, schemas map[string]*schema) (diagnosticError, error) {
+	data, err := a.readSource(filename)
 	if err != nil {
-		return fmt.Errorf("failed to read input: %w", err)
+		return nil, err
 	}
-	defer a.closeIfFile(input)
 
-	_, err = a.parser.ParseDocument(input)
+	doc, err := parseSource(inputName(filename), data)
 	if err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+		var diags diagnosticError
+		if errors.As(err, &diags) {
+			return diags, nil
+		}
+		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
-	fmt.Fprintf(a.output, "✓ Document is valid\n")
-	return nil
+	schemaPath := c.String("schema")
+	if schemaPath == "" {
+		pc, err := a.project()
+		if err != nil {
+			return nil, err
+		}
+		schemaPath, _ = findSchema(filename, doc, pc)
+	}
+	if schemaPath == "" {
+		return nil, nil
+	}
+	s, ok := schemas[schemaPath]
+	if !ok {
+		if s, err = loadSchema(schemaPath); err != nil {
+			return nil, fmt.Errorf("failed to load schema: %w", err)
+		}
+		schemas[schemaPath] = s
+	}
+	if violations := validateDocument(doc, s, c.Bool("strict")); len(violations) > 0 {
+		return violationDiagnostics(inputName(filename), data, violations), nil
+	}
+	return nil, nil
 }
 
 // getInput returns an io.ReadCloser for the input source.
@@ -368,13 +911,66 @@ func (a *App) getInput(filename string) (io.ReadCloser, error) {
 	return file, nil
 }
 
-// getOutput returns an io.WriteCloser for the output destination.
-func (a *App) getOutput(filename string) (io.WriteCloser, error) {
+// readSource reads the contents of a file, or stdin when filename is empty.
+func (a *App) readSource(filename string) ([]byte, error) {
+	input, err := a.getInput(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	data, err := io.ReadAll(input)
+	a.closeIfFile(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	return data, nil
+}
+
+// readInput reads and parses the document in a file, or stdin when filename
+// is empty.
+func (a *App) readInput(filename string) (*up.Document, error) {
+	data, err := a.readSource(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseSource(inputName(filename), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+	return doc, nil
+}
+
+// readSyntax reads the syntax tree of the document in a file, or stdin when
+// filename is empty.
+func (a *App) readSyntax(filename string) (*syntaxNode, error) {
+	data, err := a.readSource(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseSyntax(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", withSource(err, inputName(filename), data))
+	}
+	return root, nil
+}
+
+// outputWriter is the output destination of a command. Close commits what
+// was written; Abort discards it, so that a failed write never replaces an
+// existing file.
+type outputWriter interface {
+	io.WriteCloser
+	Abort()
+}
+
+// getOutput returns an outputWriter for the output destination. Files are
+// replaced when the writer is closed, so the output may name the input.
+func (a *App) getOutput(filename string) (outputWriter, error) {
 	if filename == "" {
 		return &nopWriteCloser{a.output}, nil
 	}
 
-	file, err := os.Create(filename)
+	file, err := createAtomic(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -410,50 +1006,17 @@ func (a *App) writeJSON(w io.Writer, doc *up.Document, pretty bool) error {
 }
 
 // writeUP writes the document back as formatted UP.
-func (a *App) writeUP(w io.Writer, doc *up.Document) error {
-	for _, node := range doc.Nodes {
-		line := a.formatNode(node)
-		if _, err := fmt.Fprintln(w, line); err != nil {
-			return err
-		}
-	}
-	return nil
+func writeUP(w io.Writer, doc *up.Document) error {
+	return formatSyntax(w, syntaxFromDocument(doc), defaultFormatOptions)
 }
 
-// formatNode formats a single node back to UP format.
-func (a *App) formatNode(node up.Node) string {
-	key := node.Key
-	if node.Type != "" {
-		key += "!" + node.Type
-	}
-
-	switch v := node.Value.(type) {
-	case string:
-		if strings.Contains(v, "\n") {
-			return fmt.Sprintf("%s ```\n%s\n```", key, v)
-		}
-		return fmt.Sprintf("%s %s", key, v)
-	case up.Block:
-		lines := []string{key + " {"}
-		for k, val := range v {
-			subNode := up.Node{Key: k, Value: val}
-			lines = append(lines, "  "+a.formatNode(subNode))
-		}
-		lines = append(lines, "}")
-		return strings.Join(lines, "\n")
-	case up.List:
-		if len(v) == 0 {
-			return key + " []"
-		}
-		lines := []string{key + " ["}
-		for _, item := range v {
-			lines = append(lines, fmt.Sprintf("  %v", item))
-		}
-		lines = append(lines, "]")
-		return strings.Join(lines, "\n")
-	default:
-		return fmt.Sprintf("%s %v", key, v)
-	}
+// needsMultiline reports whether a string can only be written as a
+// triple-backtick multiline value without changing its meaning.
+func needsMultiline(s string) bool {
+	return strings.Contains(s, "\n") ||
+		s != strings.TrimSpace(s) ||
+		s == "{" || s == "[" ||
+		strings.HasPrefix(s, "```")
 }
 
 // templateCommand creates the template command.
@@ -466,7 +1029,7 @@ func (a *App) templateCommand() *cli.Command {
 			{
 				Name:  "process",
 				Usage: "Process a template and output the result",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "input",
 						Aliases: []string{"i"},
@@ -486,20 +1049,20 @@ func (a *App) templateCommand() *cli.Command {
 						Name:  "pretty",
 						Usage: "Pretty print output",
 					},
-				},
+				}, templateValueFlags()...),
 				Action: a.handleTemplateProcess,
 			},
 			{
 				Name:  "validate",
-				Usage: "Validate a template",
-				Flags: []cli.Flag{
+				Usage: "Validate a template and the values given to it",
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "input",
 						Aliases: []string{"i"},
 						Usage:   "Input template file",
 						Required: true,
 					},
-				},
+				}, templateValueFlags()...),
 				Action: a.handleTemplateValidate,
 			},
 		},
@@ -508,8 +1071,7 @@ func (a *App) templateCommand() *cli.Command {
 
 // handleTemplateProcess processes a template
 func (a *App) handleTemplateProcess(c *cli.Context) error {
-	engine := up.NewTemplateEngine()
-	doc, err := engine.ProcessTemplate(c.String("input"))
+	doc, err := a.processTemplate(c)
 	if err != nil {
 		return fmt.Errorf("template processing failed: %w", err)
 	}
@@ -518,18 +1080,21 @@ func (a *App) handleTemplateProcess(c *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to open output: %w", err)
 	}
-	defer a.closeIfFile(output)
-
 	if c.Bool("json") {
-		return a.writeJSON(output, doc, c.Bool("pretty"))
+		err = a.writeJSON(output, doc, c.Bool("pretty"))
+	} else {
+		err = writeUP(output, doc)
+	}
+	if err != nil {
+		output.Abort()
+		return err
 	}
-	return a.writeUP(output, doc)
+	return output.Close()
 }
 
 // handleTemplateValidate validates a template
 func (a *App) handleTemplateValidate(c *cli.Context) error {
-	engine := up.NewTemplateEngine()
-	_, err := engine.ProcessTemplate(c.String("input"))
+	_, err := a.processTemplate(c)
 	if err != nil {
 		return fmt.Errorf("template validation failed: %w", err)
 	}
@@ -538,20 +1103,6 @@ func (a *App) handleTemplateValidate(c *cli.Context) error {
 	return nil
 }
 
-// handleEval processes the eval command.
-func (a *App) handleEval(c *cli.Context) error {
-	fmt.Fprintf(a.output, "Eval command not yet implemented\n")
-	fmt.Fprintf(a.output, "This will evaluate dynamic namespaces in UP documents\n")
-	return nil
-}
-
-// handleConvert processes the convert command.
-func (a *App) handleConvert(c *cli.Context) error {
-	fmt.Fprintf(a.output, "Convert command not yet implemented\n")
-	fmt.Fprintf(a.output, "This will convert between UP and other formats (JSON, YAML, TOML)\n")
-	return nil
-}
-
 // handleLSP starts the language server.
 func (a *App) handleLSP(c *cli.Context) error {
 	// Try to exec up-language-server
@@ -563,7 +1114,7 @@ func (a *App) handleLSP(c *cli.Context) error {
 		args = append(args, "--log", logFile)
 	}
 
-	return execTool("up-language-server", args[1:])
+	return a.runTool("up-language-server", args[1:])
 }
 
 // handleREPL starts the interactive REPL.
@@ -574,7 +1125,7 @@ func (a *App) handleREPL(c *cli.Context) error {
 		args = append(args, "--debug")
 	}
 
-	return execTool("up-repl", args)
+	return a.runTool("up-repl", args)
 }
 
 // handleTool dispatches to external tools.
@@ -586,7 +1137,7 @@ func (a *App) handleTool(c *cli.Context) error {
 	toolName := c.Args().First()
 	toolArgs := c.Args().Tail()
 
-	return execTool("up-"+toolName, toolArgs)
+	return a.runTool("up-"+toolName, toolArgs)
 }
 
 // handleVersion prints version information.
@@ -618,16 +1169,33 @@ func (a *App) handleVersion(c *cli.Context) error {
 	return nil
 }
 
-// execTool executes an external tool binary.
-func execTool(name string, args []string) error {
+// runTool executes an external tool binary, searching the tool path of the
+// project config first.
+func (a *App) runTool(name string, args []string) error {
+	pc, err := a.project()
+	if err != nil {
+		return err
+	}
+	return execTool(name, args, pc.toolPath)
+}
+
+// execTool executes an external tool binary, looked up in dirs, then next
+// to the up binary, then in PATH.
+func execTool(name string, args []string, dirs []string) error {
 	// Try to find the tool in PATH
 	path, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("failed to get executable path: %w", err)
 	}
 
-	// Try same directory as up binary first
+	// Try the given directories and the directory of the up binary first
 	toolPath := strings.TrimSuffix(path, "up") + name
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			toolPath = filepath.Join(dir, name)
+			break
+		}
+	}
 	if _, err := os.Stat(toolPath); os.IsNotExist(err) {
 		// Try PATH
 		var lookErr error
@@ -662,10 +1230,15 @@ func (nwc *nopWriteCloser) Close() error {
 	return nil
 }
 
+// Abort does nothing, as what was written cannot be taken back.
+func (nwc *nopWriteCloser) Abort() {}
+
 func main() {
 	app := DefaultApp()
 	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if !errors.As(err, new(reportedError)) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
 		os.Exit(1)
 	}
 }
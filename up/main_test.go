@@ -0,0 +1,19 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	up "github.com/uplang/go"
+)
+
+// runApp runs the up command line with the given standard input and
+// returns what it wrote to the standard output.
+func runApp(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	app := NewApp(up.NewParser(), &out, strings.NewReader(stdin), func(int) {})
+	err := app.Run(append([]string{"up"}, args...))
+	return out.String(), err
+}
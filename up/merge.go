@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// mergeStrategy decides how an overlay value is combined with the value it
+// overrides. A strategy applies to a key and everything below it, unless a
+// more specific rule says otherwise.
+type mergeStrategy string
+
+const (
+	// mergeDeep merges blocks key by key and replaces everything else.
+	mergeDeep mergeStrategy = "merge"
+	// mergeReplace replaces the value as a whole.
+	mergeReplace mergeStrategy = "replace"
+	// mergeAppend merges blocks and appends overlay list items.
+	mergeAppend mergeStrategy = "append"
+	// mergeUnique appends overlay list items that are not already present.
+	mergeUnique mergeStrategy = "unique"
+	// mergeError merges blocks and fails when a value differs.
+	mergeError mergeStrategy = "error"
+)
+
+// mergeStrategies lists the valid strategies.
+var mergeStrategies = map[mergeStrategy]bool{
+	mergeDeep:    true,
+	mergeReplace: true,
+	mergeAppend:  true,
+	mergeUnique:  true,
+	mergeError:   true,
+}
+
+// mergeRule assigns a strategy to the keys matching a pattern. A nil pattern
+// sets the default strategy for the whole document.
+type mergeRule struct {
+	pattern  []string
+	strategy mergeStrategy
+}
+
+// matches reports whether the rule applies to a dotted key path. Each
+// segment of the pattern is matched against one key with path.Match, so *
+// stands for exactly one key.
+func (r mergeRule) matches(keyPath string) bool {
+	if r.pattern == nil {
+		return false
+	}
+	keys := strings.Split(keyPath, ".")
+	if len(keys) != len(r.pattern) {
+		return false
+	}
+	for i, pattern := range r.pattern {
+		if ok, _ := path.Match(pattern, keys[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMergeRule parses a PATH=STRATEGY rule, or a bare STRATEGY that sets
+// the default.
+func parseMergeRule(spec string) (mergeRule, error) {
+	pattern, name, found := strings.Cut(spec, "=")
+	if !found {
+		pattern, name = "", spec
+	}
+	return newMergeRule(pattern, name)
+}
+
+// newMergeRule builds a rule from a key pattern and a strategy name.
+func newMergeRule(pattern, name string) (mergeRule, error) {
+	strategy := mergeStrategy(strings.TrimSpace(name))
+	if !mergeStrategies[strategy] {
+		return mergeRule{}, fmt.Errorf("unknown merge strategy %q (expected merge, replace, append, unique or error)", name)
+	}
+	if pattern = strings.TrimSpace(pattern); pattern == "" {
+		return mergeRule{strategy: strategy}, nil
+	}
+	segments := strings.Split(pattern, ".")
+	for _, seg := range segments {
+		if _, err := path.Match(seg, ""); seg == "" || err != nil {
+			return mergeRule{}, fmt.Errorf("invalid key pattern %q", pattern)
+		}
+	}
+	return mergeRule{pattern: segments, strategy: strategy}, nil
+}
+
+// mergeDirectiveRules reads the rules of a !merge directive. It accepts the
+// strategy and list_strategy settings of template merge directives, and a
+// keys block mapping key patterns to strategies:
+//
+//	options!merge {
+//	  list_strategy append
+//	  keys {
+//	    servers replace
+//	    version error
+//	  }
+//	}
+func mergeDirectiveRules(value up.Value) ([]mergeRule, error) {
+	block, ok := value.(up.Block)
+	if !ok {
+		return nil, fmt.Errorf("a !merge directive must be a block")
+	}
+
+	var rules []mergeRule
+	deep := true
+	for _, k := range sortedKeys(block) {
+		key, _ := splitKey(k)
+		setting, isString := block[k].(string)
+		switch {
+		case key == "keys":
+			keys, ok := block[k].(up.Block)
+			if !ok {
+				return nil, fmt.Errorf("keys must be a block of key patterns and strategies")
+			}
+			for _, pattern := range sortedKeys(keys) {
+				name, _ := keys[pattern].(string)
+				bare, _ := splitKey(pattern)
+				rule, err := newMergeRule(bare, name)
+				if err != nil {
+					return nil, err
+				}
+				if rule.pattern == nil {
+					return nil, fmt.Errorf("invalid key pattern %q", pattern)
+				}
+				rules = append(rules, rule)
+			}
+		case key == "strategy" && isString:
+			switch setting {
+			case "deep":
+			case "shallow", "replace":
+				deep = false
+			default:
+				return nil, fmt.Errorf("unknown strategy %q (expected deep, shallow or replace)", setting)
+			}
+		case key == "list_strategy" && isString:
+			rule, err := newMergeRule("", setting)
+			if err != nil || (rule.strategy != mergeAppend && rule.strategy != mergeUnique && rule.strategy != mergeReplace) {
+				return nil, fmt.Errorf("unknown list_strategy %q (expected append, unique or replace)", setting)
+			}
+			if rule.strategy != mergeReplace {
+				rules = append([]mergeRule{rule}, rules...)
+			}
+		default:
+			return nil, fmt.Errorf("unknown !merge setting %q", key)
+		}
+	}
+	if !deep {
+		// A shallow merge replaces every top-level value.
+		rules = append([]mergeRule{{pattern: []string{"*"}, strategy: mergeReplace}}, rules...)
+	}
+	return rules, nil
+}
+
+// merger deep-merges documents according to its rules. Later rules take
+// precedence over earlier ones.
+type merger struct {
+	rules []mergeRule
+}
+
+// strategy returns the strategy for a key path: that of the last matching
+// rule, or the one inherited from the enclosing block.
+func (m *merger) strategy(keyPath string, inherited mergeStrategy) mergeStrategy {
+	for _, r := range m.rules {
+		if r.matches(keyPath) {
+			inherited = r.strategy
+		}
+	}
+	return inherited
+}
+
+// root returns the default strategy for the whole document.
+func (m *merger) root() mergeStrategy {
+	strategy := mergeDeep
+	for _, r := range m.rules {
+		if r.pattern == nil {
+			strategy = r.strategy
+		}
+	}
+	return strategy
+}
+
+// mergeDocuments merges an overlay into a base document. Top-level keys
+// keep the order of the base, followed by those only the overlay has.
+func (m *merger) mergeDocuments(base, overlay *up.Document) (*up.Document, error) {
+	result := &up.Document{Nodes: make([]up.Node, len(base.Nodes))}
+	copy(result.Nodes, base.Nodes)
+
+	index := make(map[string]int, len(result.Nodes))
+	for i, node := range result.Nodes {
+		index[node.Key] = i
+	}
+	for _, node := range overlay.Nodes {
+		i, ok := index[node.Key]
+		if !ok {
+			index[node.Key] = len(result.Nodes)
+			result.Nodes = append(result.Nodes, node)
+			continue
+		}
+		typ, value, err := m.mergeValues(node.Key, m.root(), result.Nodes[i].Type, result.Nodes[i].Value, node.Type, node.Value)
+		if err != nil {
+			return nil, err
+		}
+		result.Nodes[i] = up.Node{Key: node.Key, Type: typ, Value: value}
+	}
+	return result, nil
+}
+
+// mergeValues merges an overlay value into a base value at a key path,
+// returning the merged value and its type annotation.
+func (m *merger) mergeValues(keyPath string, inherited mergeStrategy, baseType string, base up.Value, overlayType string, overlay up.Value) (string, up.Value, error) {
+	strategy := m.strategy(keyPath, inherited)
+	typ := mergedType(baseType, base, overlayType, overlay)
+	if strategy == mergeReplace {
+		return typ, overlay, nil
+	}
+
+	baseBlock, isBaseBlock := base.(up.Block)
+	overlayBlock, isOverlayBlock := overlay.(up.Block)
+	if isBaseBlock && isOverlayBlock {
+		block, err := m.mergeBlocks(keyPath, strategy, baseBlock, overlayBlock)
+		return typ, block, err
+	}
+
+	baseList, isBaseList := listValue(base)
+	overlayList, isOverlayList := listValue(overlay)
+	switch {
+	case isBaseList && isOverlayList && strategy == mergeAppend:
+		return typ, append(append(up.List{}, baseList...), overlayList...), nil
+	case isBaseList && isOverlayList && strategy == mergeUnique:
+		list := append(up.List{}, baseList...)
+		for _, item := range overlayList {
+			if !containsItem(list, item) {
+				list = append(list, item)
+			}
+		}
+		return typ, list, nil
+	case strategy == mergeError:
+		var changes []change
+		compareValues(keyPath, "", baseType, base, overlayType, overlay, &changes)
+		if len(changes) > 0 {
+			return "", nil, fmt.Errorf("conflict at %s: %s overridden by %s", keyPath, describeValue(baseType, base), describeValue(overlayType, overlay))
+		}
+	}
+	return typ, overlay, nil
+}
+
+// mergeBlocks merges the entries of an overlay block into a base block,
+// matching keys by name regardless of their type annotations.
+func (m *merger) mergeBlocks(keyPath string, strategy mergeStrategy, base, overlay up.Block) (up.Block, error) {
+	result := make(up.Block, len(base)+len(overlay))
+	baseKeys := make(map[string]string, len(base))
+	for k, v := range base {
+		result[k] = v
+		key, _ := splitKey(k)
+		baseKeys[key] = k
+	}
+
+	for _, k := range sortedKeys(overlay) {
+		key, overlayType := splitKey(k)
+		baseKey, ok := baseKeys[key]
+		if !ok {
+			result[k] = overlay[k]
+			continue
+		}
+		_, baseType := splitKey(baseKey)
+		typ, value, err := m.mergeValues(keyPath+"."+key, strategy, baseType, base[baseKey], overlayType, overlay[k])
+		if err != nil {
+			return nil, err
+		}
+		delete(result, baseKey)
+		result[joinKey(key, typ)] = value
+	}
+	return result, nil
+}
+
+// mergedType returns the annotation of a merged value. The overlay's
+// annotation wins; without one, the base annotation is kept as long as it
+// still fits the value.
+func mergedType(baseType string, base up.Value, overlayType string, overlay up.Value) string {
+	if overlayType != "" || baseType == "" || isDedent(baseType) {
+		return overlayType
+	}
+	_, isBaseBlock := base.(up.Block)
+	_, isOverlayBlock := overlay.(up.Block)
+	_, isBaseList := listValue(base)
+	_, isOverlayList := listValue(overlay)
+	if isBaseBlock != isOverlayBlock || isBaseList != isOverlayList {
+		return ""
+	}
+	if s, ok := overlay.(string); ok && schemaTypes[baseType] && !validScalar(baseType, s) {
+		return ""
+	}
+	return baseType
+}
+
+// containsItem reports whether a list holds an item.
+func containsItem(list up.List, item up.Value) bool {
+	for _, x := range list {
+		if reflect.DeepEqual(x, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMerge processes the merge command. Each document is merged into the
+// result of the ones before it, so later files take precedence.
+func (a *App) handleMerge(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("expected a base document and overlays")
+	}
+
+	for _, arg := range c.Args().Slice() {
+		// Flags are only parsed before the first file.
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			return fmt.Errorf("flag %s must come before the files", arg)
+		}
+	}
+
+	var docs []*up.Document
+	var directives []mergeRule
+	for _, filename := range c.Args().Slice() {
+		doc, err := a.readMergeInput(filename)
+		if err != nil {
+			return err
+		}
+		// !merge directives configure the merge and are not merged themselves.
+		kept := doc.Nodes[:0]
+		for _, node := range doc.Nodes {
+			if node.Type != "merge" {
+				kept = append(kept, node)
+				continue
+			}
+			rules, err := mergeDirectiveRules(node.Value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid %s!merge directive: %w", filename, node.Key, err)
+			}
+			directives = append(directives, rules...)
+		}
+		doc.Nodes = kept
+		docs = append(docs, doc)
+	}
+
+	m := &merger{rules: directives}
+	for _, spec := range c.StringSlice("strategy") {
+		rule, err := parseMergeRule(spec)
+		if err != nil {
+			return err
+		}
+		m.rules = append(m.rules, rule)
+	}
+
+	result := docs[0]
+	for i, doc := range docs[1:] {
+		var err error
+		if result, err = m.mergeDocuments(result, doc); err != nil {
+			return fmt.Errorf("failed to merge %s: %w", c.Args().Get(i+1), err)
+		}
+	}
+
+	encoder := codec(upCodec{})
+	if c.Bool("json") {
+		encoder = jsonCodec{}
+	}
+	return a.writeConverted(c.String("output"), encoder, result, c.Bool("pretty"))
+}
+
+// readMergeInput reads a document to merge from a file, or from stdin for -.
+func (a *App) readMergeInput(filename string) (*up.Document, error) {
+	if filename == "-" {
+		return a.readInput("")
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	doc, err := parseSource(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	mergeTestBase    = "name app\nport!int 80\nserver {\n  host a\n  tags [\n    x\n  ]\n}\nlist [\n  1\n  2\n]\n"
+	mergeTestOverlay = "port 8080\nserver {\n  tags [\n    y\n    x\n  ]\n  tls!bool true\n}\nlist [\n  2\n  3\n]\nextra e\n"
+)
+
+func TestMerge(t *testing.T) {
+	base := writeTestFile(t, "base.up", mergeTestBase)
+	overlay := writeTestFile(t, "overlay.up", mergeTestOverlay)
+	directive := writeTestFile(t, "directive.up", "opts!merge {\n  list_strategy append\n  keys {\n    server.tags unique\n  }\n}\n")
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{
+			name: "deep merge",
+			args: []string{base, overlay},
+			want: "name app\nport!int 8080\nserver {\n  host a\n  tags [\n    y\n    x\n  ]\n  tls!bool true\n}\nlist [\n  2\n  3\n]\nextra e\n",
+		},
+		{
+			name: "base only",
+			args: []string{base},
+			want: mergeTestBase,
+		},
+		{
+			name: "unique lists",
+			args: []string{"--strategy", "unique", base, overlay},
+			want: "name app\nport!int 8080\nserver {\n  host a\n  tags [\n    x\n    y\n  ]\n  tls!bool true\n}\nlist [\n  1\n  2\n  3\n]\nextra e\n",
+		},
+		{
+			name: "path rule overrides default",
+			args: []string{"--strategy", "append", "--strategy", "server=replace", base, overlay},
+			want: "name app\nport!int 8080\nserver {\n  tags [\n    y\n    x\n  ]\n  tls!bool true\n}\nlist [\n  1\n  2\n  2\n  3\n]\nextra e\n",
+		},
+		{
+			name: "directive",
+			args: []string{directive, base, overlay},
+			want: "name app\nport!int 8080\nserver {\n  host a\n  tags [\n    x\n    y\n  ]\n  tls!bool true\n}\nlist [\n  1\n  2\n  2\n  3\n]\nextra e\n",
+		},
+		{
+			name:  "overlay from stdin",
+			stdin: "name other\n",
+			args:  []string{base, "-"},
+			want:  strings.Replace(mergeTestBase, "name app", "name other", 1),
+		},
+		{
+			name: "annotation dropped when the value no longer fits",
+			args: []string{base, writeTestFile(t, "port.up", "port auto\n")},
+			want: strings.Replace(mergeTestBase, "port!int 80", "port auto", 1),
+		},
+		{
+			name: "json",
+			args: []string{"--json", base, overlay},
+			want: `{"name":"app","port":8080,"server":{"host":"a","tags":["y","x"],"tls":true},"list":["2","3"],"extra":"e"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, tt.stdin, append([]string{"merge"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	base := writeTestFile(t, "base.up", mergeTestBase)
+	overlay := writeTestFile(t, "overlay.up", mergeTestOverlay)
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no documents", nil, "expected a base document and overlays"},
+		{"conflict", []string{"--strategy", "error", base, overlay}, `failed to merge ` + overlay + `: conflict at port: "80" (!int) overridden by "8080"`},
+		{"same value is no conflict", []string{"--strategy", "error", base, base}, ""},
+		{"unknown strategy", []string{"--strategy", "mix", base}, `unknown merge strategy "mix"`},
+		{"invalid pattern", []string{"--strategy", "a..b=replace", base}, `invalid key pattern "a..b"`},
+		{"invalid directive", []string{writeTestFile(t, "d.up", "m!merge x\n"), base}, "invalid m!merge directive: a !merge directive must be a block"},
+		{"unknown directive setting", []string{writeTestFile(t, "d.up", "m!merge {\n  depth 2\n}\n"), base}, `unknown !merge setting "depth"`},
+		{"missing file", []string{base, base + ".missing"}, "failed to read input"},
+		{"trailing flag", []string{base, overlay, "-o", "out.up"}, "flag -o must come before the files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, "", append([]string{"merge"}, tt.args...)...)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRuleMatches(t *testing.T) {
+	tests := []struct {
+		spec    string
+		keyPath string
+		want    bool
+	}{
+		{"server=replace", "server", true},
+		{"server=replace", "server.host", false},
+		{"*.tags=unique", "server.tags", true},
+		{"*.tags=unique", "tags", false},
+		{"srv?=error", "srv1", true},
+		{"replace", "anything", false},
+	}
+	for _, tt := range tests {
+		rule, err := parseMergeRule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseMergeRule(%q): %v", tt.spec, err)
+		}
+		if got := rule.matches(tt.keyPath); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.spec, tt.keyPath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxStringLength is the longest string the built-in functions generate, so
+// that a single argument cannot make them allocate unbounded memory.
+const maxStringLength = 1 << 20
+
+// builtinFunc implements a single built-in namespace function.
+type builtinFunc func(args []string) (any, error)
+
+// builtinNamespace is a namespace implemented inside the up binary.
+type builtinNamespace map[string]builtinFunc
+
+// Call invokes the named built-in function.
+func (b builtinNamespace) Call(_ context.Context, call invocation) (any, error) {
+	fn, ok := b[call.Function]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", call.Function)
+	}
+	return fn(call.Args)
+}
+
+// builtinNamespaces returns the namespaces available without plugins. @time
+// reads the given clock and @random draws from r.
+func builtinNamespaces(clock func() time.Time, r *rand.Rand) map[string]namespace {
+	return map[string]namespace{
+		"string": stringNamespace(),
+		"math":   mathNamespace(),
+		"time":   timeNamespace(clock),
+		"random": randomNamespace(r),
+	}
+}
+
+// stringNamespace implements @string.
+func stringNamespace() builtinNamespace {
+	return builtinNamespace{
+		"upper": unaryString(strings.ToUpper),
+		"lower": unaryString(strings.ToLower),
+		"title": unaryString(titleCase),
+		"trim":  unaryString(strings.TrimSpace),
+		"length": func(args []string) (any, error) {
+			if err := checkArgs(args, 1, 1); err != nil {
+				return nil, err
+			}
+			return int64(len([]rune(args[0]))), nil
+		},
+		"concat": func(args []string) (any, error) {
+			return strings.Join(args, ""), nil
+		},
+		"join": func(args []string) (any, error) {
+			if err := checkArgs(args, 1, -1); err != nil {
+				return nil, err
+			}
+			return strings.Join(args[1:], args[0]), nil
+		},
+		"split": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 2); err != nil {
+				return nil, err
+			}
+			parts := strings.Split(args[0], args[1])
+			items := make([]any, len(parts))
+			for i, part := range parts {
+				items[i] = part
+			}
+			return items, nil
+		},
+		"replace": func(args []string) (any, error) {
+			if err := checkArgs(args, 3, 3); err != nil {
+				return nil, err
+			}
+			return strings.ReplaceAll(args[0], args[1], args[2]), nil
+		},
+		"repeat": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 2); err != nil {
+				return nil, err
+			}
+			n, err := intArg(args[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid repeat count %q", args[1])
+			}
+			if n > 0 && int64(len(args[0])) > maxStringLength/n {
+				return nil, fmt.Errorf("result length exceeds the maximum of %d", maxStringLength)
+			}
+			return strings.Repeat(args[0], int(n)), nil
+		},
+		"substr": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 3); err != nil {
+				return nil, err
+			}
+			runes := []rune(args[0])
+			start, err := intArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			end := int64(len(runes))
+			if len(args) == 3 {
+				if end, err = intArg(args[2]); err != nil {
+					return nil, err
+				}
+			}
+			if start < 0 || end > int64(len(runes)) || start > end {
+				return nil, fmt.Errorf("range [%d:%d] out of bounds for length %d", start, end, len(runes))
+			}
+			return string(runes[start:end]), nil
+		},
+	}
+}
+
+// mathNamespace implements @math. Results are integers when every operand is
+// an integer and the operation stays within the integers. Integer overflow
+// and results that are not finite numbers are errors.
+func mathNamespace() builtinNamespace {
+	return builtinNamespace{
+		"add": foldNumbers(addInt, func(a, b float64) float64 { return a + b }),
+		"sub": foldNumbers(subInt, func(a, b float64) float64 { return a - b }),
+		"mul": foldNumbers(mulInt, func(a, b float64) float64 { return a * b }),
+		"min": foldNumbers(func(a, b int64) (int64, bool) { return min(a, b), true }, math.Min),
+		"max": foldNumbers(func(a, b int64) (int64, bool) { return max(a, b), true }, math.Max),
+		"div": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 2); err != nil {
+				return nil, err
+			}
+			a, b, err := floatArgs(args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			if b == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return numberResult(a / b)
+		},
+		"mod": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 2); err != nil {
+				return nil, err
+			}
+			a, errA := intArg(args[0])
+			b, errB := intArg(args[1])
+			if errA != nil || errB != nil {
+				return nil, fmt.Errorf("mod expects integers")
+			}
+			if b == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return a % b, nil
+		},
+		"pow": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 2); err != nil {
+				return nil, err
+			}
+			a, b, err := floatArgs(args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			return numberResult(math.Pow(a, b))
+		},
+		"abs":   unaryFloat(math.Abs),
+		"sqrt":  unaryFloat(math.Sqrt),
+		"floor": unaryFloat(math.Floor),
+		"ceil":  unaryFloat(math.Ceil),
+		"round": unaryFloat(math.Round),
+	}
+}
+
+// timeNamespace implements @time using now as its clock.
+func timeNamespace(now func() time.Time) builtinNamespace {
+	return builtinNamespace{
+		"now": func(args []string) (any, error) {
+			if err := checkArgs(args, 0, 0); err != nil {
+				return nil, err
+			}
+			return timestamp(now().Format(time.RFC3339)), nil
+		},
+		"today": func(args []string) (any, error) {
+			if err := checkArgs(args, 0, 0); err != nil {
+				return nil, err
+			}
+			return timestamp(now().Format("2006-01-02")), nil
+		},
+		"unix": func(args []string) (any, error) {
+			if err := checkArgs(args, 0, 0); err != nil {
+				return nil, err
+			}
+			return now().Unix(), nil
+		},
+		"add": func(args []string) (any, error) {
+			if err := checkArgs(args, 1, 1); err != nil {
+				return nil, err
+			}
+			d, err := time.ParseDuration(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return timestamp(now().Add(d).Format(time.RFC3339)), nil
+		},
+		"format": func(args []string) (any, error) {
+			if err := checkArgs(args, 1, 1); err != nil {
+				return nil, err
+			}
+			return now().Format(args[0]), nil
+		},
+	}
+}
+
+// randomNamespace implements @random using r as its source.
+func randomNamespace(r *rand.Rand) builtinNamespace {
+	return builtinNamespace{
+		"int": func(args []string) (any, error) {
+			if err := checkArgs(args, 2, 2); err != nil {
+				return nil, err
+			}
+			lo, errLo := intArg(args[0])
+			hi, errHi := intArg(args[1])
+			if errLo != nil || errHi != nil || lo > hi {
+				return nil, fmt.Errorf("invalid range [%s, %s]", args[0], args[1])
+			}
+			// Draw from the unsigned span so that ranges wider than
+			// MaxInt64 do not overflow.
+			span := uint64(hi - lo)
+			if span == math.MaxUint64 {
+				return int64(r.Uint64()), nil
+			}
+			return lo + int64(r.Uint64N(span+1)), nil
+		},
+		"float": func(args []string) (any, error) {
+			if err := checkArgs(args, 0, 0); err != nil {
+				return nil, err
+			}
+			return r.Float64(), nil
+		},
+		"bool": func(args []string) (any, error) {
+			if err := checkArgs(args, 0, 0); err != nil {
+				return nil, err
+			}
+			return r.IntN(2) == 1, nil
+		},
+		"choice": func(args []string) (any, error) {
+			if err := checkArgs(args, 1, -1); err != nil {
+				return nil, err
+			}
+			return args[r.IntN(len(args))], nil
+		},
+		"string": func(args []string) (any, error) {
+			if err := checkArgs(args, 1, 1); err != nil {
+				return nil, err
+			}
+			n, err := intArg(args[0])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid length %q", args[0])
+			}
+			if n > maxStringLength {
+				return nil, fmt.Errorf("length %d exceeds the maximum of %d", n, maxStringLength)
+			}
+			const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = alphabet[r.IntN(len(alphabet))]
+			}
+			return string(b), nil
+		},
+		"uuid": func(args []string) (any, error) {
+			if err := checkArgs(args, 0, 0); err != nil {
+				return nil, err
+			}
+			var b [16]byte
+			for i := range b {
+				b[i] = byte(r.UintN(256))
+			}
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+		},
+	}
+}
+
+// checkArgs reports an error unless the number of arguments is within
+// [minArgs, maxArgs]. A negative maxArgs means no upper limit.
+func checkArgs(args []string, minArgs, maxArgs int) error {
+	switch {
+	case minArgs == maxArgs && len(args) != minArgs:
+		return fmt.Errorf("expects %d argument(s), got %d", minArgs, len(args))
+	case len(args) < minArgs:
+		return fmt.Errorf("expects at least %d argument(s), got %d", minArgs, len(args))
+	case maxArgs >= 0 && len(args) > maxArgs:
+		return fmt.Errorf("expects at most %d argument(s), got %d", maxArgs, len(args))
+	}
+	return nil
+}
+
+// intArg parses an integer argument.
+func intArg(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+	return n, nil
+}
+
+// floatArgs parses two numeric arguments.
+func floatArgs(a, b string) (float64, float64, error) {
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid number %q", a)
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid number %q", b)
+	}
+	return x, y, nil
+}
+
+// numberResult returns f as an integer when it has no fractional part. NaN
+// and infinities are errors, as neither UP nor JSON can represent them.
+func numberResult(f float64) (any, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("result %v is not a finite number", f)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f), nil
+	}
+	return f, nil
+}
+
+// addInt adds two integers, reporting whether the sum fits in an int64.
+func addInt(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+// subInt subtracts two integers, reporting whether the difference fits in
+// an int64.
+func subInt(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+// mulInt multiplies two integers, reporting whether the product fits in an
+// int64.
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	return c, c/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+// unaryString adapts a string function to a built-in function.
+func unaryString(fn func(string) string) builtinFunc {
+	return func(args []string) (any, error) {
+		if err := checkArgs(args, 1, 1); err != nil {
+			return nil, err
+		}
+		return fn(args[0]), nil
+	}
+}
+
+// unaryFloat adapts a float function to a built-in function.
+func unaryFloat(fn func(float64) float64) builtinFunc {
+	return func(args []string) (any, error) {
+		if err := checkArgs(args, 1, 1); err != nil {
+			return nil, err
+		}
+		x, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", args[0])
+		}
+		return numberResult(fn(x))
+	}
+}
+
+// foldNumbers builds a function that combines all of its arguments, using
+// integer arithmetic when every argument is an integer. intOp reports
+// whether its result fits in an int64.
+func foldNumbers(intOp func(a, b int64) (int64, bool), floatOp func(a, b float64) float64) builtinFunc {
+	return func(args []string) (any, error) {
+		if err := checkArgs(args, 1, -1); err != nil {
+			return nil, err
+		}
+
+		ints := make([]int64, 0, len(args))
+		for _, arg := range args {
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				break
+			}
+			ints = append(ints, n)
+		}
+		if len(ints) == len(args) {
+			acc := ints[0]
+			for _, n := range ints[1:] {
+				var ok bool
+				if acc, ok = intOp(acc, n); !ok {
+					return nil, fmt.Errorf("integer overflow")
+				}
+			}
+			return acc, nil
+		}
+
+		acc, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", args[0])
+		}
+		for _, arg := range args[1:] {
+			x, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", arg)
+			}
+			acc = floatOp(acc, x)
+		}
+		if math.IsNaN(acc) || math.IsInf(acc, 0) {
+			return nil, fmt.Errorf("result %v is not a finite number", acc)
+		}
+		return acc, nil
+	}
+}
+
+// titleCase upper-cases the first letter of every word.
+func titleCase(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBuiltinNamespaces(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	namespaces := builtinNamespaces(func() time.Time { return now }, rand.New(rand.NewPCG(1, 2)))
+
+	tests := []struct {
+		ns, fn string
+		args   []string
+		want   any
+	}{
+		{"string", "upper", []string{"abc"}, "ABC"},
+		{"string", "title", []string{"hello world"}, "Hello World"},
+		{"string", "length", []string{"héllo"}, int64(5)},
+		{"string", "join", []string{"-", "a", "b"}, "a-b"},
+		{"string", "repeat", []string{"ab", "3"}, "ababab"},
+		{"string", "substr", []string{"héllo", "1", "3"}, "él"},
+		{"math", "add", []string{"1", "2"}, int64(3)},
+		{"math", "add", []string{"1", "2.5"}, 3.5},
+		{"math", "div", []string{"6", "3"}, int64(2)},
+		{"math", "pow", []string{"2", "10"}, int64(1024)},
+		{"math", "add", []string{"9223372036854775806", "1"}, int64(math.MaxInt64)},
+		{"math", "sub", []string{"-9223372036854775807", "1"}, int64(math.MinInt64)},
+		{"math", "mul", []string{"-1", "9223372036854775807"}, int64(-math.MaxInt64)},
+		{"math", "mul", []string{"0", "-9223372036854775808"}, int64(0)},
+		{"time", "now", nil, timestamp("2024-03-01T12:00:00Z")},
+		{"time", "today", nil, timestamp("2024-03-01")},
+		{"time", "unix", nil, now.Unix()},
+		{"time", "add", []string{"1h"}, timestamp("2024-03-01T13:00:00Z")},
+		{"random", "int", []string{"5", "5"}, int64(5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ns+"."+tt.fn, func(t *testing.T) {
+			got, err := namespaces[tt.ns].(builtinNamespace)[tt.fn](tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinNamespaceErrors(t *testing.T) {
+	namespaces := builtinNamespaces(time.Now, rand.New(rand.NewPCG(1, 2)))
+	tooLong := strconv.Itoa(maxStringLength + 1)
+
+	tests := []struct {
+		ns, fn string
+		args   []string
+	}{
+		{"string", "upper", nil},
+		{"string", "repeat", []string{"a", "-1"}},
+		{"string", "repeat", []string{"ab", strconv.Itoa(maxStringLength)}},
+		{"string", "substr", []string{"abc", "2", "1"}},
+		{"math", "div", []string{"1", "0"}},
+		{"math", "add", []string{"1", "x"}},
+		{"math", "add", []string{"9223372036854775807", "1"}},
+		{"math", "sub", []string{"-9223372036854775808", "1"}},
+		{"math", "mul", []string{"4611686018427387904", "2"}},
+		{"math", "mul", []string{"-1", "-9223372036854775808"}},
+		{"math", "mul", []string{"-9223372036854775808", "-1"}},
+		{"math", "add", []string{"1e308", "1e308"}},
+		{"math", "sqrt", []string{"-1"}},
+		{"math", "pow", []string{"10", "400"}},
+		{"math", "max", []string{"inf", "1"}},
+		{"math", "abs", []string{"nan"}},
+		{"time", "add", []string{"soon"}},
+		{"random", "int", []string{"2", "1"}},
+		{"random", "int", []string{"a", "1"}},
+		{"random", "string", []string{"-1"}},
+		{"random", "string", []string{tooLong}},
+		{"random", "choice", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ns+"."+tt.fn, func(t *testing.T) {
+			if got, err := namespaces[tt.ns].(builtinNamespace)[tt.fn](tt.args); err == nil {
+				t.Errorf("%v: expected an error, got %#v", tt.args, got)
+			}
+		})
+	}
+}
+
+func TestRandomIntRange(t *testing.T) {
+	random := randomNamespace(rand.New(rand.NewPCG(1, 2)))
+	tests := []struct {
+		lo, hi int64
+	}{
+		{0, 10},
+		{-5, 5},
+		{math.MinInt64, math.MaxInt64},
+		{math.MinInt64 + 1, math.MaxInt64 - 1},
+		{math.MinInt64, 0},
+		{0, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		args := []string{strconv.FormatInt(tt.lo, 10), strconv.FormatInt(tt.hi, 10)}
+		for range 100 {
+			got, err := random["int"](args)
+			if err != nil {
+				t.Fatalf("%v: unexpected error: %v", args, err)
+			}
+			if n := got.(int64); n < tt.lo || n > tt.hi {
+				t.Fatalf("%v: %d out of range", args, n)
+			}
+		}
+	}
+}
+
+func TestRandomSeeded(t *testing.T) {
+	draw := func() []any {
+		random := randomNamespace(rand.New(rand.NewPCG(42, 42)))
+		var values []any
+		for _, fn := range []string{"int", "string", "uuid"} {
+			args := map[string][]string{"int": {"1", "1000"}, "string": {"8"}}[fn]
+			v, err := random[fn](args)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", fn, err)
+			}
+			values = append(values, v)
+		}
+		return values
+	}
+	first, second := draw(), draw()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("draw %d differs between runs with the same seed: %v != %v", i, first[i], second[i])
+		}
+	}
+}
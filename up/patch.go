@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// jsonPatchOperation is an operation of a JSON Patch (RFC 6902) document.
+type jsonPatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value any
+	// HasValue distinguishes a null value from a missing one.
+	HasValue bool
+}
+
+// handlePatch processes the patch command. A patch holding a JSON array is
+// applied as a JSON Patch (RFC 6902), and one holding an object as a JSON
+// Merge Patch (RFC 7386). The patch works on the JSON form of the document,
+// and only the entries whose JSON value changed are rewritten, so comments,
+// key order and the formatting of everything else are kept.
+func (a *App) handlePatch(c *cli.Context) error {
+	data, err := os.ReadFile(c.String("patch"))
+	if err != nil {
+		return fmt.Errorf("failed to read patch: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	patch, err := decodeJSONValue(dec)
+	if err != nil {
+		return fmt.Errorf("failed to parse patch: %w", err)
+	}
+	var ops []jsonPatchOperation
+	switch p := patch.(type) {
+	case []any:
+		if ops, err = parseJSONPatch(p); err != nil {
+			return fmt.Errorf("invalid patch: %w", err)
+		}
+	case object:
+	default:
+		return fmt.Errorf("invalid patch: expected an array of operations or a merge patch object, got %s", jsonKind(patch))
+	}
+
+	return a.editDocument(c, func(src *sourceDocument) error {
+		doc, err := valueFromSyntax("", "", src.root)
+		if err != nil {
+			return err
+		}
+		result := doc
+		if ops != nil {
+			if result, err = applyJSONPatch(doc, ops); err != nil {
+				return err
+			}
+		} else {
+			result = applyMergePatch(doc, patch)
+		}
+
+		resultObj, ok := result.(object)
+		if !ok {
+			return fmt.Errorf("the patched document must be an object, got %s", jsonKind(result))
+		}
+		if err := src.patchBlock(src.root, "", resultObj); err != nil {
+			return fmt.Errorf("the patched document cannot be written as UP: %w", err)
+		}
+		return nil
+	})
+}
+
+// parseJSONPatch reads the operations of a JSON Patch.
+func parseJSONPatch(items []any) ([]jsonPatchOperation, error) {
+	ops := make([]jsonPatchOperation, len(items))
+	for i, item := range items {
+		obj, ok := item.(object)
+		if !ok {
+			return nil, fmt.Errorf("operation %d: expected an object, got %s", i, jsonKind(item))
+		}
+		op := &ops[i]
+		for _, m := range obj {
+			s, isString := m.Value.(string)
+			switch m.Key {
+			case "op":
+				op.Op = s
+			case "path":
+				op.Path = s
+			case "from":
+				op.From = s
+			case "value":
+				op.Value, op.HasValue = m.Value, true
+				continue
+			default:
+				continue
+			}
+			if !isString {
+				return nil, fmt.Errorf("operation %d: %q must be a string", i, m.Key)
+			}
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if !op.HasValue {
+				return nil, fmt.Errorf("operation %d: %s requires a value", i, op.Op)
+			}
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: invalid from: %w", i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+		if _, err := parsePointer(op.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: invalid path: %w", i, err)
+		}
+	}
+	return ops, nil
+}
+
+// applyJSONPatch applies the operations in order. If one fails, the whole
+// patch fails.
+func applyJSONPatch(doc any, ops []jsonPatchOperation) (any, error) {
+	for i, op := range ops {
+		var err error
+		path, _ := parsePointer(op.Path)
+		from, _ := parsePointer(op.From)
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, path, op.Value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, path); err == nil {
+				doc, err = pointerAdd(doc, path, op.Value)
+			}
+		case "move":
+			if isPrefix(from, path) && len(from) < len(path) {
+				err = fmt.Errorf("cannot move a value into itself")
+				break
+			}
+			var value any
+			if doc, value, err = pointerRemove(doc, from); err == nil {
+				doc, err = pointerAdd(doc, path, value)
+			}
+		case "copy":
+			var value any
+			if value, err = pointerGet(doc, from); err == nil {
+				doc, err = pointerAdd(doc, path, value)
+			}
+		case "test":
+			var value any
+			if value, err = pointerGet(doc, path); err == nil && !jsonEqual(value, op.Value) {
+				err = fmt.Errorf("test failed: value differs")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("%q does not start with /", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// isPrefix reports whether a pointer is a prefix of another.
+func isPrefix(prefix, path []string) bool {
+	return len(prefix) <= len(path) && reflect.DeepEqual(prefix, path[:len(prefix)])
+}
+
+// arrayIndex parses an array index token. "-" stands for the position after
+// the last item, which only adding accepts.
+func arrayIndex(token string, n int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || token != strconv.Itoa(i) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > n || (i == n && !adding) {
+		return 0, fmt.Errorf("index %d out of range for array of %d item(s)", i, n)
+	}
+	return i, nil
+}
+
+// memberIndex returns the position of a key in an object, or -1.
+func memberIndex(obj object, key string) int {
+	for i, m := range obj {
+		if m.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// pointerGet returns the value at a pointer.
+func pointerGet(doc any, path []string) (any, error) {
+	for i, token := range path {
+		switch v := doc.(type) {
+		case object:
+			j := memberIndex(v, token)
+			if j < 0 {
+				return nil, fmt.Errorf("%s not found", "/"+strings.Join(path[:i+1], "/"))
+			}
+			doc = v[j].Value
+		case []any:
+			j, err := arrayIndex(token, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = v[j]
+		default:
+			return nil, fmt.Errorf("%s is %s, not a container", "/"+strings.Join(path[:i], "/"), jsonKind(doc))
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd returns the document with a value added at a pointer: set on an
+// object, inserted into an array, or replacing the whole document. Values
+// are copied rather than modified in place.
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	var updated any
+	switch v := parent.(type) {
+	case object:
+		obj := append(object(nil), v...)
+		if j := memberIndex(obj, token); j >= 0 {
+			obj[j].Value = value
+		} else {
+			obj = append(obj, member{Key: token, Value: value})
+		}
+		updated = obj
+	case []any:
+		j, err := arrayIndex(token, len(v), true)
+		if err != nil {
+			return nil, err
+		}
+		items := append(append(append([]any(nil), v[:j]...), value), v[j:]...)
+		updated = items
+	default:
+		return nil, fmt.Errorf("%s is %s, not a container", "/"+strings.Join(path[:len(path)-1], "/"), jsonKind(parent))
+	}
+	return pointerAdd(doc, path[:len(path)-1], updated)
+}
+
+// pointerRemove returns the document without the value at a pointer, and
+// the value removed.
+func pointerRemove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	token := path[len(path)-1]
+
+	var updated, removed any
+	switch v := parent.(type) {
+	case object:
+		j := memberIndex(v, token)
+		if j < 0 {
+			return nil, nil, fmt.Errorf("%s not found", "/"+strings.Join(path, "/"))
+		}
+		removed = v[j].Value
+		updated = append(append(object(nil), v[:j]...), v[j+1:]...)
+	case []any:
+		j, err := arrayIndex(token, len(v), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = v[j]
+		updated = append(append([]any(nil), v[:j]...), v[j+1:]...)
+	default:
+		return nil, nil, fmt.Errorf("%s is %s, not a container", "/"+strings.Join(path[:len(path)-1], "/"), jsonKind(parent))
+	}
+	doc, err = pointerAdd(doc, path[:len(path)-1], updated)
+	return doc, removed, err
+}
+
+// jsonEqual reports whether two values have the same JSON meaning. Numbers
+// are compared by value and object members in any order.
+func jsonEqual(a, b any) bool {
+	normalize := func(v any) any {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		var out any
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil
+		}
+		return out
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// applyMergePatch applies a JSON Merge Patch: members of the patch replace
+// those of the target recursively, and null members remove them.
+func applyMergePatch(target, patch any) any {
+	p, ok := patch.(object)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(object)
+	if !ok {
+		t = object{}
+	}
+
+	result := append(object(nil), t...)
+	for _, m := range p {
+		j := memberIndex(result, m.Key)
+		if m.Value == nil {
+			if j >= 0 {
+				result = append(result[:j], result[j+1:]...)
+			}
+			continue
+		}
+		if j >= 0 {
+			result[j].Value = applyMergePatch(result[j].Value, m.Value)
+		} else {
+			result = append(result, member{Key: m.Key, Value: applyMergePatch(nil, m.Value)})
+		}
+	}
+	return result
+}
+
+// patchNode rewrites a node to hold the JSON value v, leaving it alone if
+// its value did not change. typ is the annotation of the node, or of the
+// list holding it.
+func (src *sourceDocument) patchNode(node, parent *syntaxNode, path, typ string, v any) error {
+	orig, err := valueFromSyntax(path, typ, node)
+	if err != nil {
+		return err
+	}
+	if jsonEqual(orig, v) {
+		return nil
+	}
+	switch v := v.(type) {
+	case object:
+		if node.Kind == blockSyntax {
+			return src.patchBlock(node, path, v)
+		}
+	case []any:
+		if node.Kind == listSyntax {
+			return src.patchList(node, parent, path, v)
+		}
+	}
+	return src.replaceNode(node, parent, path, v)
+}
+
+// patchBlock rewrites the entries of a block to hold the members of obj.
+// Entries of removed members are deleted and new members are added at the
+// end of the block.
+func (src *sourceDocument) patchBlock(block *syntaxNode, path string, obj object) error {
+	values := make(map[string]any, len(obj))
+	for _, m := range obj {
+		values[m.Key] = m.Value
+	}
+	existing := make(map[string]bool, len(block.Children))
+	for _, child := range block.Children {
+		existing[child.Key] = true
+	}
+
+	// Add new entries first: they go below all existing ones.
+	var added []*syntaxNode
+	for _, m := range obj {
+		if existing[m.Key] {
+			continue
+		}
+		existing[m.Key] = true
+		childPath := keyPath(path, m.Key)
+		if err := checkKey(childPath, m.Key); err != nil {
+			return err
+		}
+		node, err := syntaxFromNeutral(childPath, m.Key, values[m.Key])
+		if err != nil {
+			return err
+		}
+		added = append(added, node)
+	}
+	if len(added) > 0 {
+		src.appendEntries(block, added)
+	}
+
+	// Edit from the bottom up so earlier line numbers stay valid.
+	for i := len(block.Children) - 1; i >= 0; i-- {
+		child := block.Children[i]
+		value, ok := values[child.Key]
+		if !ok {
+			src.removeNode(child)
+			continue
+		}
+		if err := src.patchNode(child, block, keyPath(path, child.Key), child.Type, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchList rewrites the items of a list to hold items. The items both
+// lists start and end with are kept, and the ones in between are rewritten
+// pairwise, with the surplus inserted or removed. A list whose annotation
+// no longer fits its items is replaced as a whole.
+func (src *sourceDocument) patchList(list, parent *syntaxNode, path string, items []any) error {
+	typ, _, err := fromList(path, items)
+	if err != nil {
+		return err
+	}
+	if typ != list.Type && !itemsFit(list.Type, items) {
+		return src.replaceNode(list, parent, path, items)
+	}
+
+	itemPath := func(i int) string { return fmt.Sprintf("%s[%d]", path, i) }
+	orig := make([]any, len(list.Children))
+	for i, child := range list.Children {
+		if orig[i], err = valueFromSyntax(itemPath(i), list.Type, child); err != nil {
+			return err
+		}
+	}
+	n, m := len(orig), len(items)
+	prefix := 0
+	for prefix < n && prefix < m && jsonEqual(orig[prefix], items[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && jsonEqual(orig[n-1-suffix], items[m-1-suffix]) {
+		suffix++
+	}
+	pairs := min(n, m) - prefix - suffix
+
+	// Insert or remove the unpaired items first: they lie below the pairs.
+	if m > n {
+		var added []*syntaxNode
+		for i := prefix + pairs; i < m-suffix; i++ {
+			node, err := syntaxFromNeutralItem(itemPath(i), items[i])
+			if err != nil {
+				return err
+			}
+			added = append(added, node)
+		}
+		src.insertItems(list, n-suffix, added)
+	}
+	for i := n - suffix - 1; i >= prefix+pairs; i-- {
+		src.removeNode(list.Children[i])
+	}
+	for i := prefix + pairs - 1; i >= prefix; i-- {
+		if err := src.patchNode(list.Children[i], list, itemPath(i), list.Type, items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceNode replaces a node with one holding the JSON value v. A scalar
+// keeps its annotation when the new value still fits it; list items take
+// the annotation of their list.
+func (src *sourceDocument) replaceNode(node, parent *syntaxNode, path string, v any) error {
+	var replacement *syntaxNode
+	var err error
+	if parent.Kind == listSyntax {
+		replacement, err = syntaxFromNeutralItem(path, v)
+	} else {
+		replacement, err = syntaxFromNeutral(path, node.Key, v)
+	}
+	if err != nil {
+		return err
+	}
+	if parent.Kind != listSyntax && (node.Kind == scalarSyntax || node.Kind == multilineSyntax) {
+		if typ, value, err := fromValue(path, v); err == nil && annotationFits(node.Type, typ, value) {
+			replacement = syntaxFromValue(node.Key, node.Type, value)
+		}
+	}
+	src.splice(node.Line-1, node.EndLine, render(replacement, src.indentOf(node.Line), src.unitOf(parent)))
+	return nil
+}
+
+// itemsFit reports whether every scalar item of a list, and of the inline
+// lists in it, fits a list annotation.
+func itemsFit(listType string, items []any) bool {
+	for _, item := range items {
+		switch item := item.(type) {
+		case object:
+		case []any:
+			if !itemsFit(listType, item) {
+				return false
+			}
+		default:
+			typ, value, err := fromValue("", item)
+			if err != nil || typ != listType && !annotationFits(listType, typ, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// annotationFits reports whether a value converted with annotation typ can
+// carry the annotation origType instead without changing its JSON form.
+func annotationFits(origType, typ string, v up.Value) bool {
+	s, ok := v.(string)
+	if !ok || origType == "" || isDedent(origType) || jsonKindOf(origType) != jsonKindOf(typ) {
+		return false
+	}
+	return !schemaTypes[origType] || validScalar(origType, s)
+}
+
+// jsonKindOf returns the JSON kind of values carrying an annotation.
+func jsonKindOf(typ string) string {
+	switch typ {
+	case "int", "float":
+		return "number"
+	case "bool", "null":
+		return typ
+	default:
+		return "string"
+	}
+}
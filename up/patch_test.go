@@ -0,0 +1,210 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const patchInput = `# Service config
+name app
+
+server {
+  # listen port
+  port!int 8080
+  timeout!dur 30s
+  ratio!float 1.50
+}
+tags [
+  a
+  # middle
+  b
+]
+`
+
+// runPatch applies a patch to a document and returns the result.
+func runPatch(t *testing.T, input, patch string) (string, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "patch.json")
+	if err := os.WriteFile(filename, []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return runApp(t, input, "patch", "--patch", filename)
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		// edit turns patchInput into the expected output.
+		edit func(string) string
+	}{
+		{
+			name:  "replace keeps annotation and comments",
+			patch: `[{"op": "replace", "path": "/server/port", "value": 9090}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "port!int 8080", "port!int 9090", 1)
+			},
+		},
+		{
+			name:  "fitting value keeps annotation",
+			patch: `[{"op": "replace", "path": "/server/timeout", "value": "45s"}, {"op": "replace", "path": "/server/ratio", "value": 2}]`,
+			edit: func(s string) string {
+				s = strings.Replace(s, "timeout!dur 30s", "timeout!dur 45s", 1)
+				return strings.Replace(s, "ratio!float 1.50", "ratio!float 2", 1)
+			},
+		},
+		{
+			name:  "equal value keeps text",
+			patch: `[{"op": "replace", "path": "/server/ratio", "value": 1.5}]`,
+			edit:  func(s string) string { return s },
+		},
+		{
+			name:  "value of another type",
+			patch: `[{"op": "replace", "path": "/server/port", "value": "auto"}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "port!int 8080", "port auto", 1)
+			},
+		},
+		{
+			name:  "add key at end of block",
+			patch: `[{"op": "add", "path": "/server/host", "value": "localhost"}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "  ratio!float 1.50\n", "  ratio!float 1.50\n  host localhost\n", 1)
+			},
+		},
+		{
+			name:  "add block",
+			patch: `[{"op": "add", "path": "/db", "value": {"port": 5432}}]`,
+			edit: func(s string) string {
+				return s + "db {\n  port!int 5432\n}\n"
+			},
+		},
+		{
+			name:  "remove with comment",
+			patch: `[{"op": "remove", "path": "/name"}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "# Service config\nname app\n", "", 1)
+			},
+		},
+		{
+			name:  "insert list item",
+			patch: `[{"op": "add", "path": "/tags/1", "value": "x"}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "  a\n", "  a\n  x\n", 1)
+			},
+		},
+		{
+			name:  "append list item",
+			patch: `[{"op": "add", "path": "/tags/-", "value": "c"}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "  b\n", "  b\n  c\n", 1)
+			},
+		},
+		{
+			name:  "remove list item",
+			patch: `[{"op": "remove", "path": "/tags/0"}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "  a\n", "", 1)
+			},
+		},
+		{
+			name:  "list annotation changes",
+			patch: `[{"op": "replace", "path": "/tags", "value": [1, 2]}]`,
+			edit: func(s string) string {
+				return strings.Replace(s, "tags [\n  a\n  # middle\n  b\n]\n", "tags!int [\n  1\n  2\n]\n", 1)
+			},
+		},
+		{
+			name:  "move",
+			patch: `[{"op": "move", "from": "/name", "path": "/server/name"}]`,
+			edit: func(s string) string {
+				s = strings.Replace(s, "# Service config\nname app\n", "", 1)
+				return strings.Replace(s, "  ratio!float 1.50\n", "  ratio!float 1.50\n  name app\n", 1)
+			},
+		},
+		{
+			name:  "test passes",
+			patch: `[{"op": "test", "path": "/server/port", "value": 8080}]`,
+			edit:  func(s string) string { return s },
+		},
+		{
+			name:  "merge patch",
+			patch: `{"server": {"port": 80, "ratio": null}, "tags": null}`,
+			edit: func(s string) string {
+				s = strings.Replace(s, "port!int 8080", "port!int 80", 1)
+				s = strings.Replace(s, "  ratio!float 1.50\n", "", 1)
+				return strings.Replace(s, "tags [\n  a\n  # middle\n  b\n]\n", "", 1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runPatch(t, patchInput, tt.patch)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := tt.edit(patchInput); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"not json", `[`, "failed to parse patch"},
+		{"scalar patch", `"x"`, "expected an array of operations or a merge patch object"},
+		{"unknown op", `[{"op": "frob", "path": "/name"}]`, `unknown op "frob"`},
+		{"missing value", `[{"op": "add", "path": "/x"}]`, "add requires a value"},
+		{"invalid pointer", `[{"op": "remove", "path": "name"}]`, "does not start with /"},
+		{"missing path", `[{"op": "remove", "path": "/missing"}]`, "/missing not found"},
+		{"index out of range", `[{"op": "add", "path": "/tags/5", "value": "x"}]`, "out of range"},
+		{"failed test", `[{"op": "test", "path": "/name", "value": "other"}]`, "test failed"},
+		{"move into itself", `[{"op": "move", "from": "/server", "path": "/server/inner"}]`, "cannot move a value into itself"},
+		{"replace document", `[{"op": "add", "path": "", "value": [1]}]`, "must be an object"},
+		{"mixed list", `[{"op": "add", "path": "/mixed", "value": [1, "a"]}]`, "cannot be written as UP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runPatch(t, patchInput, tt.patch)
+			if err == nil {
+				t.Fatalf("expected an error, got:\n%s", out)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+			if out != "" {
+				t.Errorf("a failed patch wrote output:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		ptr  string
+		want []string
+	}{
+		{"", nil},
+		{"/", []string{""}},
+		{"/a/b", []string{"a", "b"}},
+		{"/a~1b/c~0d", []string{"a/b", "c~d"}},
+		{"/list/0", []string{"list", "0"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.ptr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.ptr, err)
+			continue
+		}
+		if strings.Join(got, "\x00") != strings.Join(tt.want, "\x00") || len(got) != len(tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.ptr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	up "github.com/uplang/go"
+)
+
+// projectConfigFile is the path of the project config file, relative to
+// the project root. It is looked up in the current directory and its
+// parents, unless UP_CONFIG names another file.
+var projectConfigFile = filepath.Join(".up", "config.up")
+
+// projectConfig holds the defaults a project config file supplies. Flags
+// and environment variables take precedence over them.
+type projectConfig struct {
+	// root is the directory the paths in the config are relative to.
+	root string
+	// nsPath is the namespace search path for eval.
+	nsPath []string
+	// schemas maps file patterns, relative to root, to schema files.
+	schemas map[string]string
+	// format holds the formatter options.
+	format formatOptions
+	// lint is the lint configuration, or nil if the config has none.
+	lint *lintConfig
+	// toolPath lists directories searched for tools before the directory
+	// of the up binary and PATH.
+	toolPath []string
+}
+
+// project returns the project config, loading it on first use. Without a
+// config file it returns the built-in defaults.
+func (a *App) project() (*projectConfig, error) {
+	if a.config != nil {
+		return a.config, nil
+	}
+
+	filename := os.Getenv("UP_CONFIG")
+	if filename == "" {
+		filename = findProjectConfig()
+	}
+	pc := &projectConfig{format: defaultFormatOptions}
+	if filename != "" {
+		var err error
+		if pc, err = loadProjectConfig(filename); err != nil {
+			return nil, fmt.Errorf("failed to load project config: %w", err)
+		}
+	}
+	a.config = pc
+	return pc, nil
+}
+
+// findProjectConfig returns the path of the project config file of the
+// nearest directory from the current one up that has one, or "".
+func findProjectConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProjectConfig reads a project config file:
+//
+//	eval {
+//	  ns_path [
+//	    tools/namespaces
+//	  ]
+//	}
+//	schemas {
+//	  configs/*.up schemas/app.up-schema
+//	}
+//	format {
+//	  indent!int 4
+//	}
+//	lint {
+//	  rules {
+//	    empty-block off
+//	  }
+//	}
+//	tools {
+//	  path [
+//	    bin
+//	  ]
+//	}
+//
+// Paths are relative to the project root: the directory holding the .up
+// directory, or the directory of a config file elsewhere. The lint block
+// takes the settings of a lint config file.
+func loadProjectConfig(filename string) (*projectConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseSource(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	root := filepath.Dir(filename)
+	if filepath.Base(root) == ".up" {
+		root = filepath.Dir(root)
+	}
+	pc := &projectConfig{root: root, format: defaultFormatOptions}
+	for _, node := range doc.Nodes {
+		settings, ok := node.Value.(up.Block)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a block", filename, node.Key)
+		}
+		switch node.Key {
+		case "eval":
+			err = pc.setEval(settings)
+		case "schemas":
+			err = pc.setSchemas(settings)
+		case "format":
+			err = pc.setFormat(settings)
+		case "lint":
+			pc.lint, err = parseLintConfig(settings)
+		case "tools":
+			err = pc.setTools(settings)
+		default:
+			err = fmt.Errorf("unknown section")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, node.Key, err)
+		}
+	}
+	return pc, nil
+}
+
+// setEval applies the settings of the eval section.
+func (pc *projectConfig) setEval(settings up.Block) error {
+	for _, k := range sortedKeys(settings) {
+		switch key, _ := splitKey(k); key {
+		case "ns_path":
+			dirs, err := schemaStrings(settings[k])
+			if err != nil {
+				return fmt.Errorf("ns_path %w", err)
+			}
+			pc.nsPath = pc.paths(dirs)
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
+// setSchemas applies the schema associations of the schemas section.
+func (pc *projectConfig) setSchemas(settings up.Block) error {
+	pc.schemas = make(map[string]string, len(settings))
+	for _, k := range sortedKeys(settings) {
+		schema, err := schemaString(settings[k])
+		if err != nil {
+			return fmt.Errorf("%s %w", k, err)
+		}
+		pc.schemas[k] = schema
+	}
+	return nil
+}
+
+// setFormat applies the formatter options of the format section.
+func (pc *projectConfig) setFormat(settings up.Block) error {
+	for _, k := range sortedKeys(settings) {
+		key, _ := splitKey(k)
+		var err error
+		switch key {
+		case "indent":
+			var indent *int
+			if indent, err = schemaInt(settings[k]); err == nil {
+				if *indent < 0 {
+					err = fmt.Errorf("must not be negative")
+				}
+				pc.format.Indent = *indent
+			}
+		case "sort_keys":
+			var text string
+			if text, err = schemaString(settings[k]); err == nil {
+				pc.format.SortKeys, err = strconv.ParseBool(text)
+			}
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// setTools applies the settings of the tools section.
+func (pc *projectConfig) setTools(settings up.Block) error {
+	for _, k := range sortedKeys(settings) {
+		switch key, _ := splitKey(k); key {
+		case "path":
+			dirs, err := schemaStrings(settings[k])
+			if err != nil {
+				return fmt.Errorf("path %w", err)
+			}
+			pc.toolPath = pc.paths(dirs)
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
+// paths resolves paths relative to the project root.
+func (pc *projectConfig) paths(paths []string) []string {
+	resolved := make([]string, len(paths))
+	for i, p := range paths {
+		if filepath.IsAbs(p) {
+			resolved[i] = p
+		} else {
+			resolved[i] = filepath.Join(pc.root, p)
+		}
+	}
+	return resolved
+}
+
+// schemaFor returns the schema associated with a file. Patterns follow the
+// .upignore syntax, matched against the path relative to the project root;
+// when several match, the longest pattern wins.
+func (pc *projectConfig) schemaFor(filename string) (string, bool) {
+	if filename == "" || len(pc.schemas) == 0 {
+		return "", false
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false
+	}
+	root, err := filepath.Abs(pc.root)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+
+	best := ""
+	for pattern := range pc.schemas {
+		longer := len(pattern) > len(best) || len(pattern) == len(best) && pattern < best
+		if longer && ignored([]string{pattern}, filepath.ToSlash(rel), false) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	return pc.paths([]string{pc.schemas[best]})[0], true
+}
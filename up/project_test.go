@@ -0,0 +1,140 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadProjectConfig(t *testing.T) {
+	dir, abs := t.TempDir(), t.TempDir()
+	writeTree(t, dir, map[string]string{".up/config.up": `eval {
+  ns_path [
+    tools/ns
+    ` + abs + `
+  ]
+}
+schemas {
+  configs/*.up schemas/app.up-schema
+}
+format {
+  indent!int 4
+  sort_keys!bool true
+}
+lint {
+  rules {
+    empty-block off
+  }
+}
+tools {
+  path [
+    bin
+  ]
+}
+`})
+
+	pc, err := loadProjectConfig(filepath.Join(dir, ".up", "config.up"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pc.root != dir {
+		t.Errorf("got root %s, want %s", pc.root, dir)
+	}
+	if got, want := strings.Join(pc.nsPath, " "), filepath.Join(dir, "tools", "ns")+" "+abs; got != want {
+		t.Errorf("got ns_path %s, want %s", got, want)
+	}
+	if got, want := strings.Join(pc.toolPath, " "), filepath.Join(dir, "bin"); got != want {
+		t.Errorf("got tools path %s, want %s", got, want)
+	}
+	if pc.format != (formatOptions{Indent: 4, SortKeys: true}) {
+		t.Errorf("got format options %+v", pc.format)
+	}
+	if pc.lint == nil || pc.lint.severities["empty-block"] != "off" {
+		t.Errorf("lint settings not applied: %+v", pc.lint)
+	}
+	if pc.schemas["configs/*.up"] != "schemas/app.up-schema" {
+		t.Errorf("got schemas %v", pc.schemas)
+	}
+}
+
+func TestLoadProjectConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"section not a block", "format 4\n", "format must be a block"},
+		{"unknown section", "colors {\n  on true\n}\n", "colors: unknown section"},
+		{"unknown eval setting", "eval {\n  path x\n}\n", `eval: unknown setting "path"`},
+		{"negative indent", "format {\n  indent!int -1\n}\n", "format: indent: must not be negative"},
+		{"invalid sort_keys", "format {\n  sort_keys maybe\n}\n", "format: sort_keys"},
+		{"invalid lint", "lint {\n  rules {\n    no-such-rule off\n  }\n}\n", "lint:"},
+		{"syntax error", "format {\n", "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadProjectConfig(writeTestFile(t, "config.up", tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	root := t.TempDir()
+	prod := filepath.Join(t.TempDir(), "prod.up-schema")
+	pc := &projectConfig{root: root, schemas: map[string]string{
+		"*.up":              "any.up-schema",
+		"configs/*.up":      "configs.up-schema",
+		"configs/prod/*.up": prod,
+	}}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"top.up", filepath.Join(root, "any.up-schema")},
+		{"configs/app.up", filepath.Join(root, "configs.up-schema")},
+		{"configs/prod/app.up", prod},
+		{"configs/app.json", ""},
+		{"../outside.up", ""},
+	}
+	for _, tt := range tests {
+		got, ok := pc.schemaFor(filepath.Join(root, filepath.FromSlash(tt.file)))
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("schemaFor(%s) = %q, %v; want %q", tt.file, got, ok, tt.want)
+		}
+	}
+}
+
+func TestProjectConfigDefaults(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"config.up":            "format {\n  indent!int 4\n}\nschemas {\n  *.up app.up-schema\n}\n",
+		"app.up-schema":        "port {\n  type int\n}\n",
+		"bad.up":               "port!int x\n",
+		"broken/.up/config.up": "format 4\n",
+	})
+	t.Setenv("UP_CONFIG", filepath.Join(dir, "config.up"))
+
+	got, err := runApp(t, "a {\n b 1\n}\n", "format")
+	if err != nil {
+		t.Fatalf("format: %v", err)
+	}
+	if want := "a {\n    b 1\n}\n"; got != want {
+		t.Errorf("config indent not used: got %q, want %q", got, want)
+	}
+	if got, _ := runApp(t, "a {\n b 1\n}\n", "format", "--indent", "1"); got != "a {\n b 1\n}\n" {
+		t.Errorf("--indent does not override the config: got %q", got)
+	}
+
+	_, err = runApp(t, "", "validate", "-i", filepath.Join(dir, "bad.up"))
+	if err == nil || !strings.Contains(err.Error(), "port") {
+		t.Errorf("schema from the config not applied: %v", err)
+	}
+
+	t.Setenv("UP_CONFIG", filepath.Join(dir, "broken", ".up", "config.up"))
+	if _, err := runApp(t, "a 1\n", "format"); err == nil || !strings.Contains(err.Error(), "failed to load project config") {
+		t.Errorf("got error %v, want a project config error", err)
+	}
+}
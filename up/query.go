@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// queryMatch is a value selected by a query, with its key path and type
+// annotation. Scalar list items carry the annotation of their list. The
+// path is itself a query expression that selects the match.
+type queryMatch struct {
+	Path string
+	Type string
+	Node *syntaxNode
+}
+
+// queryStep maps the current matches to the next ones.
+type queryStep func([]queryMatch) []queryMatch
+
+// query is a compiled path expression.
+type query []queryStep
+
+// handleQuery processes the query command.
+func (a *App) handleQuery(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected exactly one query expression")
+	}
+	if c.Bool("json") && c.Bool("raw") {
+		return fmt.Errorf("--json and --raw cannot be combined")
+	}
+	q, err := compileQuery(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	root, err := a.readSyntax(c.String("input"))
+	if err != nil {
+		return err
+	}
+
+	matches := q.run(queryMatch{Node: root})
+	if len(matches) == 0 && c.Bool("exit-status") {
+		return fmt.Errorf("no matches")
+	}
+
+	var buf bytes.Buffer
+	switch {
+	case c.Bool("json"):
+		err = writeMatchesJSON(&buf, matches, c.Bool("pretty"))
+	case c.Bool("raw"):
+		err = writeMatchesRaw(&buf, matches)
+	default:
+		err = writeMatchesUP(&buf, matches)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(a.output)
+	return err
+}
+
+// run evaluates the query against a root value.
+func (q query) run(root queryMatch) []queryMatch {
+	matches := []queryMatch{root}
+	for _, step := range q {
+		matches = step(matches)
+	}
+	return matches
+}
+
+// writeMatchesUP writes each match as an entry keyed by its path, so type
+// annotations are kept. A path starting with a quoted key gets a leading
+// dot to make it a valid key. A match of the whole document is written as
+// the document itself.
+func writeMatchesUP(w io.Writer, matches []queryMatch) error {
+	root := &syntaxNode{Kind: blockSyntax}
+	for _, m := range matches {
+		if m.Path == "" {
+			root.Children = append(root.Children, m.Node.Children...)
+			root.Trailer = append(root.Trailer, m.Node.Trailer...)
+			continue
+		}
+		key := m.Path
+		if strings.HasPrefix(key, "[") {
+			key = "." + key
+		}
+		if err := checkKey(m.Path, key); err != nil {
+			return err
+		}
+		node := *m.Node
+		node.Key, node.Type, node.Comments = key, m.Type, nil
+		if node.Kind == inlineListSyntax {
+			node.Kind, node.Children = listSyntax, inlineItems(m.Node)
+		}
+		root.Children = append(root.Children, &node)
+	}
+	return formatSyntax(w, root, defaultFormatOptions)
+}
+
+// writeMatchesJSON writes each match as a JSON value on its own line, typed
+// according to its annotation.
+func writeMatchesJSON(w io.Writer, matches []queryMatch, pretty bool) error {
+	for _, m := range matches {
+		value, err := valueFromSyntax(m.Path, m.Type, m.Node)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if pretty {
+			data, err = json.MarshalIndent(value, "", "  ")
+		} else {
+			data, err = json.Marshal(value)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMatchesRaw writes scalar matches as plain text, one per line, for use
+// in shell scripts. Blocks and lists are written as compact JSON.
+func writeMatchesRaw(w io.Writer, matches []queryMatch) error {
+	for _, m := range matches {
+		if s, ok := scalarText(m); ok {
+			if _, err := fmt.Fprintln(w, s); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeMatchesJSON(w, []queryMatch{m}, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalarText returns the text of a scalar match, with the indentation of a
+// dedented multiline string removed.
+func scalarText(m queryMatch) (string, bool) {
+	switch m.Node.Kind {
+	case scalarSyntax:
+		return m.Node.Text, true
+	case multilineSyntax:
+		text := strings.Join(m.Node.Lines, "\n")
+		if n, ok := dedentWidth(m.Type); ok {
+			text = dedent(text, n)
+		}
+		return text, true
+	default:
+		return "", false
+	}
+}
+
+// compileQuery parses a path expression:
+//
+//	server.port          key lookup; a leading dot is optional
+//	servers[0]           list index, negative from the end
+//	["odd key"]          quoted key
+//	server.*  items[*]   every value of a block or item of a list
+//	..port               recursive descent: port at any depth
+//	items[?@.port > 80]  filter children by a condition
+//
+// Filter conditions start with @, the child being tested, optionally followed
+// by a relative path and a !type test, and an operator (==, !=, <, <=, >, >=
+// or =~ for a regular expression) with a literal. Without an operator the
+// condition tests that the path exists. For example servers[?@.port!int]
+// keeps the servers whose port is annotated !int.
+func compileQuery(expr string) (query, error) {
+	qp := &queryParser{expr: strings.TrimSpace(expr)}
+	q, err := qp.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %w", expr, err)
+	}
+	return q, nil
+}
+
+// queryParser holds the state of compileQuery.
+type queryParser struct {
+	expr string
+	pos  int
+}
+
+// parse parses the whole expression.
+func (qp *queryParser) parse() (query, error) {
+	var q query
+	if qp.expr == "." {
+		return q, nil
+	}
+	if qp.pos < len(qp.expr) && qp.expr[qp.pos] != '.' && qp.expr[qp.pos] != '[' {
+		name := qp.name()
+		if name == "" {
+			return nil, fmt.Errorf("unexpected %q at offset %d", qp.expr[qp.pos], qp.pos)
+		}
+		q = append(q, childStep(name))
+	}
+
+	for qp.pos < len(qp.expr) {
+		switch {
+		case strings.HasPrefix(qp.expr[qp.pos:], ".."):
+			qp.pos += 2
+			q = append(q, descendantStep)
+			if qp.pos < len(qp.expr) && qp.expr[qp.pos] == '[' {
+				continue
+			}
+			step, err := qp.member()
+			if err != nil {
+				return nil, err
+			}
+			q = append(q, step)
+		case qp.expr[qp.pos] == '.':
+			qp.pos++
+			step, err := qp.member()
+			if err != nil {
+				return nil, err
+			}
+			q = append(q, step)
+		case qp.expr[qp.pos] == '[':
+			step, err := qp.bracket()
+			if err != nil {
+				return nil, err
+			}
+			q = append(q, step)
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", qp.expr[qp.pos], qp.pos)
+		}
+	}
+	return q, nil
+}
+
+// member parses what follows a dot: a name, a quoted key or *.
+func (qp *queryParser) member() (queryStep, error) {
+	switch {
+	case qp.pos >= len(qp.expr):
+		return nil, fmt.Errorf("missing key at end of expression")
+	case qp.expr[qp.pos] == '*':
+		qp.pos++
+		return wildcardStep, nil
+	case qp.expr[qp.pos] == '"':
+		key, err := qp.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return childStep(key), nil
+	}
+	name := qp.name()
+	if name == "" {
+		return nil, fmt.Errorf("missing key at offset %d", qp.pos)
+	}
+	return childStep(name), nil
+}
+
+// name parses an unquoted key.
+func (qp *queryParser) name() string {
+	start := qp.pos
+	for qp.pos < len(qp.expr) && !strings.ContainsRune(".[]*\" \t", rune(qp.expr[qp.pos])) {
+		qp.pos++
+	}
+	return qp.expr[start:qp.pos]
+}
+
+// quoted parses a double-quoted string.
+func (qp *queryParser) quoted() (string, error) {
+	end := qp.pos + 1
+	for end < len(qp.expr) && qp.expr[end] != '"' {
+		if qp.expr[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(qp.expr) {
+		return "", fmt.Errorf("unterminated string at offset %d", qp.pos)
+	}
+	s, err := strconv.Unquote(qp.expr[qp.pos : end+1])
+	if err != nil {
+		return "", fmt.Errorf("invalid string at offset %d: %w", qp.pos, err)
+	}
+	qp.pos = end + 1
+	return s, nil
+}
+
+// bracket parses a [...] segment: an index, *, a quoted key or a filter.
+func (qp *queryParser) bracket() (queryStep, error) {
+	start := qp.pos
+	qp.pos++
+	var step queryStep
+	switch {
+	case qp.pos < len(qp.expr) && qp.expr[qp.pos] == '*':
+		qp.pos++
+		step = wildcardStep
+	case qp.pos < len(qp.expr) && qp.expr[qp.pos] == '"':
+		key, err := qp.quoted()
+		if err != nil {
+			return nil, err
+		}
+		step = childStep(key)
+	case qp.pos < len(qp.expr) && qp.expr[qp.pos] == '?':
+		end := closingBracket(qp.expr, qp.pos)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated filter at offset %d", start)
+		}
+		cond, err := compileCondition(strings.TrimSpace(qp.expr[qp.pos+1 : end]))
+		if err != nil {
+			return nil, err
+		}
+		qp.pos = end
+		step = filterStep(cond)
+	default:
+		end := strings.IndexByte(qp.expr[qp.pos:], ']')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated index at offset %d", start)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(qp.expr[qp.pos : qp.pos+end]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid index at offset %d", start)
+		}
+		qp.pos += end
+		step = indexStep(index)
+	}
+
+	if qp.pos >= len(qp.expr) || qp.expr[qp.pos] != ']' {
+		return nil, fmt.Errorf("missing ] for [ at offset %d", start)
+	}
+	qp.pos++
+	return step, nil
+}
+
+// closingBracket returns the index of the ] closing a bracket whose content
+// starts at from, skipping nested brackets and quoted strings, or -1.
+func closingBracket(s string, from int) int {
+	depth, quoted := 0, false
+	for i := from; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			depth++
+		case c == ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// children returns the values directly inside a match: the entries of a
+// block or the items of a list, in document order.
+func children(m queryMatch) []queryMatch {
+	switch m.Node.Kind {
+	case blockSyntax:
+		matches := make([]queryMatch, len(m.Node.Children))
+		for i, child := range m.Node.Children {
+			matches[i] = queryMatch{Path: matchPath(m.Path, child.Key), Type: child.Type, Node: child}
+		}
+		return matches
+	case listSyntax, inlineListSyntax:
+		items := listNodes(m.Node)
+		matches := make([]queryMatch, len(items))
+		for i, item := range items {
+			matches[i] = itemMatch(m, i, item)
+		}
+		return matches
+	default:
+		return nil
+	}
+}
+
+// matchPath appends a key to the path of a match, quoting keys that the
+// query syntax would not read back as a single name.
+func matchPath(path, key string) string {
+	if key == "" || strings.ContainsAny(key, ".[]*\" \t") {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	return keyPath(path, key)
+}
+
+// listNodes returns the items of a list or inline list node, or nil for
+// any other node.
+func listNodes(node *syntaxNode) []*syntaxNode {
+	switch node.Kind {
+	case listSyntax:
+		return node.Children
+	case inlineListSyntax:
+		return inlineItems(node)
+	default:
+		return nil
+	}
+}
+
+// inlineItems returns the items of an inline list as scalar nodes.
+func inlineItems(node *syntaxNode) []*syntaxNode {
+	items := make([]*syntaxNode, len(node.Items))
+	for i, item := range node.Items {
+		items[i] = &syntaxNode{Kind: scalarSyntax, Text: item, Line: node.Line, EndLine: node.EndLine}
+	}
+	return items
+}
+
+// itemMatch builds the match for item i of a list match. Scalar and inline
+// list items take the list's annotation.
+func itemMatch(m queryMatch, i int, item *syntaxNode) queryMatch {
+	typ := m.Type
+	if item.Kind == blockSyntax {
+		typ = ""
+	}
+	return queryMatch{Path: fmt.Sprintf("%s[%d]", m.Path, i), Type: typ, Node: item}
+}
+
+// childStep selects the entry with the given key from each block.
+func childStep(key string) queryStep {
+	return func(matches []queryMatch) []queryMatch {
+		var next []queryMatch
+		for _, m := range matches {
+			if m.Node.Kind != blockSyntax {
+				continue
+			}
+			for _, child := range m.Node.Children {
+				if child.Key == key {
+					next = append(next, queryMatch{Path: matchPath(m.Path, key), Type: child.Type, Node: child})
+					break
+				}
+			}
+		}
+		return next
+	}
+}
+
+// indexStep selects an item from each list. Negative indexes count from the
+// end.
+func indexStep(index int) queryStep {
+	return func(matches []queryMatch) []queryMatch {
+		var next []queryMatch
+		for _, m := range matches {
+			list := listNodes(m.Node)
+			i := index
+			if i < 0 {
+				i += len(list)
+			}
+			if i >= 0 && i < len(list) {
+				next = append(next, itemMatch(m, i, list[i]))
+			}
+		}
+		return next
+	}
+}
+
+// wildcardStep selects every value of each block and item of each list.
+func wildcardStep(matches []queryMatch) []queryMatch {
+	var next []queryMatch
+	for _, m := range matches {
+		next = append(next, children(m)...)
+	}
+	return next
+}
+
+// descendantStep selects each match and everything below it, depth first.
+func descendantStep(matches []queryMatch) []queryMatch {
+	var next []queryMatch
+	var walk func(m queryMatch)
+	walk = func(m queryMatch) {
+		next = append(next, m)
+		for _, child := range children(m) {
+			walk(child)
+		}
+	}
+	for _, m := range matches {
+		walk(m)
+	}
+	return next
+}
+
+// filterStep selects the children of each match that satisfy a condition.
+func filterStep(cond func(queryMatch) bool) queryStep {
+	return func(matches []queryMatch) []queryMatch {
+		var next []queryMatch
+		for _, m := range matches {
+			for _, child := range children(m) {
+				if cond(child) {
+					next = append(next, child)
+				}
+			}
+		}
+		return next
+	}
+}
+
+// conditionOperators lists the filter operators, longest first so that <=
+// is not read as <.
+var conditionOperators = []string{"==", "!=", "<=", ">=", "=~", "<", ">"}
+
+// compileCondition parses a filter condition such as @.port!int > 1024.
+func compileCondition(cond string) (func(queryMatch) bool, error) {
+	if !strings.HasPrefix(cond, "@") {
+		return nil, fmt.Errorf("filter %q must start with @", cond)
+	}
+
+	op, literal := "", ""
+	operand := cond
+	quoted := false
+	for i := 1; i < len(cond); i++ {
+		switch {
+		case quoted && cond[i] == '\\':
+			i++
+			continue
+		case cond[i] == '"':
+			quoted = !quoted
+			continue
+		case quoted:
+			continue
+		}
+		for _, candidate := range conditionOperators {
+			if strings.HasPrefix(cond[i:], candidate) {
+				op, operand = candidate, strings.TrimSpace(cond[:i])
+				literal = strings.TrimSpace(cond[i+len(candidate):])
+				break
+			}
+		}
+		if op != "" {
+			break
+		}
+	}
+
+	if strings.HasPrefix(literal, `"`) {
+		s, err := strconv.Unquote(literal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s in filter", literal)
+		}
+		literal = s
+	}
+	var pattern *regexp.Regexp
+	if op == "=~" {
+		var err error
+		if pattern, err = regexp.Compile(literal); err != nil {
+			return nil, fmt.Errorf("invalid pattern in filter: %w", err)
+		}
+	}
+
+	operand = strings.TrimPrefix(operand, "@")
+	typ := ""
+	if idx := strings.LastIndex(operand, "!"); idx >= 0 && !strings.ContainsAny(operand[idx:], ".[]") {
+		operand, typ = operand[:idx], operand[idx+1:]
+	}
+	var rel query
+	if operand != "" {
+		if !strings.HasPrefix(operand, ".") && !strings.HasPrefix(operand, "[") {
+			return nil, fmt.Errorf("invalid filter path %q", operand)
+		}
+		var err error
+		if rel, err = (&queryParser{expr: operand}).parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(m queryMatch) bool {
+		for _, target := range rel.run(m) {
+			if typ != "" && target.Type != typ {
+				continue
+			}
+			if op == "" || compareMatch(target, op, literal, pattern) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// compareMatch applies a filter operator to a scalar match. Values that both
+// parse as numbers are compared numerically, others as strings.
+func compareMatch(m queryMatch, op, literal string, pattern *regexp.Regexp) bool {
+	s, ok := scalarText(m)
+	if !ok {
+		return false
+	}
+	if op == "=~" {
+		return pattern.MatchString(s)
+	}
+
+	cmp := strings.Compare(s, literal)
+	x, errX := strconv.ParseFloat(s, 64)
+	y, errY := strconv.ParseFloat(literal, 64)
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const queryTestDocument = `name app
+server {
+  port!int 8080
+  host localhost
+}
+servers [
+  {
+    name a
+    port!int 80
+  }
+  {
+    name b
+    port!int 443
+  }
+  {
+    name c
+  }
+]
+tags [
+  x
+  y
+]
+`
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"key", []string{"name"}, "name app\n"},
+		{"nested key keeps annotation", []string{"server.port"}, "server.port!int 8080\n"},
+		{"leading dot", []string{".server.host"}, "server.host localhost\n"},
+		{"block", []string{"server"}, "server {\n  port!int 8080\n  host localhost\n}\n"},
+		{"index", []string{"servers[1].name"}, "servers[1].name b\n"},
+		{"negative index", []string{"servers[-1]"}, "servers[2] {\n  name c\n}\n"},
+		{"index out of range", []string{"servers[3]"}, ""},
+		{"wildcard over list", []string{"servers[*].name"}, "servers[0].name a\nservers[1].name b\nservers[2].name c\n"},
+		{"wildcard over block", []string{"server.*"}, "server.port!int 8080\nserver.host localhost\n"},
+		{"recursive descent", []string{"..port"}, "server.port!int 8080\nservers[0].port!int 80\nservers[1].port!int 443\n"},
+		{"quoted key", []string{`["name"]`}, "name app\n"},
+		{"filter comparison", []string{"servers[?@.port > 100].name"}, "servers[1].name b\n"},
+		{"filter existence", []string{"servers[?@.port].name"}, "servers[0].name a\nservers[1].name b\n"},
+		{"filter type", []string{"servers[?@.port!int].name"}, "servers[0].name a\nservers[1].name b\n"},
+		{"filter regexp", []string{`servers[?@.name =~ "^[bc]$"].name`}, "servers[1].name b\nservers[2].name c\n"},
+		{"filter on scalars", []string{`tags[?@ == "y"]`}, "tags[1] y\n"},
+		{"missing key", []string{"nothing.here"}, ""},
+		{"json", []string{"--json", "servers[0]"}, "{\"name\":\"a\",\"port\":80}\n"},
+		{"pretty json", []string{"--json", "--pretty", "tags"}, "[\n  \"x\",\n  \"y\"\n]\n"},
+		{"raw", []string{"-r", "..port"}, "8080\n80\n443\n"},
+		{"raw block", []string{"-r", "servers[2]"}, "{\"name\":\"c\"}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, queryTestDocument, append([]string{"query"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPaths(t *testing.T) {
+	const document = "a.b 1\nx {\n  c.d {\n    e!int 2\n  }\n}\nl [\n  [p, q]\n]\n# trailing\n"
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`["a.b"]`, ".[\"a.b\"] 1\n"},
+		{"..e", "x[\"c.d\"].e!int 2\n"},
+		{`x["c.d"].e`, "x[\"c.d\"].e!int 2\n"},
+		{"l[0]", "l[0] [\n  p\n  q\n]\n"},
+		{"l[0][1]", "l[0][1] q\n"},
+		{".", document},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := runApp(t, document, "query", tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if _, err := runApp(t, got, "format", "--check"); err != nil {
+				t.Errorf("output is not valid UP: %v", err)
+			}
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no expression", nil, "expected exactly one query expression"},
+		{"json and raw", []string{"--json", "--raw", "name"}, "--json and --raw cannot be combined"},
+		{"exit status", []string{"--exit-status", "nothing"}, "no matches"},
+		{"trailing dot", []string{"a."}, `invalid query "a.": missing key at end of expression`},
+		{"unterminated index", []string{"a[0"}, `invalid query "a[0": unterminated index at offset 1`},
+		{"invalid index", []string{"a[x]"}, `invalid query "a[x]": invalid index at offset 1`},
+		{"unterminated string", []string{`a["x`}, "unterminated string at offset 2"},
+		{"unterminated filter", []string{"a[?@.x"}, "unterminated filter at offset 1"},
+		{"unexpected character", []string{"a b"}, `unexpected ' ' at offset 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, queryTestDocument, append([]string{"query"}, tt.args...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// sarifSchema is the JSON schema of SARIF 2.1.0 logs.
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// sarifLog is the top-level object of a SARIF 2.1.0 log, limited to the
+// properties up reports.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// sarifRun is a SARIF run object: one invocation of the tool.
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+// sarifTool is a SARIF tool object.
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+// sarifDriver is the SARIF toolComponent object of the tool's driver.
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+// sarifRule is a SARIF reportingDescriptor object: a rule.
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+// sarifConfiguration is a SARIF reportingConfiguration object.
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+// sarifMessage is a SARIF message object.
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+// sarifResult is a SARIF result object: one diagnostic.
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	RuleIndex *int            `json:"ruleIndex,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+// sarifLocation is a SARIF location object.
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+// sarifPhysicalLocation is a SARIF physicalLocation object.
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+// sarifArtifactLocation is a SARIF artifactLocation object.
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifRegion is a SARIF region object.
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// writeSARIFFile writes diagnostics as a SARIF log to a file, or to the
+// output when filename is empty.
+func (a *App) writeSARIFFile(filename string, diags []diagnostic) error {
+	output, err := a.getOutput(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create output: %w", err)
+	}
+	if err := writeSARIF(output, diags); err != nil {
+		output.Abort()
+		return fmt.Errorf("failed to write SARIF: %w", err)
+	}
+	return output.Close()
+}
+
+// writeSARIF writes diagnostics as a SARIF 2.1.0 log with a single run.
+// Every diagnostic code is listed as a rule of the tool, and each
+// diagnostic becomes a result pointing at its file and range. Columns
+// count characters and end columns are exclusive, as in diagnostics.
+func writeSARIF(w io.Writer, diags []diagnostic) error {
+	driver := sarifDriver{
+		Name:           "up",
+		Version:        version,
+		InformationURI: "https://github.com/uplang/tools",
+		Rules:          make([]sarifRule, len(diagnosticRules)),
+	}
+	ruleIndex := make(map[string]int, len(diagnosticRules))
+	for i, rule := range diagnosticRules {
+		driver.Rules[i] = sarifRule{
+			ID:                   rule.Code,
+			Name:                 rule.Name,
+			ShortDescription:     sarifMessage{rule.Description},
+			DefaultConfiguration: sarifConfiguration{rule.Severity},
+		}
+		ruleIndex[rule.Code] = i
+	}
+
+	results := make([]sarifResult, len(diags))
+	for i, d := range diags {
+		result := sarifResult{
+			RuleID:  d.Code,
+			Level:   d.Severity,
+			Message: sarifMessage{d.Message},
+		}
+		if index, ok := ruleIndex[d.Code]; ok {
+			result.RuleIndex = &index
+		}
+		if d.File != "" && d.File != "<stdin>" {
+			location := sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{filepath.ToSlash(d.File)},
+			}
+			if d.Line > 0 {
+				location.Region = &sarifRegion{
+					StartLine:   d.Line,
+					StartColumn: d.Column,
+					EndLine:     d.EndLine,
+					EndColumn:   d.EndColumn,
+				}
+			}
+			result.Locations = []sarifLocation{{location}}
+		}
+		results[i] = result
+	}
+
+	return writeIndentedJSON(w, sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{driver}, Results: results}},
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	tests := []struct {
+		name string
+		diag diagnostic
+		// wantIndex is the expected ruleIndex, or -1 for none.
+		wantIndex int
+		want      sarifResult
+	}{
+		{
+			name:      "located",
+			diag:      diagnostic{File: filepath.Join("configs", "app.up"), Line: 2, Column: 1, EndLine: 2, EndColumn: 5, Severity: severityError, Code: codeDuplicateKey, Message: "duplicate key"},
+			wantIndex: ruleIndexOf(codeDuplicateKey),
+			want: sarifResult{
+				RuleID:  codeDuplicateKey,
+				Level:   severityError,
+				Message: sarifMessage{"duplicate key"},
+				Locations: []sarifLocation{{sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{"configs/app.up"},
+					Region:           &sarifRegion{StartLine: 2, StartColumn: 1, EndLine: 2, EndColumn: 5},
+				}}},
+			},
+		},
+		{
+			name:      "whole file",
+			diag:      diagnostic{File: "app.up", Severity: severityWarning, Code: codeEmptyBlock, Message: "empty block"},
+			wantIndex: ruleIndexOf(codeEmptyBlock),
+			want: sarifResult{
+				RuleID:    codeEmptyBlock,
+				Level:     severityWarning,
+				Message:   sarifMessage{"empty block"},
+				Locations: []sarifLocation{{sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{"app.up"}}}},
+			},
+		},
+		{
+			name:      "stdin without code",
+			diag:      diagnostic{File: "<stdin>", Line: 1, Severity: severityError, Message: "failed"},
+			wantIndex: -1,
+			want:      sarifResult{Level: severityError, Message: sarifMessage{"failed"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeSARIF(&buf, []diagnostic{tt.diag}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var log sarifLog
+			if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+				t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+			}
+			if log.Version != "2.1.0" || log.Schema != sarifSchema || len(log.Runs) != 1 {
+				t.Fatalf("unexpected log header: %+v", log)
+			}
+			run := log.Runs[0]
+			if len(run.Tool.Driver.Rules) != len(diagnosticRules) {
+				t.Errorf("got %d rules, want %d", len(run.Tool.Driver.Rules), len(diagnosticRules))
+			}
+			if len(run.Results) != 1 {
+				t.Fatalf("got %d results, want 1", len(run.Results))
+			}
+
+			got := run.Results[0]
+			switch {
+			case tt.wantIndex < 0 && got.RuleIndex != nil:
+				t.Errorf("got ruleIndex %d, want none", *got.RuleIndex)
+			case tt.wantIndex >= 0 && (got.RuleIndex == nil || *got.RuleIndex != tt.wantIndex):
+				t.Errorf("got ruleIndex %v, want %d", got.RuleIndex, tt.wantIndex)
+			case tt.wantIndex >= 0 && run.Tool.Driver.Rules[tt.wantIndex].ID != tt.diag.Code:
+				t.Errorf("rule %d is %s, want %s", tt.wantIndex, run.Tool.Driver.Rules[tt.wantIndex].ID, tt.diag.Code)
+			}
+			got.RuleIndex = nil
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("got result %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// ruleIndexOf returns the position of a diagnostic code among the rules.
+func ruleIndexOf(code string) int {
+	for i, rule := range diagnosticRules {
+		if rule.Code == code {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	up "github.com/uplang/go"
+)
+
+// schemaTypes lists the types a schema can require. The scalar types match
+// the UP type annotations of the same name.
+var schemaTypes = map[string]bool{
+	"string": true, "int": true, "float": true, "bool": true,
+	"dur": true, "ts": true, "uuid": true,
+	"block": true, "list": true, "any": true,
+}
+
+// uuidPattern matches the textual form of a UUID.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// schema describes the expected shape of a value. An up-schema file is a UP
+// document whose top-level keys describe the top-level keys of the documents
+// it validates:
+//
+//	server {
+//	  type block
+//	  required!bool true
+//	  fields {
+//	    port {
+//	      type int
+//	      min!int 1
+//	      max!int 65535
+//	    }
+//	  }
+//	}
+type schema struct {
+	Type      string
+	Required  bool
+	Enum      []string
+	Min       string
+	Max       string
+	MinLength *int
+	MaxLength *int
+	Pattern   *regexp.Regexp
+	Fields    map[string]*schema
+	Items     *schema
+}
+
+// violation is a single schema violation.
+type violation struct {
+	Path string
+	// Code is the diagnostic code of the kind of violation.
+	Code    string
+	Message string
+}
+
+// String formats the violation as "path: message".
+func (v violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// loadSchema reads an up-schema file.
+func loadSchema(filename string) (*schema, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseSource(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", filename, err)
+	}
+
+	fields := make(up.Block, len(doc.Nodes))
+	for _, node := range doc.Nodes {
+		fields[joinKey(node.Key, node.Type)] = node.Value
+	}
+	root, err := parseSchemaFields("", fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", filename, err)
+	}
+	return &schema{Type: "block", Fields: root}, nil
+}
+
+// parseSchemaFields parses the field specs of a block schema.
+func parseSchemaFields(path string, block up.Block) (map[string]*schema, error) {
+	fields := make(map[string]*schema, len(block))
+	for _, k := range sortedKeys(block) {
+		v := block[k]
+		key, _ := splitKey(k)
+		spec, ok := v.(up.Block)
+		if !ok {
+			return nil, fmt.Errorf("%s: field spec must be a block", keyPath(path, key))
+		}
+		s, err := parseSchema(keyPath(path, key), spec)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = s
+	}
+	return fields, nil
+}
+
+// parseSchema parses a single field spec.
+func parseSchema(path string, spec up.Block) (*schema, error) {
+	s := &schema{Type: "any"}
+	for _, k := range sortedKeys(spec) {
+		v := spec[k]
+		key, _ := splitKey(k)
+		var err error
+		switch key {
+		case "type":
+			s.Type, err = schemaString(v)
+			if err == nil && !schemaTypes[s.Type] {
+				err = fmt.Errorf("unknown type %q", s.Type)
+			}
+		case "required":
+			var text string
+			if text, err = schemaString(v); err == nil {
+				s.Required, err = strconv.ParseBool(text)
+			}
+		case "enum":
+			s.Enum, err = schemaStrings(v)
+		case "min":
+			s.Min, err = schemaString(v)
+		case "max":
+			s.Max, err = schemaString(v)
+		case "min_length":
+			s.MinLength, err = schemaInt(v)
+		case "max_length":
+			s.MaxLength, err = schemaInt(v)
+		case "pattern":
+			var text string
+			if text, err = schemaString(v); err == nil {
+				s.Pattern, err = regexp.Compile(text)
+			}
+		case "fields":
+			block, ok := v.(up.Block)
+			if !ok {
+				err = fmt.Errorf("must be a block")
+				break
+			}
+			s.Fields, err = parseSchemaFields(path, block)
+		case "items":
+			block, ok := v.(up.Block)
+			if !ok {
+				err = fmt.Errorf("must be a block")
+				break
+			}
+			s.Items, err = parseSchema(path+"[]", block)
+		case "description":
+		default:
+			err = fmt.Errorf("unknown schema property")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	if s.Fields != nil && s.Type == "any" {
+		s.Type = "block"
+	}
+	if s.Items != nil && s.Type == "any" {
+		s.Type = "list"
+	}
+	for _, bound := range []string{s.Min, s.Max} {
+		if bound == "" {
+			continue
+		}
+		if _, err := orderedValue(s.Type, bound); err != nil {
+			return nil, fmt.Errorf("%s: bound %q: %w", path, bound, err)
+		}
+	}
+	return s, nil
+}
+
+// schemaString returns a scalar schema property.
+func schemaString(v up.Value) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("must be a scalar")
+	}
+	return s, nil
+}
+
+// schemaStrings returns a list schema property.
+func schemaStrings(v up.Value) ([]string, error) {
+	list, ok := v.(up.List)
+	if !ok {
+		return nil, fmt.Errorf("must be a list")
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("items must be scalars")
+		}
+		items[i] = s
+	}
+	return items, nil
+}
+
+// schemaInt returns an integer schema property.
+func schemaInt(v up.Value) (*int, error) {
+	s, err := schemaString(v)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("must be an integer")
+	}
+	return &n, nil
+}
+
+// validateDocument checks a document against a schema. In strict mode keys
+// the schema does not describe are violations, and scalars must carry the
+// type annotation the schema requires. Violations are sorted by path.
+func validateDocument(doc *up.Document, s *schema, strict bool) []violation {
+	block := make(up.Block, len(doc.Nodes))
+	for _, node := range doc.Nodes {
+		if node.Type == "schema" {
+			continue
+		}
+		block[joinKey(node.Key, node.Type)] = node.Value
+	}
+
+	var violations []violation
+	s.validate("", "", block, strict, &violations)
+	// Blocks are maps, so violations come in no particular order.
+	sort.SliceStable(violations, func(i, j int) bool {
+		a, b := violations[i], violations[j]
+		return a.Path < b.Path || a.Path == b.Path && a.Message < b.Message
+	})
+	return violations
+}
+
+// violationDiagnostics converts schema violations into diagnostics located
+// at the entries they concern. A missing key is reported at the block that
+// lacks it, or for the whole file at the top level.
+func violationDiagnostics(filename string, data []byte, violations []violation) diagnosticError {
+	root, _ := parseSyntax(bytes.NewReader(data))
+	lines := sourceLines(data)
+	diags := make(diagnosticError, len(violations))
+	for i, v := range violations {
+		diags[i] = diagnostic{Severity: severityError, Code: v.Code, Message: v.String()}
+		if node := locateNode(root, v.Path); node != nil && node != root {
+			diags[i] = nodeDiagnostic(lines, node, v.Code, v.String())
+		}
+	}
+	return diags.inFile(filename, data)
+}
+
+// locateNode returns the syntax node at a violation path, or the deepest
+// node on the path that exists.
+func locateNode(root *syntaxNode, path string) *syntaxNode {
+	if root == nil || path == "" {
+		return root
+	}
+	segments, err := parseEditPath(path)
+	if err != nil {
+		return root
+	}
+	node := root
+	for _, seg := range segments {
+		var next *syntaxNode
+		switch {
+		case seg.IsIndex && node.Kind == listSyntax && seg.Index < len(node.Children):
+			next = node.Children[seg.Index]
+		case !seg.IsIndex && node.Kind == blockSyntax:
+			next = childByKey(node, seg.Key)
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node
+}
+
+// validate checks a value and its type annotation against the schema,
+// appending every violation found.
+func (s *schema) validate(path, typ string, v up.Value, strict bool, violations *[]violation) {
+	report := func(code, format string, args ...any) {
+		*violations = append(*violations, violation{Path: path, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch value := v.(type) {
+	case up.Block:
+		if s.Type != "block" && s.Type != "any" {
+			report(codeTypeMismatch, "expected %s, got a block", s.Type)
+			return
+		}
+		s.validateBlock(path, value, strict, violations)
+	case up.List:
+		if s.Type != "list" && s.Type != "any" {
+			report(codeTypeMismatch, "expected %s, got a list", s.Type)
+			return
+		}
+		s.validateList(path, typ, value, strict, violations)
+	case string:
+		s.validateScalar(path, typ, value, strict, report)
+	}
+}
+
+// validateBlock checks the entries of a block against the field specs.
+func (s *schema) validateBlock(path string, block up.Block, strict bool, violations *[]violation) {
+	present := make(map[string]bool, len(block))
+	for _, k := range sortedKeys(block) {
+		key, typ := splitKey(k)
+		present[key] = true
+		childPath := keyPath(path, key)
+
+		field, ok := s.Fields[key]
+		if !ok {
+			if strict && s.Fields != nil {
+				*violations = append(*violations, violation{Path: childPath, Code: codeUnknownKey, Message: "unknown key"})
+			}
+			continue
+		}
+		field.validate(childPath, typ, block[k], strict, violations)
+	}
+
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s.Fields[name].Required && !present[name] {
+			*violations = append(*violations, violation{Path: keyPath(path, name), Code: codeMissingKey, Message: "required key is missing"})
+		}
+	}
+}
+
+// validateList checks the length and items of a list. The list's own type
+// annotation applies to its scalar items.
+func (s *schema) validateList(path, typ string, list up.List, strict bool, violations *[]violation) {
+	if s.MinLength != nil && len(list) < *s.MinLength {
+		*violations = append(*violations, violation{Path: path, Code: codeLength, Message: fmt.Sprintf("list has %d items, fewer than the minimum %d", len(list), *s.MinLength)})
+	}
+	if s.MaxLength != nil && len(list) > *s.MaxLength {
+		*violations = append(*violations, violation{Path: path, Code: codeLength, Message: fmt.Sprintf("list has %d items, more than the maximum %d", len(list), *s.MaxLength)})
+	}
+	if s.Items == nil {
+		return
+	}
+	for i, item := range list {
+		s.Items.validate(fmt.Sprintf("%s[%d]", path, i), typ, item, strict, violations)
+	}
+}
+
+// validateScalar checks a scalar value.
+func (s *schema) validateScalar(path, typ, value string, strict bool, report func(string, string, ...any)) {
+	switch s.Type {
+	case "block", "list":
+		report(codeTypeMismatch, "expected a %s, got a scalar", s.Type)
+		return
+	case "any":
+	case "string":
+		if typ != "" && typ != "string" && !isDedent(typ) {
+			report(codeTypeMismatch, "expected !string, got !%s", typ)
+			return
+		}
+	default:
+		if typ != "" && typ != s.Type && !(typ == "int" && s.Type == "float") {
+			report(codeTypeMismatch, "expected !%s, got !%s", s.Type, typ)
+			return
+		}
+		if typ == "" && strict {
+			report(codeMissingAnnotation, "missing !%s annotation", s.Type)
+		}
+		if !validScalar(s.Type, value) {
+			report(codeTypeMismatch, "invalid %s %q", s.Type, value)
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 && !containsString(s.Enum, value) {
+		report(codeNotInEnum, "%q is not one of %s", value, strings.Join(s.Enum, ", "))
+	}
+	if s.Pattern != nil && !s.Pattern.MatchString(value) {
+		report(codePatternMismatch, "%q does not match pattern %s", value, s.Pattern)
+	}
+	length := len([]rune(value))
+	if s.MinLength != nil && length < *s.MinLength {
+		report(codeLength, "length %d is less than the minimum %d", length, *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		report(codeLength, "length %d is more than the maximum %d", length, *s.MaxLength)
+	}
+	if s.Min != "" && compareScalar(s.Type, value, s.Min) < 0 {
+		report(codeRange, "%s is below the minimum %s", value, s.Min)
+	}
+	if s.Max != "" && compareScalar(s.Type, value, s.Max) > 0 {
+		report(codeRange, "%s is above the maximum %s", value, s.Max)
+	}
+}
+
+// isDedent reports whether a type annotation is a multiline dedent width.
+func isDedent(typ string) bool {
+	_, ok := dedentWidth(typ)
+	return ok
+}
+
+// validScalar reports whether a scalar is a valid value of a type.
+func validScalar(typ, value string) bool {
+	switch typ {
+	case "bool":
+		return value == "true" || value == "false"
+	case "uuid":
+		return uuidPattern.MatchString(value)
+	case "int", "float", "dur", "ts":
+		_, err := orderedValue(typ, value)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// orderedValue converts a scalar of an ordered type into a float64 for range
+// comparisons.
+func orderedValue(typ, value string) (float64, error) {
+	switch typ {
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		return float64(n), err
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "dur":
+		d, err := parseDuration(value)
+		return float64(d), err
+	case "ts":
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return float64(t.UnixNano()), nil
+			}
+		}
+		return 0, fmt.Errorf("invalid ts")
+	default:
+		return 0, fmt.Errorf("min and max require an int, float, dur or ts type")
+	}
+}
+
+// compareScalar compares two values of an ordered type, returning -1, 0 or 1.
+// Values that cannot be compared count as equal; they are reported as
+// invalid elsewhere.
+func compareScalar(typ, a, b string) int {
+	x, errA := orderedValue(typ, a)
+	y, errB := orderedValue(typ, b)
+	switch {
+	case errA != nil || errB != nil || x == y:
+		return 0
+	case x < y:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// parseDuration parses a Go duration, additionally accepting a day suffix
+// ("7d").
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findSchema locates the schema for a document: a top-level node annotated
+// !schema, whose value is a path relative to the document, the schema the
+// project config associates with the file, or a sibling file with the same
+// base name and the .up-schema extension.
+func findSchema(filename string, doc *up.Document, pc *projectConfig) (string, bool) {
+	dir := "."
+	if filename != "" {
+		dir = filepath.Dir(filename)
+	}
+	for _, node := range doc.Nodes {
+		if node.Type == "schema" {
+			if path, ok := node.Value.(string); ok && path != "" {
+				if filepath.IsAbs(path) {
+					return path, true
+				}
+				return filepath.Join(dir, path), true
+			}
+		}
+	}
+
+	if filename == "" {
+		return "", false
+	}
+	if path, ok := pc.schemaFor(filename); ok {
+		return path, true
+	}
+	sibling := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".up-schema"
+	if _, err := os.Stat(sibling); err == nil {
+		return sibling, true
+	}
+	return "", false
+}
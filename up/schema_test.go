@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes a file into a temporary directory and returns its
+// path.
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+const testSchema = `name {
+  type string
+  required!bool true
+  pattern ^[a-z]+$
+}
+port {
+  type int
+  min!int 1
+  max!int 65535
+}
+env {
+  type string
+  enum [
+    dev
+    prod
+  ]
+}
+tags {
+  type list
+  max_length!int 2
+  items {
+    type string
+  }
+}
+server {
+  fields {
+    timeout {
+      type dur
+    }
+  }
+}
+`
+
+func TestValidateDocument(t *testing.T) {
+	s, err := loadSchema(writeTestFile(t, "test.up-schema", testSchema))
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		doc    string
+		strict bool
+		want   []string
+	}{
+		{
+			name: "valid",
+			doc:  "name app\nport!int 8080\nenv prod\ntags [\n  a\n]\nserver {\n  timeout!dur 30s\n}\n",
+		},
+		{
+			name: "missing required key",
+			doc:  "port!int 80\n",
+			want: []string{"name: required key is missing"},
+		},
+		{
+			name: "sorted by path",
+			doc:  "server {\n  timeout!dur soon\n}\ntags [\n  a\n  b\n  c\n]\nport!int 0\nname App\nenv qa\n",
+			want: []string{
+				`env: "qa" is not one of dev, prod`,
+				`name: "App" does not match pattern ^[a-z]+$`,
+				"port: 0 is below the minimum 1",
+				"server.timeout: invalid dur \"soon\"",
+				"tags: list has 3 items, more than the maximum 2",
+			},
+		},
+		{
+			name: "wrong annotation",
+			doc:  "name app\nport!float 1.5\n",
+			want: []string{"port: expected !int, got !float"},
+		},
+		{
+			name: "block for scalar",
+			doc:  "name {\n  x y\n}\n",
+			want: []string{"name: expected string, got a block"},
+		},
+		{
+			name:   "strict",
+			doc:    "name app\nport 80\nextra x\n",
+			strict: true,
+			want:   []string{"extra: unknown key", "port: missing !int annotation"},
+		},
+		{
+			name: "not strict",
+			doc:  "name app\nport 80\nextra x\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseSource("test.up", []byte(tt.doc))
+			if err != nil {
+				t.Fatalf("failed to parse document: %v", err)
+			}
+			var got []string
+			for _, v := range validateDocument(doc, s, tt.strict) {
+				got = append(got, v.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"spec not a block", "name string\n", "name: field spec must be a block"},
+		{"unknown type", "name {\n  type text\n}\n", `name: type: unknown type "text"`},
+		{"unknown property", "name {\n  color red\n}\n", "name: color: unknown schema property"},
+		{"invalid pattern", "name {\n  pattern (a\n}\n", "name: pattern"},
+		{"invalid bound", "port {\n  type int\n  min one\n}\n", `port: bound "one"`},
+		{"invalid required", "name {\n  required maybe\n}\n", "name: required"},
+		{"syntax error", "name {\n", "failed to parse schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadSchema(writeTestFile(t, "test.up-schema", tt.schema))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidScalar(t *testing.T) {
+	tests := []struct {
+		typ, value string
+		want       bool
+	}{
+		{"string", "anything", true},
+		{"int", "-12", true},
+		{"int", "1.5", false},
+		{"float", "1.5", true},
+		{"float", "x", false},
+		{"bool", "true", true},
+		{"bool", "yes", false},
+		{"dur", "1h30m", true},
+		{"dur", "soon", false},
+		{"ts", "2024-03-01T12:00:00Z", true},
+		{"ts", "2024-03-01", true},
+		{"ts", "yesterday", false},
+		{"uuid", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"uuid", "123e4567", false},
+	}
+	for _, tt := range tests {
+		if got := validScalar(tt.typ, tt.value); got != tt.want {
+			t.Errorf("validScalar(%q, %q) = %v, want %v", tt.typ, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	schemaPath := writeTestFile(t, "test.up-schema", testSchema)
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{"valid", "name app\n", nil},
+		{"syntax error", "name app\nserver {\n", []string{"unterminated block"}},
+		{"violations", "port!int 0\nenv qa\n", []string{`env: "qa" is not one of dev, prod`, "name: required key is missing", "port: 0 is below the minimum 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, tt.doc, "validate", "--schema", schemaPath)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var diags diagnosticError
+			if !errors.As(err, &diags) {
+				t.Fatalf("expected diagnostics, got %v", err)
+			}
+			if len(diags) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d: %v", len(diags), len(tt.want), diags)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(diags[i].Message, want) {
+					t.Errorf("diagnostic %d is %q, want one containing %q", i, diags[i].Message, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	up "github.com/uplang/go"
+	"github.com/urfave/cli/v2"
+)
+
+// templateParam is a parameter a template declares in a params!params
+// block. Its spec takes the properties of a schema field, plus a default.
+type templateParam struct {
+	name string
+	spec *schema
+	// defaultValue is nil when the parameter has no default.
+	defaultValue up.Value
+	defaultType  string
+}
+
+// templateValueFlags returns the flags supplying values to a template.
+func templateValueFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "values",
+			Usage: "Values `FILE`: parameter values and an overlay for the result (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "Set a parameter or a value of the result, as `PATH=VALUE` (repeatable; applied after --values)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "set-type",
+			Usage: "Type annotation of a --set value, as `PATH=TYPE`",
+		},
+	}
+}
+
+// processTemplate processes the template named by --input with the values
+// of --values and --set. Values whose top-level key names a parameter the
+// template declares are passed to it as $vars; the others are merged into
+// the result as a final overlay. It fails if a required parameter is
+// missing or a value does not match its parameter's spec.
+func (a *App) processTemplate(c *cli.Context) (*up.Document, error) {
+	filename := c.String("input")
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	template, err := parseSource(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	params, err := templateParams(template)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	overlay, err := a.templateValues(c)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := bindParams(params, overlay)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVarReferences(filename, template, vars); err != nil {
+		return nil, err
+	}
+
+	doc, err := up.NewTemplateEngine().WithVars(vars).ProcessTemplate(filename)
+	if err != nil {
+		return nil, err
+	}
+	kept := doc.Nodes[:0]
+	for _, node := range doc.Nodes {
+		if node.Type != "params" {
+			kept = append(kept, node)
+		}
+	}
+	doc.Nodes = kept
+
+	var m merger
+	return m.mergeDocuments(doc, overlay)
+}
+
+// templateParams reads the parameters a template declares:
+//
+//	params!params {
+//	  env {
+//	    type string
+//	    required!bool true
+//	    enum [
+//	      dev
+//	      prod
+//	    ]
+//	  }
+//	  replicas {
+//	    type int
+//	    default!int 2
+//	  }
+//	}
+//
+// A parameter that is not required must have a default. Parameter names
+// must not also be defined in the template's vars block, which would take
+// precedence over them.
+func templateParams(doc *up.Document) ([]templateParam, error) {
+	var params []templateParam
+	vars := make(up.Block)
+	for _, node := range doc.Nodes {
+		switch {
+		case node.Key == "vars":
+			if block, ok := node.Value.(up.Block); ok {
+				vars = block
+			}
+		case node.Type == "params":
+			block, ok := node.Value.(up.Block)
+			if !ok {
+				return nil, fmt.Errorf("a !params directive must be a block")
+			}
+			for _, k := range sortedKeys(block) {
+				param, err := parseTemplateParam(k, block[k])
+				if err != nil {
+					return nil, err
+				}
+				params = append(params, param)
+			}
+		}
+	}
+
+	for _, param := range params {
+		for k := range vars {
+			if key, _ := splitKey(k); key == param.name {
+				return nil, fmt.Errorf("parameter %q is also defined in vars", param.name)
+			}
+		}
+	}
+	return params, nil
+}
+
+// parseTemplateParam parses the declaration of a parameter.
+func parseTemplateParam(k string, v up.Value) (templateParam, error) {
+	name, _ := splitKey(k)
+	spec, ok := v.(up.Block)
+	if !ok {
+		return templateParam{}, fmt.Errorf("parameter %s: declaration must be a block", name)
+	}
+
+	param := templateParam{name: name}
+	rest := make(up.Block, len(spec))
+	for k, v := range spec {
+		if key, typ := splitKey(k); key == "default" {
+			param.defaultValue, param.defaultType = v, typ
+		} else {
+			rest[k] = v
+		}
+	}
+	var err error
+	if param.spec, err = parseSchema(name, rest); err != nil {
+		return templateParam{}, fmt.Errorf("parameter %w", err)
+	}
+	if !param.spec.Required && param.defaultValue == nil {
+		return templateParam{}, fmt.Errorf("parameter %s: an optional parameter must have a default", name)
+	}
+	return param, nil
+}
+
+// templateValues reads the --values files and --set assignments into an
+// overlay document. Later values take precedence.
+func (a *App) templateValues(c *cli.Context) (*up.Document, error) {
+	var m merger
+	overlay := &up.Document{}
+	for _, filename := range c.StringSlice("values") {
+		doc, err := a.readInput(filename)
+		if err != nil {
+			return nil, err
+		}
+		if overlay, err = m.mergeDocuments(overlay, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	types := make(map[string]string)
+	for _, assignment := range c.StringSlice("set-type") {
+		path, typ, ok := strings.Cut(assignment, "=")
+		if !ok || typ == "" {
+			return nil, fmt.Errorf("invalid --set-type %q (expected PATH=TYPE)", assignment)
+		}
+		types[path] = typ
+	}
+
+	for _, assignment := range c.StringSlice("set") {
+		expr, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q (expected PATH=VALUE)", assignment)
+		}
+		path, err := parseEditPath(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --set %q: %w", assignment, err)
+		}
+		typ := types[expr]
+		delete(types, expr)
+		if typ != "" && schemaTypes[typ] && !validScalar(typ, value) {
+			return nil, fmt.Errorf("invalid --set %q: not a valid %s", assignment, typ)
+		}
+
+		// Build the value from the inside out.
+		var v up.Value = value
+		for i := len(path) - 1; i > 0; i-- {
+			if path[i].IsIndex {
+				return nil, fmt.Errorf("invalid --set %q: list indexes are not supported", assignment)
+			}
+			v = up.Block{joinKey(path[i].Key, typ): v}
+			typ = ""
+		}
+		if path[0].IsIndex {
+			return nil, fmt.Errorf("invalid --set %q: list indexes are not supported", assignment)
+		}
+		node := up.Node{Key: path[0].Key, Type: typ, Value: v}
+		if overlay, err = m.mergeDocuments(overlay, &up.Document{Nodes: []up.Node{node}}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(types) > 0 {
+		unmatched := slices.Sorted(maps.Keys(types))
+		return nil, fmt.Errorf("--set-type %s has no matching --set", unmatched[0])
+	}
+	return overlay, nil
+}
+
+// bindParams takes the values of the declared parameters out of the
+// overlay and returns them as template variables, falling back to their
+// defaults, so that every parameter is bound. Block values are flattened
+// into dotted variable names, as the template engine does with vars blocks.
+func bindParams(params []templateParam, overlay *up.Document) (map[string]any, error) {
+	vars := make(map[string]any)
+	var missing []string
+	var violations []violation
+	for _, param := range params {
+		typ, value, found := param.defaultType, param.defaultValue, param.defaultValue != nil
+		kept := overlay.Nodes[:0]
+		for _, node := range overlay.Nodes {
+			if node.Key == param.name {
+				typ, value, found = node.Type, node.Value, true
+			} else {
+				kept = append(kept, node)
+			}
+		}
+		overlay.Nodes = kept
+
+		if !found {
+			if param.spec.Required {
+				missing = append(missing, param.name)
+			}
+			continue
+		}
+		param.spec.validate(param.name, typ, value, false, &violations)
+		flattenVars(param.name, value, vars)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required parameter(s): %s", strings.Join(missing, ", "))
+	}
+	if len(violations) > 0 {
+		messages := make([]string, len(violations))
+		for i, v := range violations {
+			messages[i] = v.String()
+		}
+		sort.Strings(messages)
+		return nil, fmt.Errorf("invalid parameter(s): %s", strings.Join(messages, "; "))
+	}
+	return vars, nil
+}
+
+// flattenVars stores a value under a variable name, storing the entries of
+// blocks under dotted names.
+func flattenVars(name string, v up.Value, vars map[string]any) {
+	block, ok := v.(up.Block)
+	if !ok {
+		vars[name] = v
+		return
+	}
+	for k, value := range block {
+		key, _ := splitKey(k)
+		flattenVars(name+"."+key, value, vars)
+	}
+}
+
+// checkVarReferences fails if the template, or a file it loads with !base
+// or !include, references a variable that is neither bound in vars nor
+// defined in a vars block. The template engine would not terminate on such
+// a reference.
+func checkVarReferences(filename string, template *up.Document, vars map[string]any) error {
+	bound := make(map[string]bool, len(vars))
+	refs := make(map[string]bool)
+	for name, v := range vars {
+		bound[name] = true
+		collectVarReferences(v, refs)
+	}
+
+	visited := map[string]bool{filepath.Clean(filename): true}
+	var walk func(dir string, doc *up.Document) error
+	walk = func(dir string, doc *up.Document) error {
+		var loaded []string
+		for _, node := range doc.Nodes {
+			switch {
+			case node.Type == "base":
+				if file, ok := node.Value.(string); ok {
+					loaded = append(loaded, file)
+				}
+			case node.Type == "include":
+				list, _ := node.Value.(up.List)
+				for _, item := range list {
+					if file, ok := item.(string); ok {
+						loaded = append(loaded, file)
+					}
+				}
+			case node.Key == "vars":
+				// The engine stores vars under their keys as written,
+				// annotations included.
+				if block, ok := node.Value.(up.Block); ok {
+					boundVarNames("", block, bound)
+				}
+			}
+			collectVarReferences(node.Value, refs)
+		}
+
+		for _, file := range loaded {
+			path := filepath.Join(dir, file)
+			if visited[path] {
+				continue
+			}
+			visited[path] = true
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", file, err)
+			}
+			doc, err := parseSource(path, data)
+			if err != nil {
+				return err
+			}
+			if err := walk(filepath.Dir(path), doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(filepath.Dir(filename), template); err != nil {
+		return err
+	}
+
+	var undefined []string
+	for ref := range refs {
+		if !bound[ref] {
+			undefined = append(undefined, "$vars."+ref)
+		}
+	}
+	if len(undefined) > 0 {
+		sort.Strings(undefined)
+		return fmt.Errorf("%s: undefined variable(s): %s", filename, strings.Join(undefined, ", "))
+	}
+	return nil
+}
+
+// boundVarNames adds the names the template engine binds for a vars block.
+func boundVarNames(prefix string, block up.Block, bound map[string]bool) {
+	for k, v := range block {
+		name := k
+		if prefix != "" {
+			name = prefix + "." + k
+		}
+		if nested, ok := v.(up.Block); ok {
+			boundVarNames(name, nested, bound)
+		} else {
+			bound[name] = true
+		}
+	}
+}
+
+// collectVarReferences adds the names of the $vars references in the
+// strings of a value, delimited as the template engine delimits them.
+func collectVarReferences(v any, refs map[string]bool) {
+	switch v := v.(type) {
+	case string:
+		for {
+			_, rest, found := strings.Cut(v, "$vars.")
+			if !found {
+				return
+			}
+			end := strings.IndexFunc(rest, func(r rune) bool {
+				return !(r == '.' || r == '_' || r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+			})
+			if end < 0 {
+				end = len(rest)
+			}
+			refs[rest[:end]] = true
+			v = rest[end:]
+		}
+	case up.Block:
+		for _, value := range v {
+			collectVarReferences(value, refs)
+		}
+	case up.List:
+		for _, item := range v {
+			collectVarReferences(item, refs)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplateFiles writes files into a temporary directory and returns
+// its path.
+func writeTemplateFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const paramsTemplate = `params!params {
+  env {
+    type string
+    required!bool true
+    enum [
+      dev
+      prod
+    ]
+  }
+  replicas {
+    type int
+    default!int 2
+  }
+  db {
+    type block
+    default {
+      host localhost
+    }
+  }
+}
+server {
+  name app-$vars.env
+  replicas $vars.replicas
+  db $vars.db.host
+}
+`
+
+func TestTemplateProcess(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		args  []string
+		want  []string
+	}{
+		{
+			name:  "defaults",
+			files: map[string]string{"app.up": paramsTemplate},
+			args:  []string{"--set", "env=dev"},
+			want:  []string{"name app-dev", "replicas 2", "db localhost"},
+		},
+		{
+			name:  "set parameters",
+			files: map[string]string{"app.up": paramsTemplate},
+			args:  []string{"--set", "env=prod", "--set", "replicas=5", "--set", "db.host=db.internal"},
+			want:  []string{"name app-prod", "replicas 5", "db db.internal"},
+		},
+		{
+			name:  "values file and overlay",
+			files: map[string]string{"app.up": paramsTemplate, "prod.up": "env prod\nserver {\n  port!int 8080\n}\n"},
+			args:  []string{"--values", "prod.up"},
+			want:  []string{"name app-prod", "port!int 8080"},
+		},
+		{
+			name: "vars block",
+			files: map[string]string{"app.up": `vars {
+  region eu
+}
+zone $vars.region-1
+`},
+			want: []string{"zone eu-1"},
+		},
+		{
+			name: "included vars",
+			files: map[string]string{
+				"app.up":    "include!include [\n  common.up\n]\nzone $vars.region\n",
+				"common.up": "vars {\n  region us\n}\n",
+			},
+			want: []string{"zone us"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTemplateFiles(t, tt.files)
+			args := []string{"template", "process", "-i", filepath.Join(dir, "app.up")}
+			for i := 0; i < len(tt.args); i++ {
+				arg := tt.args[i]
+				if arg == "--values" {
+					i++
+					arg = "--values=" + filepath.Join(dir, tt.args[i])
+				}
+				args = append(args, arg)
+			}
+			out, err := runApp(t, "", args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output does not contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		args     []string
+		want     string
+	}{
+		{
+			name:     "missing required parameter",
+			template: paramsTemplate,
+			want:     "missing required parameter(s): env",
+		},
+		{
+			name:     "invalid parameter",
+			template: paramsTemplate,
+			args:     []string{"--set", "env=qa"},
+			want:     "invalid parameter(s)",
+		},
+		{
+			name:     "optional parameter without default",
+			template: "params!params {\n  db {\n    type block\n  }\n}\nhost $vars.db.host\n",
+			want:     "parameter db: an optional parameter must have a default",
+		},
+		{
+			name:     "parameter also in vars",
+			template: "vars {\n  env dev\n}\nparams!params {\n  env {\n    type string\n    default dev\n  }\n}\n",
+			want:     `parameter "env" is also defined in vars`,
+		},
+		{
+			name:     "undefined variable",
+			template: "name $vars.missing\n",
+			want:     "undefined variable(s): $vars.missing",
+		},
+		{
+			name:     "undefined block entry",
+			template: paramsTemplate + "port $vars.db.port\n",
+			args:     []string{"--set", "env=dev"},
+			want:     "undefined variable(s): $vars.db.port",
+		},
+		{
+			name:     "unknown set type",
+			template: "name app\n",
+			args:     []string{"--set-type", "port=int"},
+			want:     "--set-type port has no matching --set",
+		},
+	}
+	for _, tt := range tests {
+		for _, command := range []string{"process", "validate"} {
+			t.Run(tt.name+"/"+command, func(t *testing.T) {
+				dir := writeTemplateFiles(t, map[string]string{"app.up": tt.template})
+				args := append([]string{"template", command, "-i", filepath.Join(dir, "app.up")}, tt.args...)
+				_, err := runApp(t, "", args...)
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("error %q does not contain %q", err, tt.want)
+				}
+			})
+		}
+	}
+}
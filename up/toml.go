@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	up "github.com/uplang/go"
+)
+
+// tomlCodec reads and writes TOML documents.
+type tomlCodec struct{}
+
+// Decode reads a TOML document. Tables and arrays of tables become nested
+// blocks and lists, integers become !int and datetimes become !ts.
+func (c tomlCodec) Decode(r io.Reader) (*up.Document, error) {
+	objs, err := c.DecodeObjects(r)
+	if err != nil {
+		return nil, err
+	}
+	return documentFromObject(objs[0])
+}
+
+// DecodeObjects reads a TOML document, keeping the order in which keys are
+// defined.
+func (tomlCodec) DecodeObjects(r io.Reader) ([]object, error) {
+	var data map[string]any
+	md, err := toml.NewDecoder(r).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		if _, ok := order[key.String()]; !ok {
+			order[key.String()] = i
+		}
+	}
+
+	obj, ok := tomlValue(nil, data, order).(object)
+	if !ok {
+		return nil, fmt.Errorf("top-level value must be a table")
+	}
+	return []object{obj}, nil
+}
+
+// Encode writes a UP document as TOML. Values TOML cannot express, such as
+// nulls, are reported with their key path.
+func (c tomlCodec) Encode(w io.Writer, doc *up.Document, pretty bool) error {
+	obj, err := documentToObject(doc)
+	if err != nil {
+		return err
+	}
+	return c.EncodeObject(w, obj, pretty)
+}
+
+// EncodeObject writes an ordered object as TOML.
+func (tomlCodec) EncodeObject(w io.Writer, obj object, _ bool) error {
+	bw := bufio.NewWriter(w)
+	te := &tomlEncoder{w: bw}
+	if err := te.writeTable(nil, "", obj); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// tomlValue converts a decoded TOML value into a format-neutral value,
+// ordering table keys by their position in the source.
+func tomlValue(path toml.Key, v any, order map[string]int) any {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		position := func(k string) int {
+			if pos, ok := order[append(path[:len(path):len(path)], k).String()]; ok {
+				return pos
+			}
+			return math.MaxInt
+		}
+		sort.SliceStable(keys, func(i, j int) bool { return position(keys[i]) < position(keys[j]) })
+
+		obj := make(object, 0, len(keys))
+		for _, k := range keys {
+			childPath := append(path[:len(path):len(path)], k)
+			obj = append(obj, member{Key: k, Value: tomlValue(childPath, v[k], order)})
+		}
+		return obj
+	case []map[string]any:
+		items := make([]any, len(v))
+		for i, table := range v {
+			items[i] = tomlValue(path, table, order)
+		}
+		return items
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = tomlValue(path, item, order)
+		}
+		return items
+	case time.Time:
+		return tomlTimestamp(v)
+	default:
+		return v
+	}
+}
+
+// tomlTimestamp formats a TOML datetime, keeping local dates and times in
+// their local form.
+func tomlTimestamp(t time.Time) timestamp {
+	switch t.Location().String() {
+	case "datetime-local":
+		return timestamp(t.Format("2006-01-02T15:04:05.999999999"))
+	case "date-local":
+		return timestamp(t.Format("2006-01-02"))
+	case "time-local":
+		return timestamp(t.Format("15:04:05.999999999"))
+	default:
+		return timestamp(t.Format(time.RFC3339Nano))
+	}
+}
+
+// tomlEncoder writes format-neutral values as TOML.
+type tomlEncoder struct {
+	w *bufio.Writer
+}
+
+// writeTable writes the body of the table at path: its plain keys first, then
+// its sub-tables and arrays of tables, as TOML requires. where is the key
+// path used in error messages, including array indexes.
+func (te *tomlEncoder) writeTable(path []string, where string, obj object) error {
+	for _, m := range obj {
+		if isTOMLTable(m.Value) || isTOMLTableArray(m.Value) {
+			continue
+		}
+		value, err := tomlInline(keyPath(where, m.Key), m.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(te.w, "%s = %s\n", tomlKey(m.Key), value)
+	}
+
+	for _, m := range obj {
+		childPath := append(path[:len(path):len(path)], m.Key)
+		switch {
+		case isTOMLTable(m.Value):
+			fmt.Fprintf(te.w, "\n[%s]\n", tomlKeyPath(childPath))
+			if err := te.writeTable(childPath, keyPath(where, m.Key), m.Value.(object)); err != nil {
+				return err
+			}
+		case isTOMLTableArray(m.Value):
+			for i, item := range m.Value.([]any) {
+				fmt.Fprintf(te.w, "\n[[%s]]\n", tomlKeyPath(childPath))
+				itemWhere := fmt.Sprintf("%s[%d]", keyPath(where, m.Key), i)
+				if err := te.writeTable(childPath, itemWhere, item.(object)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isTOMLTable reports whether a value is written as a [table].
+func isTOMLTable(v any) bool {
+	_, ok := v.(object)
+	return ok
+}
+
+// isTOMLTableArray reports whether a value is written as an [[array]] of
+// tables, which requires a non-empty list holding only tables.
+func isTOMLTableArray(v any) bool {
+	items, ok := v.([]any)
+	if !ok || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if _, ok := item.(object); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlInline formats a value in inline form.
+func tomlInline(path string, v any) (string, error) {
+	switch v := v.(type) {
+	case object:
+		parts := make([]string, 0, len(v))
+		for _, m := range v {
+			value, err := tomlInline(keyPath(path, m.Key), m.Value)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(m.Key)+" = "+value)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	case []any:
+		parts := make([]string, 0, len(v))
+		for i, item := range v {
+			value, err := tomlInline(fmt.Sprintf("%s[%d]", path, i), item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, value)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case string:
+		return tomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return tomlFloat(v), nil
+	case timestamp:
+		return string(v), nil
+	case annotatedScalar:
+		return tomlString(v.Text), nil
+	case uint64:
+		return "", fmt.Errorf("%s: integer %d is out of range for TOML", path, v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return v.String(), nil
+		}
+		if _, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return "", fmt.Errorf("%s: integer %s is out of range for TOML", path, v)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid number %s", path, v)
+		}
+		return tomlFloat(f), nil
+	case nil:
+		return "", fmt.Errorf("%s: null values cannot be represented in TOML", path)
+	default:
+		return "", fmt.Errorf("%s: values of type %T cannot be represented in TOML", path, v)
+	}
+}
+
+// tomlFloat formats a float so that it is never read back as an integer.
+func tomlFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// tomlString quotes a string as a TOML basic string.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// bareKey matches keys that need no quoting in TOML.
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKey formats a single key, quoting it when necessary.
+func tomlKey(k string) string {
+	if bareKey.MatchString(k) {
+		return k
+	}
+	return tomlString(k)
+}
+
+// tomlKeyPath formats a dotted table header key.
+func tomlKeyPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, k := range path {
+		parts[i] = tomlKey(k)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestConvertTOMLToUP(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want string
+	}{
+		{
+			name: "scalars",
+			toml: "name = \"app\"\nport = 8080\nratio = 1.5\non = true\n",
+			want: "name app\nport!int 8080\nratio!float 1.5\non!bool true\n",
+		},
+		{
+			name: "key order",
+			toml: "z = 1\na = 2\n\n[server]\nport = 1\nhost = \"h\"\n",
+			want: "z!int 1\na!int 2\nserver {\n  port!int 1\n  host h\n}\n",
+		},
+		{
+			name: "datetimes",
+			toml: "at = 2024-03-01T12:00:00Z\nlocal = 2024-03-01T12:00:00\nday = 2024-03-01\ntime = 12:00:00\n",
+			want: "at!ts 2024-03-01T12:00:00Z\nlocal!ts 2024-03-01T12:00:00\nday!ts 2024-03-01\ntime!ts 12:00:00\n",
+		},
+		{
+			name: "array of tables",
+			toml: "[[users]]\nname = \"a\"\n\n[[users]]\nname = \"b\"\n",
+			want: "users [\n  {\n    name a\n  }\n  {\n    name b\n  }\n]\n",
+		},
+		{
+			name: "inline table",
+			toml: "point = { x = 1, y = 2 }\n",
+			want: "point {\n  x!int 1\n  y!int 2\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, tt.toml, "convert", "--from", "toml", "--to", "up")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertUPToTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		up   string
+		want string
+	}{
+		{
+			name: "tables after keys",
+			up:   "server {\n  port!int 80\n}\nname app\n",
+			want: "name = \"app\"\n\n[server]\nport = 80\n",
+		},
+		{
+			name: "array of tables",
+			up:   "users [\n  {\n    name a\n  }\n]\n",
+			want: "\n[[users]]\nname = \"a\"\n",
+		},
+		{
+			name: "quoted keys and strings",
+			up:   "a.b \"x\"\\y\n",
+			want: "\"a.b\" = \"\\\"x\\\"\\\\y\"\n",
+		},
+		{
+			name: "float stays float",
+			up:   "f!float 2\n",
+			want: "f = 2.0\n",
+		},
+		{
+			name: "custom annotation as string",
+			up:   "c!rgb #fff\n",
+			want: "c = \"#fff\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, tt.up, "convert", "--from", "up", "--to", "toml")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertTOMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		up   string
+	}{
+		{"scalars", "name app\nport!int 8080\nratio!float 1.5\non!bool true\n"},
+		{"datetimes", "at!ts 2024-03-01T12:00:00Z\nday!ts 2024-03-01\n"},
+		{"nested", "name app\nserver {\n  host localhost\n  ports!int [\n    80\n    443\n  ]\n}\n"},
+		{"multiline", "text ```\none\ntwo\n```\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tomlText, err := runApp(t, tt.up, "convert", "--from", "up", "--to", "toml")
+			if err != nil {
+				t.Fatalf("up to toml: %v", err)
+			}
+			back, err := runApp(t, tomlText, "convert", "--from", "toml", "--to", "up")
+			if err != nil {
+				t.Fatalf("toml to up: %v\n%s", err, tomlText)
+			}
+			if back != tt.up {
+				t.Errorf("round trip through:\n%s\ngot:\n%s\nwant:\n%s", tomlText, back, tt.up)
+			}
+		})
+	}
+}
+
+func TestConvertTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		from  string
+		to    string
+		input string
+		want  string
+	}{
+		{"null", "up", "toml", "server {\n  x!null null\n}\n", "server.x: null values cannot be represented in TOML"},
+		{"null in array of tables", "json", "toml", `{"u":[{"x":null}]}`, "u[0].x: null values cannot be represented in TOML"},
+		{"uint64", "yaml", "toml", "n: 18446744073709551615\n", "n: integer 18446744073709551615 is out of range for TOML"},
+		{"json uint64", "json", "toml", `{"n":12345678901234567890}`, "n: integer 12345678901234567890 is out of range for TOML"},
+		{"invalid syntax", "toml", "up", "a = \n", "toml:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, tt.input, "convert", "--from", tt.from, "--to", tt.to)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTOMLFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{1, "1.0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+		{math.NaN(), "nan"},
+	}
+	for _, tt := range tests {
+		if got := tomlFloat(tt.f); got != tt.want {
+			t.Errorf("tomlFloat(%v) = %q, want %q", tt.f, got, tt.want)
+		}
+	}
+}
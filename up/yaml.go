@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	up "github.com/uplang/go"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlCodec reads and writes YAML documents.
+type yamlCodec struct{}
+
+// Decode reads a single YAML document. Streams with several documents are
+// read with DecodeObjects.
+func (y yamlCodec) Decode(r io.Reader) (*up.Document, error) {
+	objs, err := y.DecodeObjects(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) != 1 {
+		return nil, fmt.Errorf("expected one document, found %d", len(objs))
+	}
+	return documentFromObject(objs[0])
+}
+
+// DecodeObjects reads every document of a YAML stream, keeping the key
+// order. Each document must be a mapping; empty documents are skipped.
+func (yamlCodec) DecodeObjects(r io.Reader) ([]object, error) {
+	dec := yaml.NewDecoder(r)
+
+	var objs []object
+	for i := 1; ; i++ {
+		var node yaml.Node
+		if err := dec.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		value, err := decodeYAMLNode(&node)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if value == nil {
+			continue
+		}
+
+		obj, ok := value.(object)
+		if !ok {
+			return nil, fmt.Errorf("document %d: top-level value must be a mapping", i)
+		}
+		objs = append(objs, obj)
+	}
+
+	if len(objs) == 0 {
+		return []object{{}}, nil
+	}
+	return objs, nil
+}
+
+// Encode writes a UP document as a YAML mapping, keeping typed scalars as
+// YAML integers, floats, booleans, nulls and timestamps.
+func (y yamlCodec) Encode(w io.Writer, doc *up.Document, pretty bool) error {
+	obj, err := documentToObject(doc)
+	if err != nil {
+		return err
+	}
+	return y.EncodeObject(w, obj, pretty)
+}
+
+// EncodeObject writes an ordered object as a YAML mapping.
+func (yamlCodec) EncodeObject(w io.Writer, obj object, _ bool) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(encodeYAMLNode(obj)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// decodeYAMLNode converts a YAML node into a format-neutral value, keeping
+// mapping order and resolving aliases and merge keys.
+func decodeYAMLNode(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return decodeYAMLNode(node.Content[0])
+	case yaml.AliasNode:
+		return decodeYAMLNode(node.Alias)
+	case yaml.MappingNode:
+		return decodeYAMLMapping(node)
+	case yaml.SequenceNode:
+		items := make([]any, 0, len(node.Content))
+		for _, child := range node.Content {
+			value, err := decodeYAMLNode(child)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case yaml.ScalarNode:
+		return decodeYAMLScalar(node)
+	default:
+		return nil, fmt.Errorf("line %d: unsupported YAML node", node.Line)
+	}
+}
+
+// decodeYAMLMapping converts a mapping node into an object. Keys from merge
+// keys (<<) are added only when the mapping does not define them itself.
+func decodeYAMLMapping(node *yaml.Node) (object, error) {
+	obj := object{}
+	index := make(map[string]int)
+	var merged []object
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		if keyNode.ShortTag() == "!!merge" {
+			sources, err := yamlMergeSources(valueNode)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, sources...)
+			continue
+		}
+
+		value, err := decodeYAMLNode(valueNode)
+		if err != nil {
+			return nil, err
+		}
+		if j, ok := index[keyNode.Value]; ok {
+			obj[j].Value = value
+			continue
+		}
+		index[keyNode.Value] = len(obj)
+		obj = append(obj, member{Key: keyNode.Value, Value: value})
+	}
+
+	for _, source := range merged {
+		for _, m := range source {
+			if _, ok := index[m.Key]; !ok {
+				index[m.Key] = len(obj)
+				obj = append(obj, m)
+			}
+		}
+	}
+	return obj, nil
+}
+
+// yamlMergeSources returns the mappings referenced by a merge key value.
+func yamlMergeSources(node *yaml.Node) ([]object, error) {
+	value, err := decodeYAMLNode(node)
+	if err != nil {
+		return nil, err
+	}
+
+	switch value := value.(type) {
+	case object:
+		return []object{value}, nil
+	case []any:
+		sources := make([]object, 0, len(value))
+		for _, item := range value {
+			obj, ok := item.(object)
+			if !ok {
+				return nil, fmt.Errorf("line %d: merge key value must be a mapping", node.Line)
+			}
+			sources = append(sources, obj)
+		}
+		return sources, nil
+	default:
+		return nil, fmt.Errorf("line %d: merge key value must be a mapping", node.Line)
+	}
+}
+
+// decodeYAMLScalar converts a scalar node according to its resolved tag.
+func decodeYAMLScalar(node *yaml.Node) (any, error) {
+	switch node.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "!!int":
+		var n int64
+		if err := node.Decode(&n); err != nil {
+			var u uint64
+			if uerr := node.Decode(&u); uerr != nil {
+				return nil, err
+			}
+			return u, nil
+		}
+		return n, nil
+	case "!!float":
+		var f float64
+		if err := node.Decode(&f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case "!!timestamp":
+		if validTimestamp(node.Value) {
+			return timestamp(node.Value), nil
+		}
+		var t time.Time
+		if err := node.Decode(&t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	default:
+		return node.Value, nil
+	}
+}
+
+// encodeYAMLNode converts a format-neutral value into a YAML node.
+func encodeYAMLNode(v any) *yaml.Node {
+	switch v := v.(type) {
+	case object:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, m := range v {
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: m.Key},
+				encodeYAMLNode(m.Value),
+			)
+		}
+		return node
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			node.Content = append(node.Content, encodeYAMLNode(item))
+		}
+		return node
+	case string:
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if strings.Contains(v, "\n") {
+			node.Style = yaml.LiteralStyle
+		}
+		return node
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}
+	case int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v, 10)}
+	case float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(v, 'g', -1, 64)}
+	case timestamp:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp", Value: string(v)}
+	case annotatedScalar:
+		return encodeYAMLNode(v.Text)
+	case uint64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatUint(v, 10)}
+	case time.Time:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp", Value: v.Format(time.RFC3339Nano)}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: v.String()}
+		}
+		if _, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: v.String()}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: v.String()}
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(v)}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertYAMLToUP(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{
+			name: "scalars",
+			yaml: "name: app\nport: 8080\nratio: 1.5\non: true\nnothing: null\n",
+			want: "name app\nport!int 8080\nratio!float 1.5\non!bool true\nnothing!null null\n",
+		},
+		{
+			name: "key order",
+			yaml: "z: 1\na:\n  y: x\n  b: w\n",
+			want: "z!int 1\na {\n  y x\n  b w\n}\n",
+		},
+		{
+			name: "timestamp",
+			yaml: "at: 2024-03-01T12:00:00Z\nday: 2024-03-01\n",
+			want: "at!ts 2024-03-01T12:00:00Z\nday!ts 2024-03-01\n",
+		},
+		{
+			name: "list",
+			yaml: "ports:\n  - 80\n  - 443\n",
+			want: "ports!int [\n  80\n  443\n]\n",
+		},
+		{
+			name: "literal block",
+			yaml: "text: |\n  one\n  two\n",
+			want: "text ```\none\ntwo\n\n```\n",
+		},
+		{
+			name: "anchors and merge keys",
+			yaml: "base: &base\n  host: a\n  port: 1\nprod:\n  <<: *base\n  port: 2\n",
+			want: "base {\n  host a\n  port!int 1\n}\nprod {\n  port!int 2\n  host a\n}\n",
+		},
+		{
+			name: "several documents",
+			yaml: "a: 1\n---\nb: 2\n",
+			want: "documents [\n  {\n    a!int 1\n  }\n  {\n    b!int 2\n  }\n]\n",
+		},
+		{
+			name: "empty",
+			yaml: "",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runApp(t, tt.yaml, "convert", "--from", "yaml", "--to", "up")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertYAMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		up   string
+	}{
+		{"scalars", "name app\nport!int 8080\nratio!float 1.5\non!bool true\nnothing!null null\n"},
+		{"timestamps", "at!ts 2024-03-01T12:00:00Z\nday!ts 2024-03-01\n"},
+		{"nested", "server {\n  host localhost\n  ports!int [\n    80\n    443\n  ]\n}\n"},
+		{"list of blocks", "users [\n  {\n    name a\n  }\n  {\n    name b\n  }\n]\n"},
+		{"multiline", "text ```\none\ntwo\n```\n"},
+		{"strings that look typed", "version 1.0\nflag yes\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlText, err := runApp(t, tt.up, "convert", "--from", "up", "--to", "yaml")
+			if err != nil {
+				t.Fatalf("up to yaml: %v", err)
+			}
+			back, err := runApp(t, yamlText, "convert", "--from", "yaml", "--to", "up")
+			if err != nil {
+				t.Fatalf("yaml to up: %v\n%s", err, yamlText)
+			}
+			if back != tt.up {
+				t.Errorf("round trip through:\n%s\ngot:\n%s\nwant:\n%s", yamlText, back, tt.up)
+			}
+		})
+	}
+}
+
+func TestConvertYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"not a mapping", "- a\n- b\n", "document 1: top-level value must be a mapping"},
+		{"second document", "a: 1\n---\n- b\n", "document 2: top-level value must be a mapping"},
+		{"invalid syntax", "a: [1\n", "yaml:"},
+		{"merge of scalar", "a:\n  <<: 1\n", "merge key value must be a mapping"},
+		{"mixed list", "l:\n  - 1\n  - a\n", "cannot represent a list mixing int, string items"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runApp(t, tt.yaml, "convert", "--from", "yaml", "--to", "up")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertYAMLSplit(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.up")
+	if _, err := runApp(t, "a: 1\n---\nb: 2\n", "convert", "--from", "yaml", "--to", "up", "--split", "-o", output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, want := range map[string]string{"out-1.up": "a!int 1\n", "out-2.up": "b!int 2\n"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", name, data, want)
+		}
+	}
+}
+
+func TestConvertJSONToYAMLNumbers(t *testing.T) {
+	got, err := runApp(t, `{"i":-7,"u":12345678901234567890,"f":1.5}`, "convert", "--from", "json", "--to", "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := runApp(t, got, "convert", "--from", "yaml", "--to", "up")
+	if err != nil {
+		t.Fatalf("yaml to up: %v\n%s", err, got)
+	}
+	want := "i!int -7\nu!int 12345678901234567890\nf!float 1.5\n"
+	if back != want {
+		t.Errorf("round trip through:\n%s\ngot:\n%s\nwant:\n%s", got, back, want)
+	}
+}